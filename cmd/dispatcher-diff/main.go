@@ -0,0 +1,59 @@
+// Command dispatcher-diff compares two API snapshots of a GoDispatcher service and exits
+// with status 1 when the new one has breaking changes.
+//
+//	dispatcher-diff orders-openapi.json http://orders-canary:1306/help
+//	dispatcher-diff -breaking old.yaml new.yaml
+//
+// Each snapshot is a saved /help?format=openapi (JSON or YAML) or /help?format=json file,
+// or a service URL. URLs without a format are asked for the OpenAPI export.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/godispatcher/dispatcher/codegen"
+)
+
+func main() {
+	breakingOnly := flag.Bool("breaking", false, "print breaking changes only")
+	format := flag.String("format", "openapi", "format requested from URLs without one: openapi or json")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: dispatcher-diff [flags] old new")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	changes, err := diff(flag.Arg(0), flag.Arg(1), *format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dispatcher-diff:", err)
+		os.Exit(2)
+	}
+	breaking := codegen.Breaking(changes)
+	if *breakingOnly {
+		changes = breaking
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if len(breaking) > 0 {
+		os.Exit(1)
+	}
+}
+
+func diff(oldSource, newSource, format string) ([]codegen.Change, error) {
+	old, err := codegen.LoadSource(oldSource, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", oldSource, err)
+	}
+	new, err := codegen.LoadSource(newSource, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", newSource, err)
+	}
+	return codegen.Diff(old, new), nil
+}
@@ -0,0 +1,58 @@
+// Command dispatcher-gen generates typed clients for a GoDispatcher service.
+//
+//	dispatcher-gen -source http://orders:1306/help?format=json -package orders -out orders/client.go
+//	dispatcher-gen -source orders-openapi.yaml -package orders
+//	dispatcher-gen -source http://orders:1306/help -lang ts -out src/orders.ts
+//
+// The source is the JSON help of a running service, or a saved OpenAPI document
+// (/help?format=openapi or openapi-yaml).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/godispatcher/dispatcher/codegen"
+)
+
+func main() {
+	source := flag.String("source", "", "URL of /help or /help?format=openapi, or a saved OpenAPI/JSON help file")
+	lang := flag.String("lang", "go", "output language: go or ts")
+	pkg := flag.String("package", "client", "package name of the generated Go code")
+	out := flag.String("out", "", "output file, stdout when empty")
+	flag.Parse()
+
+	if err := run(*source, *lang, *pkg, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "dispatcher-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(source, lang, pkg, out string) error {
+	if source == "" {
+		return fmt.Errorf("-source is required")
+	}
+	api, err := codegen.LoadSource(source, "json")
+	if err != nil {
+		return err
+	}
+
+	var code []byte
+	switch lang {
+	case "go":
+		code, err = codegen.GenerateGo(api, pkg)
+	case "ts":
+		code = codegen.GenerateTypeScript(api)
+	default:
+		return fmt.Errorf("unsupported language %q", lang)
+	}
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(code)
+		return err
+	}
+	return os.WriteFile(out, code, 0o644)
+}
@@ -0,0 +1,96 @@
+// Command dispatcher-mock serves the transactions declared by a schema file with generated
+// responses, so clients can be built before the service exists.
+//
+//	dispatcher-mock -source orders-openapi.yaml -port 9000 -latency 200ms -error-rate 0.05
+//	dispatcher-mock -source http://orders:1306/help
+//
+// The source is a saved /help?format=openapi (JSON or YAML) or /help?format=json file, or
+// a service URL. Forms are checked against the declared request types, and responses come
+// from the documented examples or are built from the declared response types.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/godispatcher/dispatcher/codegen"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/server"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+func main() {
+	source := flag.String("source", "", "schema file or service URL")
+	port := flag.String("port", "9000", "HTTP port")
+	streamPort := flag.String("stream-port", "", "NDJSON stream port, the stream server is off when empty")
+	latency := flag.Duration("latency", 0, "latency added to every response")
+	jitter := flag.Duration("jitter", 0, "random extra latency of up to this much")
+	errorRate := flag.Float64("error-rate", 0, "share of requests, 0 to 1, answered with an Error document")
+	errorMessage := flag.String("error", "", "message of injected errors")
+	flag.Parse()
+
+	if *source == "" {
+		fmt.Fprintln(os.Stderr, "dispatcher-mock: -source is required")
+		os.Exit(2)
+	}
+	api, err := codegen.LoadSource(*source, "openapi")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dispatcher-mock:", err)
+		os.Exit(1)
+	}
+	declare(api)
+
+	register := department.NewRegisteryDispatcher(*port)
+	register.StreamPort = *streamPort
+	register.Mock = &model.MockOptions{Enabled: true, Latency: *latency, Jitter: *jitter, ErrorRate: *errorRate, Error: *errorMessage}
+	server.ServJsonApiDoc()
+	fmt.Fprintf(os.Stderr, "dispatcher-mock: %d transactions on :%s\n", len(api.Operations), *port)
+	// Run serves the stream API as well when a stream port is set, and stops on SIGINT or SIGTERM
+	if err := server.Run(context.Background(), register); err != nil {
+		fmt.Fprintln(os.Stderr, "dispatcher-mock:", err)
+		os.Exit(1)
+	}
+}
+
+// declare registers every operation of api as a transaction.
+func declare(api *codegen.API) {
+	for _, op := range api.Operations {
+		department.DispatcherHolder.Add(op.Department, transaction.TransactionBucketItem{
+			Name:        op.Transaction,
+			Transaction: declared{api: api, op: op},
+		})
+	}
+}
+
+// declared is a transaction known only from the schema. It is always wrapped in a
+// department.MockServer, which calls ValidateForm and SampleResponse.
+type declared struct {
+	api *codegen.API
+	op  codegen.Operation
+}
+
+func (d declared) GetRequest() any  { return d.api.Sample(d.op.Request) }
+func (d declared) GetResponse() any { return d.api.Sample(d.op.Response) }
+
+func (d declared) GetOptions() model.ServerOption {
+	doc := model.TransactionDoc{Summary: d.op.Summary, Description: d.op.Description, Deprecated: d.op.Deprecated}
+	for _, example := range d.op.Examples {
+		doc.Examples = append(doc.Examples, model.TransactionExample{Name: example.Name, Request: example.Request, Response: example.Response})
+	}
+	return model.ServerOption{Doc: doc}
+}
+
+func (d declared) Init(document model.Document) model.Document {
+	return department.MockServer{Transaction: d}.Init(document)
+}
+
+func (d declared) ValidateForm(form interface{}) error {
+	return d.api.Validate(d.op.Request, form)
+}
+
+func (d declared) SampleResponse() interface{} {
+	return d.api.Sample(d.op.Response)
+}
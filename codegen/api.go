@@ -0,0 +1,148 @@
+// Package codegen turns the API description served by ApiDocServer into typed client code.
+// Both the OpenAPI export (/help?format=openapi) and the JSON help (/help?format=json) can
+// be loaded into an API, which the Go and TypeScript generators render and Diff compares.
+package codegen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Kind is the JSON type of a TypeRef.
+type Kind string
+
+const (
+	KindAny     Kind = "any"
+	KindString  Kind = "string"
+	KindInteger Kind = "integer"
+	KindNumber  Kind = "number"
+	KindBoolean Kind = "boolean"
+	KindTime    Kind = "time" // a date-time string
+	KindArray   Kind = "array"
+	KindMap     Kind = "map"
+	KindObject  Kind = "object" // a named Type
+)
+
+// TypeRef is the type of a field, request or response.
+type TypeRef struct {
+	Kind Kind
+	Elem *TypeRef // element of arrays and maps
+	Name string   // Type name of objects
+	Enum []string // allowed values of strings
+}
+
+// Type is a named object type.
+type Type struct {
+	Name        string
+	Description string
+	Fields      []Field
+}
+
+// Field is a property of a Type.
+type Field struct {
+	JsonName    string
+	Type        TypeRef
+	Required    bool
+	Description string
+	Deprecated  bool
+	Example     interface{} // decoded JSON, nil when none is documented
+}
+
+// Operation is a transaction of a department.
+type Operation struct {
+	Department  string
+	Transaction string
+	Summary     string
+	Description string
+	Deprecated  bool
+	Request     TypeRef
+	Response    TypeRef
+	Examples    []Example
+}
+
+// Example is a documented request and response pair of an Operation.
+type Example struct {
+	Name     string
+	Request  interface{}
+	Response interface{}
+}
+
+// API is the language independent description rendered by the generators.
+type API struct {
+	Title      string
+	Operations []Operation
+	Types      map[string]*Type
+}
+
+// Load detects whether data is an OpenAPI document or the JSON help and loads it.
+// YAML is accepted for OpenAPI documents.
+func Load(data []byte) (*API, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var probe struct {
+			OpenAPI     string          `json:"openapi"`
+			Departments json.RawMessage `json:"departments"`
+		}
+		if err := json.Unmarshal(trimmed, &probe); err != nil {
+			return nil, err
+		}
+		if probe.OpenAPI == "" {
+			if probe.Departments == nil {
+				return nil, errors.New("neither an OpenAPI document nor a /help?format=json listing")
+			}
+			return LoadHelp(trimmed)
+		}
+	}
+	return LoadOpenAPI(trimmed)
+}
+
+// SortedTypes returns the types ordered by name, for stable output.
+func (a *API) SortedTypes() []*Type {
+	types := make([]*Type, 0, len(a.Types))
+	for _, t := range a.Types {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+// addType registers t under a unique name derived from name and returns that name.
+func (a *API) addType(name string, t *Type) string {
+	unique := name
+	for i := 2; a.Types[unique] != nil || reservedTypeNames[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	t.Name = unique
+	a.Types[unique] = t
+	return unique
+}
+
+// ExportedName converts a JSON name such as "created_by" or "user_id" into an exported
+// identifier ("CreatedBy", "UserID").
+func ExportedName(name string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if initialisms[strings.ToLower(word)] {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	out := b.String()
+	if out == "" || unicode.IsDigit([]rune(out)[0]) {
+		out = "X" + out
+	}
+	return out
+}
+
+// reservedTypeNames are declared by the generated clients themselves.
+var reservedTypeNames = map[string]bool{"Client": true, "ClientOptions": true, "Security": true, "DispatcherDocument": true, "DispatcherError": true}
+
+var initialisms = map[string]bool{"id": true, "ip": true, "url": true, "uri": true, "api": true, "http": true, "json": true, "uuid": true}
@@ -0,0 +1,207 @@
+package codegen
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Change is a difference between two API descriptions.
+type Change struct {
+	Breaking  bool
+	Operation string // "Department.transaction"
+	Path      string // "request.items[].sku"; empty for the transaction itself
+	Message   string
+}
+
+func (c Change) String() string {
+	level := "non-breaking"
+	if c.Breaking {
+		level = "BREAKING"
+	}
+	location := c.Operation
+	if c.Path != "" {
+		location += " " + c.Path
+	}
+	return fmt.Sprintf("%s: %s: %s", level, location, c.Message)
+}
+
+// Breaking returns the breaking changes of changes.
+func Breaking(changes []Change) []Change {
+	var breaking []Change
+	for _, c := range changes {
+		if c.Breaking {
+			breaking = append(breaking, c)
+		}
+	}
+	return breaking
+}
+
+// Diff compares the API of a deployed build with the API of a new one. Types are compared
+// by structure, so renamed Go types do not matter. A change is breaking when a caller
+// written against old can fail against new: a removed transaction, a removed or renamed
+// field, an incompatible type, a new required request field or a response field that
+// may now be left out.
+func Diff(old, new *API) []Change {
+	d := differ{seen: map[string]bool{}}
+	operations := map[string]Operation{}
+	for _, op := range new.Operations {
+		operations[op.Department+"."+op.Transaction] = op
+	}
+	known := map[string]bool{}
+	for _, o := range old.Operations {
+		d.operation = o.Department + "." + o.Transaction
+		known[d.operation] = true
+		n, ok := operations[d.operation]
+		if !ok {
+			d.add(true, "", "transaction removed")
+			continue
+		}
+		if !o.Deprecated && n.Deprecated {
+			d.add(false, "", "transaction deprecated")
+		}
+		d.compare("request", o.Request, n.Request, old, new, true)
+		d.compare("response", o.Response, n.Response, old, new, false)
+	}
+	for _, n := range new.Operations {
+		if d.operation = n.Department + "." + n.Transaction; !known[d.operation] {
+			d.add(false, "", "transaction added")
+		}
+	}
+	return d.changes
+}
+
+type differ struct {
+	operation string
+	changes   []Change
+	seen      map[string]bool // compared type pairs, which ends recursion
+}
+
+func (d *differ) add(breaking bool, path, format string, args ...interface{}) {
+	d.changes = append(d.changes, Change{Breaking: breaking, Operation: d.operation, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// compare reports the differences of a request (input) or response value.
+// Requests may accept more than before, responses may promise more than before.
+func (d *differ) compare(path string, o, n TypeRef, oldAPI, newAPI *API, input bool) {
+	if o.Kind != n.Kind {
+		compatible := wider(n, o)
+		if !input {
+			compatible = wider(o, n)
+		}
+		d.add(!compatible, path, "type changed from %s to %s", describe(o), describe(n))
+		return
+	}
+	switch o.Kind {
+	case KindArray:
+		d.compare(path+"[]", *o.Elem, *n.Elem, oldAPI, newAPI, input)
+	case KindMap:
+		d.compare(path+"{}", *o.Elem, *n.Elem, oldAPI, newAPI, input)
+	case KindString:
+		d.compareEnum(path, o.Enum, n.Enum, input)
+	case KindObject:
+		key := fmt.Sprint(d.operation, input, o.Name, "\x00", n.Name)
+		if d.seen[key] {
+			return
+		}
+		d.seen[key] = true
+		ot, nt := oldAPI.Types[o.Name], newAPI.Types[n.Name]
+		if ot == nil || nt == nil {
+			return
+		}
+		d.compareFields(path, ot, nt, oldAPI, newAPI, input)
+	}
+}
+
+func (d *differ) compareEnum(path string, o, n []string, input bool) {
+	switch {
+	case len(o) == 0 && len(n) == 0:
+	case len(n) == 0:
+		d.add(!input, path, "enum restriction removed")
+	case len(o) == 0:
+		d.add(input, path, "restricted to %v", n)
+	default:
+		for _, value := range o {
+			if !slices.Contains(n, value) {
+				d.add(input, path, "enum value %q removed", value)
+			}
+		}
+		for _, value := range n {
+			if !slices.Contains(o, value) {
+				d.add(!input, path, "enum value %q added", value)
+			}
+		}
+	}
+}
+
+func (d *differ) compareFields(path string, ot, nt *Type, oldAPI, newAPI *API, input bool) {
+	var removed, added []Field
+	for _, f := range ot.Fields {
+		if _, ok := fieldByName(nt, f.JsonName); !ok {
+			removed = append(removed, f)
+		}
+	}
+	for _, f := range nt.Fields {
+		if _, ok := fieldByName(ot, f.JsonName); !ok {
+			added = append(added, f)
+		}
+	}
+	if len(removed) == 1 && len(added) == 1 && describe(removed[0].Type) == describe(added[0].Type) {
+		d.add(true, path+"."+removed[0].JsonName, "field renamed to %q", added[0].JsonName)
+		removed, added = nil, nil
+	}
+	for _, f := range removed {
+		d.add(true, path+"."+f.JsonName, "field removed")
+	}
+	for _, f := range added {
+		if input && f.Required {
+			d.add(true, path+"."+f.JsonName, "required field added")
+		} else {
+			d.add(false, path+"."+f.JsonName, "field added")
+		}
+	}
+
+	for _, of := range ot.Fields {
+		nf, ok := fieldByName(nt, of.JsonName)
+		if !ok {
+			continue
+		}
+		fieldPath := path + "." + of.JsonName
+		switch {
+		case !of.Required && nf.Required:
+			d.add(input, fieldPath, "field made required")
+		case of.Required && !nf.Required:
+			d.add(!input, fieldPath, "field made optional")
+		}
+		if !of.Deprecated && nf.Deprecated {
+			d.add(false, fieldPath, "field deprecated")
+		}
+		d.compare(fieldPath, of.Type, nf.Type, oldAPI, newAPI, input)
+	}
+}
+
+func fieldByName(t *Type, jsonName string) (Field, bool) {
+	for _, f := range t.Fields {
+		if f.JsonName == jsonName {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// wider reports whether every value of b is also a value of a.
+func wider(a, b TypeRef) bool {
+	return a.Kind == KindAny ||
+		(a.Kind == KindString && b.Kind == KindTime) ||
+		(a.Kind == KindNumber && b.Kind == KindInteger)
+}
+
+func describe(ref TypeRef) string {
+	switch ref.Kind {
+	case KindArray:
+		return "array of " + describe(*ref.Elem)
+	case KindMap:
+		return "map of " + describe(*ref.Elem)
+	default:
+		return string(ref.Kind)
+	}
+}
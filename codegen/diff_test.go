@@ -0,0 +1,91 @@
+package codegen_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/godispatcher/dispatcher/codegen"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+type orderV1 struct {
+	Sku      string `json:"sku" require:"true"`
+	Quantity int    `json:"quantity"`
+	Note     string `json:"note,omitempty"`
+	Channel  string `json:"channel,omitempty" enum:"web,store"`
+}
+
+type receiptV1 struct {
+	ID    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+type orderV2 struct {
+	Sku      string  `json:"sku" require:"true"`
+	Quantity float64 `json:"quantity" require:"true"`
+	Comment  string  `json:"comment,omitempty"`
+	Channel  string  `json:"channel,omitempty" enum:"web"`
+}
+
+type receiptV2 struct {
+	ID    string   `json:"id"`
+	Total float64  `json:"total"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// snapshot loads the API description of the transactions added by register.
+func snapshot(t *testing.T, query string, register func()) *codegen.API {
+	department.DispatcherHolder = nil
+	register()
+	api, err := codegen.Load(helpBody(t, query))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+func TestDiff(t *testing.T) {
+	defer func() { department.DispatcherHolder = nil }()
+	v1 := func() {
+		department.DispatcherHolder.Add("Orders", transaction.TransactionBucketItem{Name: "create", Transaction: stubServer{request: orderV1{}, response: receiptV1{}}})
+		department.DispatcherHolder.Add("Orders", transaction.TransactionBucketItem{Name: "cancel", Transaction: stubServer{request: orderV1{}, response: receiptV1{}}})
+	}
+	v2 := func() {
+		department.DispatcherHolder.Add("Orders", transaction.TransactionBucketItem{Name: "create", Transaction: stubServer{request: orderV2{}, response: receiptV2{}}})
+		department.DispatcherHolder.Add("Orders", transaction.TransactionBucketItem{Name: "list", Transaction: stubServer{request: orderV1{}, response: []receiptV1{}}})
+	}
+
+	changes := codegen.Diff(snapshot(t, "format=openapi", v1), snapshot(t, "format=openapi", v2))
+	got := make([]string, len(changes))
+	for i, c := range changes {
+		got[i] = c.String()
+	}
+	sort.Strings(got)
+	want := []string{
+		`BREAKING: Orders.cancel: transaction removed`,
+		`BREAKING: Orders.create request.channel: enum value "store" removed`,
+		`BREAKING: Orders.create request.note: field renamed to "comment"`,
+		`BREAKING: Orders.create request.quantity: field made required`,
+		`BREAKING: Orders.create response.total: type changed from integer to number`,
+		`non-breaking: Orders.create request.quantity: type changed from integer to number`,
+		`non-breaking: Orders.create response.tags: field added`,
+		`non-breaking: Orders.list: transaction added`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d changes want %d:\n%v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %s\nwant %s", got[i], want[i])
+		}
+	}
+
+	if changes := codegen.Diff(snapshot(t, "format=json", v1), snapshot(t, "format=json", v1)); len(changes) != 0 {
+		t.Errorf("identical snapshots must not differ: %v", changes)
+	}
+	breaking := codegen.Breaking(codegen.Diff(snapshot(t, "format=json", v1), snapshot(t, "format=json", v2)))
+	if len(breaking) < 4 {
+		t.Errorf("json help snapshots miss breaking changes: %v", breaking)
+	}
+}
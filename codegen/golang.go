@@ -0,0 +1,159 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// GenerateGo renders a Go package with the API types and a Client that has one typed
+// method per transaction. The client sends documents through a coordinator.Transport,
+// so it works over CallHTTP as well as a StreamClientPool.
+func GenerateGo(api *API, pkg string) ([]byte, error) {
+	g := goWriter{api: api}
+	body := &bytes.Buffer{}
+	g.client(body)
+	for _, t := range api.SortedTypes() {
+		g.typeDecl(body, t)
+	}
+
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "// Code generated by dispatcher-gen. DO NOT EDIT.\n\n")
+	if api.Title != "" {
+		fmt.Fprintf(out, "// Package %s is a typed client for %s.\n", pkg, api.Title)
+	}
+	fmt.Fprintf(out, "package %s\n\nimport (\n", pkg)
+	if g.usesTime {
+		fmt.Fprintf(out, "\t\"time\"\n\n")
+	}
+	fmt.Fprintf(out, "\t\"github.com/godispatcher/dispatcher/coordinator\"\n\t\"github.com/godispatcher/dispatcher/model\"\n)\n\n")
+	out.Write(body.Bytes())
+
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		return out.Bytes(), fmt.Errorf("generated code does not compile: %w", err)
+	}
+	return formatted, nil
+}
+
+type goWriter struct {
+	api      *API
+	usesTime bool
+}
+
+func (g *goWriter) client(w *bytes.Buffer) {
+	fmt.Fprintf(w, `// Client calls the transactions of the service, e.g.
+// NewClient(coordinator.HTTPTransport("http://orders:1306")) or
+// NewClient(coordinator.StreamTransport(pool)).
+type Client struct {
+	Transport coordinator.Transport
+	// Security is sent with every call. The verify code of the current request is used when it has none.
+	Security *model.Security
+}
+
+func NewClient(transport coordinator.Transport) *Client {
+	return &Client{Transport: transport}
+}
+
+func (c *Client) document(departmentName, transactionName string) model.Document {
+	document := model.Document{Department: departmentName, Transaction: transactionName}
+	if c.Security != nil {
+		security := *c.Security
+		document.Security = &security
+	}
+	return document
+}
+`)
+	for _, op := range g.api.Operations {
+		request, response := g.goType(op.Request), g.goType(op.Response)
+		fmt.Fprintln(w)
+		g.comment(w, ExportedName(op.Department)+ExportedName(op.Transaction), op.Summary, op.Description, op.Deprecated, "")
+		fmt.Fprintf(w, "func (c *Client) %s%s(request %s) (%s, error) {\n", ExportedName(op.Department), ExportedName(op.Transaction), request, response)
+		fmt.Fprintf(w, "\treturn coordinator.Invoke[%s, %s](c.Transport, c.document(%q, %q), request)\n}\n", request, response, op.Department, op.Transaction)
+	}
+}
+
+func (g *goWriter) typeDecl(w *bytes.Buffer, t *Type) {
+	fmt.Fprintln(w)
+	g.comment(w, t.Name, "", t.Description, false, "")
+	fmt.Fprintf(w, "type %s struct {\n", t.Name)
+	used := map[string]bool{}
+	for _, f := range t.Fields {
+		name := ExportedName(f.JsonName)
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s%d", ExportedName(f.JsonName), i)
+		}
+		used[name] = true
+		description := f.Description
+		if len(f.Type.Enum) > 0 {
+			description = strings.TrimSpace(description + "\nOne of: " + strings.Join(f.Type.Enum, ", "))
+		}
+		g.comment(w, name, "", description, f.Deprecated, "\t")
+		fieldType := g.goType(f.Type)
+		if f.Type.Kind == KindObject {
+			// pointers keep recursive types finite and let optional objects be left out
+			fieldType = "*" + fieldType
+		}
+		tag := f.JsonName
+		if !f.Required {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(w, "\t%s %s `json:%q`\n", name, fieldType, tag)
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+func (g *goWriter) goType(ref TypeRef) string {
+	switch ref.Kind {
+	case KindString:
+		return "string"
+	case KindInteger:
+		return "int64"
+	case KindNumber:
+		return "float64"
+	case KindBoolean:
+		return "bool"
+	case KindTime:
+		g.usesTime = true
+		return "time.Time"
+	case KindArray:
+		return "[]" + g.elemType(*ref.Elem)
+	case KindMap:
+		return "map[string]" + g.elemType(*ref.Elem)
+	case KindObject:
+		return ref.Name
+	default:
+		return "interface{}"
+	}
+}
+
+func (g *goWriter) elemType(ref TypeRef) string {
+	if ref.Kind == KindObject {
+		return "*" + ref.Name
+	}
+	return g.goType(ref)
+}
+
+func (g *goWriter) comment(w *bytes.Buffer, name, summary, description string, deprecated bool, indent string) {
+	var lines []string
+	if summary != "" {
+		lines = append(lines, name+": "+summary)
+	}
+	if description != "" {
+		lines = append(lines, strings.Split(description, "\n")...)
+	}
+	if deprecated {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "Deprecated: marked as deprecated by the service.")
+	}
+	for _, line := range lines {
+		if line = strings.TrimRight(line, " "); line == "" {
+			fmt.Fprintf(w, "%s//\n", indent)
+			continue
+		}
+		fmt.Fprintf(w, "%s// %s\n", indent, line)
+	}
+}
@@ -0,0 +1,163 @@
+package codegen_test
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/codegen"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/server"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+type stubServer struct {
+	model.ServerInterface
+	request  any
+	response any
+	options  model.ServerOption
+}
+
+func (s stubServer) Init(document model.Document) model.Document { return model.Document{} }
+func (s stubServer) GetRequest() any                             { return s.request }
+func (s stubServer) GetResponse() any                            { return s.response }
+func (s stubServer) GetOptions() model.ServerOption              { return s.options }
+
+type loginRequest struct {
+	Email    string `json:"email" require:"true" description:"Account e-mail address" example:"jane@example.com"`
+	Password string `json:"password" require:"true" sensitive:"true"`
+	Remember int    `json:"remember,omitempty" description:"Session length in days" example:"30"`
+	Legacy   string `json:"legacy,omitempty" deprecated:"true"`
+}
+
+type schemaLevel int
+
+func (schemaLevel) MarshalJSON() ([]byte, error) { return json.Marshal("debug") }
+
+type schemaAudit struct {
+	CreatedBy string `json:"created_by"`
+	Name      string
+}
+
+type schemaOwner struct {
+	Name string
+}
+
+type schemaItem struct {
+	schemaAudit
+	*schemaOwner
+	ID       int64              `json:"id,string" require:"true"`
+	Email    string             `json:"email" format:"email" isEmpty:"false"`
+	At       time.Time          `json:"at"`
+	Level    schemaLevel        `json:"level"`
+	Tags     map[string]float64 `json:"tags,omitempty"`
+	Raw      []byte             `json:"raw,omitempty"`
+	Parent   *schemaItem        `json:"parent,omitempty"`
+	Audit    schemaAudit        `json:"audit,string"`
+	Internal string             `json:"-"`
+	NoTag    bool
+}
+
+type openAPINode struct {
+	Name     string         `json:"name" require:"true" isEmpty:"false"`
+	Children []*openAPINode `json:"children,omitempty"`
+	Hidden   string         `json:"-"`
+}
+
+// registerAPI registers a documented login and a transaction with nested and recursive types.
+func registerAPI() {
+	department.DispatcherHolder = nil
+	department.DispatcherHolder.Add("Auth", transaction.TransactionBucketItem{
+		Name: "login",
+		Transaction: stubServer{request: loginRequest{}, response: map[string]string{}, options: model.ServerOption{Doc: model.TransactionDoc{
+			Summary: "Sign in",
+			Examples: []model.TransactionExample{
+				{Name: "remembered", Request: loginRequest{Email: "jane@example.com", Remember: 30}, Response: map[string]string{"token": "abc"}},
+			},
+		}}},
+	})
+	department.DispatcherHolder.Add("Shop", transaction.TransactionBucketItem{
+		Name:        "item",
+		Transaction: stubServer{request: schemaItem{}, response: openAPINode{}},
+	})
+}
+
+// helpBody returns the API description served by ApiDocServer for query.
+func helpBody(t *testing.T, query string) []byte {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	server.ApiDocServer{Title: "Shop API"}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help?"+query, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("%s: unexpected status %d", query, rr.Code)
+	}
+	return rr.Body.Bytes()
+}
+
+func TestGenerateGo(t *testing.T) {
+	registerAPI()
+	defer func() { department.DispatcherHolder = nil }()
+
+	cases := map[string][]string{
+		"format=openapi": {
+			"func (c *Client) AuthLogin(request LoginRequest) (map[string]string, error)",
+			"func (c *Client) ShopItem(request SchemaItem) (OpenAPINode, error)",
+			"Children []*OpenAPINode `json:\"children,omitempty\"`",
+			"ID string `json:\"id\"`",
+			"At time.Time `json:\"at,omitempty\"`",
+			"// Deprecated:",
+		},
+		"format=openapi-yaml": {"func (c *Client) ShopItem(request SchemaItem) (OpenAPINode, error)"},
+		"format=json": {
+			"func (c *Client) AuthLogin(request AuthLoginRequest) (interface{}, error)",
+			"Email string `json:\"email\"`",
+			"Remember int64 `json:\"remember,omitempty\"`",
+			"Children []map[string]interface{} `json:\"children,omitempty\"`",
+		},
+	}
+	// the source importer resolves the imports of the client through the go command
+	_, noGo := exec.LookPath("go")
+	fset := token.NewFileSet()
+	imports := importer.ForCompiler(fset, "source", nil)
+	for query, wants := range cases {
+		api, err := codegen.Load(helpBody(t, query))
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		code, err := codegen.GenerateGo(api, "shop")
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", query, err, code)
+		}
+		normalized := strings.Join(strings.Fields(string(code)), " ")
+		for _, want := range wants {
+			if !strings.Contains(normalized, strings.Join(strings.Fields(want), " ")) {
+				t.Errorf("%s: generated code misses %q\n%s", query, want, code)
+			}
+		}
+
+		file, err := parser.ParseFile(fset, "client.go", code, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if testing.Short() || noGo != nil {
+			continue
+		}
+		config := types.Config{Importer: imports}
+		if _, err := config.Check("shop", fset, []*ast.File{file}, nil); err != nil {
+			t.Errorf("%s: generated client does not compile: %v\n%s", query, err, code)
+		}
+	}
+
+	if _, err := codegen.Load([]byte(`{"foo":1}`)); err == nil {
+		t.Errorf("expected an error for an unknown source")
+	}
+}
@@ -0,0 +1,168 @@
+package codegen
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type helpList struct {
+	Departments []struct {
+		Name         string `json:"name"`
+		Transactions []struct {
+			Name            string      `json:"name"`
+			Summary         string      `json:"summary"`
+			Description     string      `json:"description"`
+			Deprecated      bool        `json:"deprecated"`
+			ProcedureFields []helpField `json:"procedure_fields"`
+			OutputFields    []helpField `json:"output_fields"`
+			Examples        []struct {
+				Name     string      `json:"name"`
+				Request  interface{} `json:"request"`
+				Response interface{} `json:"response"`
+			} `json:"examples"`
+		} `json:"transactions"`
+	} `json:"departments"`
+}
+
+type helpField struct {
+	Path        string   `json:"path"`
+	Type        string   `json:"type"`
+	Required    bool     `json:"required"`
+	Description string   `json:"description"`
+	Example     string   `json:"example"`
+	Deprecated  bool     `json:"deprecated"`
+	Enum        []string `json:"enum"`
+}
+
+// helpNode is a field of the JSON help with the fields nested below it.
+type helpNode struct {
+	field    helpField
+	mapValue bool // children describe map values ("{}" in paths) rather than array elements
+	children []*helpNode
+	index    map[string]*helpNode
+}
+
+// LoadHelp loads the JSON help (/help?format=json). Types are rebuilt from the
+// procedure_fields and output_fields listings, so the help must not be requested with short=1.
+func LoadHelp(data []byte) (*API, error) {
+	var list helpList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	api := &API{Types: map[string]*Type{}}
+	for _, dep := range list.Departments {
+		for _, tr := range dep.Transactions {
+			hint := ExportedName(dep.Name) + ExportedName(tr.Name)
+			var examples []Example
+			for _, example := range tr.Examples {
+				examples = append(examples, Example{Name: example.Name, Request: example.Request, Response: example.Response})
+			}
+			api.Operations = append(api.Operations, Operation{
+				Department:  dep.Name,
+				Transaction: tr.Name,
+				Summary:     tr.Summary,
+				Description: tr.Description,
+				Deprecated:  tr.Deprecated,
+				Request:     api.helpRoot(tr.ProcedureFields, hint+"Request"),
+				Response:    api.helpRoot(tr.OutputFields, hint+"Response"),
+				Examples:    examples,
+			})
+		}
+	}
+	return api, nil
+}
+
+func (a *API) helpRoot(fields []helpField, name string) TypeRef {
+	if len(fields) == 0 {
+		return TypeRef{Kind: KindAny}
+	}
+	root := &helpNode{index: map[string]*helpNode{}}
+	for _, field := range fields {
+		node := root
+		segments := strings.Split(field.Path, ".")
+		for _, segment := range segments[:len(segments)-1] {
+			if node = node.index[strings.TrimRight(segment, "[]{}")]; node == nil {
+				break
+			}
+			node.mapValue = strings.HasSuffix(segment, "{}")
+		}
+		if node == nil {
+			// the parent is not listed, e.g. a truncated listing
+			continue
+		}
+		child := &helpNode{field: field, index: map[string]*helpNode{}}
+		node.children = append(node.children, child)
+		node.index[segments[len(segments)-1]] = child
+	}
+	return a.helpObject(root, name)
+}
+
+func (a *API) helpObject(node *helpNode, name string) TypeRef {
+	t := &Type{}
+	name = a.addType(name, t)
+	for _, child := range node.children {
+		fieldType := a.helpType(child, name+ExportedName(child.field.Path[strings.LastIndex(child.field.Path, ".")+1:]))
+		var example interface{}
+		if child.field.Example != "" {
+			// examples of non-string fields are JSON
+			example = child.field.Example
+			var decoded interface{}
+			if fieldType.Kind != KindString && fieldType.Kind != KindTime && json.Unmarshal([]byte(child.field.Example), &decoded) == nil {
+				example = decoded
+			}
+		}
+		t.Fields = append(t.Fields, Field{
+			JsonName:    child.field.Path[strings.LastIndex(child.field.Path, ".")+1:],
+			Type:        fieldType,
+			Required:    child.field.Required,
+			Description: child.field.Description,
+			Deprecated:  child.field.Deprecated,
+			Example:     example,
+		})
+	}
+	return TypeRef{Kind: KindObject, Name: name}
+}
+
+// helpType parses types such as "integer", "string(date-time)" or "object[]".
+func (a *API) helpType(node *helpNode, hint string) TypeRef {
+	base := node.field.Type
+	arrays := 0
+	for strings.HasSuffix(base, "[]") {
+		base = strings.TrimSuffix(base, "[]")
+		arrays++
+	}
+	base, format, _ := strings.Cut(base, "(")
+	format = strings.TrimSuffix(format, ")")
+
+	var ref TypeRef
+	switch base {
+	case "string":
+		ref = TypeRef{Kind: KindString, Enum: node.field.Enum}
+		if format == "date-time" {
+			ref = TypeRef{Kind: KindTime}
+		}
+	case "integer":
+		ref = TypeRef{Kind: KindInteger}
+	case "number":
+		ref = TypeRef{Kind: KindNumber}
+	case "boolean":
+		ref = TypeRef{Kind: KindBoolean}
+	case "object":
+		switch {
+		case len(node.children) == 0:
+			ref = TypeRef{Kind: KindMap, Elem: &TypeRef{Kind: KindAny}}
+		case node.mapValue:
+			elem := a.helpObject(node, hint+"Value")
+			ref = TypeRef{Kind: KindMap, Elem: &elem}
+		default:
+			ref = a.helpObject(node, hint)
+		}
+	default:
+		ref = TypeRef{Kind: KindAny}
+	}
+	for i := 0; i < arrays; i++ {
+		elem := ref
+		ref = TypeRef{Kind: KindArray, Elem: &elem}
+	}
+	return ref
+}
@@ -0,0 +1,225 @@
+package codegen
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const openAPIRefPrefix = "#/components/schemas/"
+
+// LoadOpenAPI loads an OpenAPI 3.1 document as produced by /help?format=openapi, in JSON or YAML.
+func LoadOpenAPI(data []byte) (*API, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if _, ok := doc["openapi"]; !ok {
+		return nil, errors.New("not an OpenAPI document")
+	}
+	l := openAPILoader{
+		api:     &API{Title: str(object(doc["info"])["title"]), Types: map[string]*Type{}},
+		schemas: object(object(doc["components"])["schemas"]),
+		names:   map[string]string{},
+	}
+	paths := object(doc["paths"])
+	for _, path := range sortedKeys(paths) {
+		post := object(object(paths[path])["post"])
+		if post == nil {
+			continue
+		}
+		departmentName, transactionName, ok := strings.Cut(str(post["operationId"]), ".")
+		if !ok {
+			segments := strings.Split(strings.Trim(path, "/"), "/")
+			if len(segments) != 2 {
+				continue
+			}
+			departmentName, transactionName = segments[0], segments[1]
+		}
+		hint := ExportedName(departmentName) + ExportedName(transactionName)
+		request := mediaSchema(object(post["requestBody"]))
+		response := mediaSchema(object(object(post["responses"])["200"]))
+		l.api.Operations = append(l.api.Operations, Operation{
+			Department:  departmentName,
+			Transaction: transactionName,
+			Summary:     str(post["summary"]),
+			Description: str(post["description"]),
+			Deprecated:  post["deprecated"] == true,
+			Request:     l.typeRef(object(object(request["properties"])["form"]), hint+"Request"),
+			Response:    l.typeRef(object(object(response["properties"])["output"]), hint+"Response"),
+			Examples:    openAPIExamples(object(post["requestBody"]), object(object(post["responses"])["200"])),
+		})
+	}
+	return l.api, nil
+}
+
+type openAPILoader struct {
+	api     *API
+	schemas map[string]interface{}
+	names   map[string]string // component name -> type name
+}
+
+func (l *openAPILoader) typeRef(schema map[string]interface{}, hint string) TypeRef {
+	if ref := str(schema["$ref"]); ref != "" {
+		return l.named(strings.TrimPrefix(ref, openAPIRefPrefix))
+	}
+	switch schemaType(schema) {
+	case "string":
+		if schema["format"] == "date-time" {
+			return TypeRef{Kind: KindTime}
+		}
+		return TypeRef{Kind: KindString, Enum: strs(schema["enum"])}
+	case "integer":
+		return TypeRef{Kind: KindInteger}
+	case "number":
+		return TypeRef{Kind: KindNumber}
+	case "boolean":
+		return TypeRef{Kind: KindBoolean}
+	case "array":
+		elem := l.typeRef(object(schema["items"]), hint+"Item")
+		return TypeRef{Kind: KindArray, Elem: &elem}
+	case "object":
+		if properties := object(schema["properties"]); len(properties) > 0 {
+			t := &Type{Description: str(schema["description"])}
+			name := l.api.addType(hint, t)
+			t.Fields = l.fields(schema, name)
+			return TypeRef{Kind: KindObject, Name: name}
+		}
+		elem := TypeRef{Kind: KindAny}
+		if additional := object(schema["additionalProperties"]); additional != nil {
+			elem = l.typeRef(additional, hint+"Value")
+		}
+		return TypeRef{Kind: KindMap, Elem: &elem}
+	}
+	if enum := strs(schema["enum"]); len(enum) > 0 {
+		return TypeRef{Kind: KindString, Enum: enum}
+	}
+	return TypeRef{Kind: KindAny}
+}
+
+// named returns the type of a component, loading it on first use.
+func (l *openAPILoader) named(component string) TypeRef {
+	if name, ok := l.names[component]; ok {
+		if name == "" {
+			// an inlined component that refers to itself
+			return TypeRef{Kind: KindAny}
+		}
+		return TypeRef{Kind: KindObject, Name: name}
+	}
+	schema := object(l.schemas[component])
+	if schemaType(schema) != "object" || len(object(schema["properties"])) == 0 {
+		// aliases and property-less objects are inlined
+		l.names[component] = ""
+		ref := l.typeRef(schema, ExportedName(componentBase(component)))
+		delete(l.names, component)
+		return ref
+	}
+	t := &Type{Description: str(schema["description"])}
+	// reserve the name before loading the fields so recursive references resolve to it
+	name := l.api.addType(ExportedName(componentBase(component)), t)
+	l.names[component] = name
+	t.Fields = l.fields(schema, name)
+	return TypeRef{Kind: KindObject, Name: name}
+}
+
+func (l *openAPILoader) fields(schema map[string]interface{}, typeName string) []Field {
+	required := map[string]bool{}
+	for _, name := range strs(schema["required"]) {
+		required[name] = true
+	}
+	properties := object(schema["properties"])
+	var fields []Field
+	for _, name := range sortedKeys(properties) {
+		property := object(properties[name])
+		fields = append(fields, Field{
+			JsonName:    name,
+			Type:        l.typeRef(property, typeName+ExportedName(name)),
+			Required:    required[name],
+			Description: str(property["description"]),
+			Deprecated:  property["deprecated"] == true,
+			Example:     first(property["examples"]),
+		})
+	}
+	return fields
+}
+
+// openAPIExamples pairs the request and response document examples sharing a key.
+func openAPIExamples(requestBody, response map[string]interface{}) []Example {
+	requests := object(object(object(requestBody["content"])["application/json"])["examples"])
+	responses := object(object(object(response["content"])["application/json"])["examples"])
+	var examples []Example
+	for _, key := range sortedKeys(requests) {
+		example := object(requests[key])
+		name := str(example["summary"])
+		if name == "" {
+			name = key
+		}
+		examples = append(examples, Example{
+			Name:     name,
+			Request:  object(example["value"])["form"],
+			Response: object(object(responses[key])["value"])["output"],
+		})
+	}
+	return examples
+}
+
+func first(v interface{}) interface{} {
+	if list, ok := v.([]interface{}); ok && len(list) > 0 {
+		return list[0]
+	}
+	return nil
+}
+
+// componentBase drops the package qualifier of component names such as "model.Security".
+func componentBase(component string) string {
+	return component[strings.LastIndex(component, ".")+1:]
+}
+
+func mediaSchema(body map[string]interface{}) map[string]interface{} {
+	return object(object(object(body["content"])["application/json"])["schema"])
+}
+
+// schemaType returns the type keyword; for OpenAPI 3.1 type lists the first non-null entry.
+func schemaType(schema map[string]interface{}) string {
+	if list, ok := schema["type"].([]interface{}); ok {
+		for _, t := range list {
+			if t != "null" {
+				return str(t)
+			}
+		}
+		return ""
+	}
+	return str(schema["type"])
+}
+
+func object(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func strs(v interface{}) []string {
+	list, _ := v.([]interface{})
+	var out []string
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
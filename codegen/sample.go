@@ -0,0 +1,140 @@
+package codegen
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/godispatcher/dispatcher/constants"
+)
+
+// Sample builds a JSON value of ref's shape, like utilities.SampleValue does for Go types:
+// documented field examples when present, the first enum value, zero values otherwise.
+// Arrays hold one element and a type that contains itself is cut off with null.
+func (a *API) Sample(ref TypeRef) interface{} {
+	return a.sample(ref, map[string]bool{})
+}
+
+func (a *API) sample(ref TypeRef, visiting map[string]bool) interface{} {
+	switch ref.Kind {
+	case KindString:
+		if len(ref.Enum) > 0 {
+			return ref.Enum[0]
+		}
+		return ""
+	case KindTime:
+		return "2006-01-02T15:04:05Z"
+	case KindInteger, KindNumber:
+		return 0
+	case KindBoolean:
+		return false
+	case KindArray:
+		return []interface{}{a.sample(*ref.Elem, visiting)}
+	case KindMap:
+		return map[string]interface{}{}
+	case KindObject:
+		t := a.Types[ref.Name]
+		if t == nil || visiting[ref.Name] {
+			return nil
+		}
+		visiting[ref.Name] = true
+		defer delete(visiting, ref.Name)
+		out := map[string]interface{}{}
+		for _, f := range t.Fields {
+			if f.Example != nil {
+				out[f.JsonName] = f.Example
+				continue
+			}
+			out[f.JsonName] = a.sample(f.Type, visiting)
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+// Validate checks a decoded JSON value against ref: required fields, JSON types and enum values.
+// The errors use the messages of the form validator.
+func (a *API) Validate(ref TypeRef, value interface{}) error {
+	return a.validate("", ref, value)
+}
+
+func (a *API) validate(path string, ref TypeRef, value interface{}) error {
+	if value == nil || ref.Kind == KindAny {
+		return nil
+	}
+	name := path
+	if name == "" {
+		name = "form"
+	}
+	switch ref.Kind {
+	case KindString, KindTime:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf(constants.FIELD_TYPE_MISMATCH, name, "a string")
+		}
+		if len(ref.Enum) > 0 && !slices.Contains(ref.Enum, s) {
+			return fmt.Errorf(constants.FIELD_NOT_IN_ENUM, name, strings.Join(ref.Enum, ", "))
+		}
+	case KindInteger:
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			return fmt.Errorf(constants.FIELD_TYPE_MISMATCH, name, "an integer")
+		}
+	case KindNumber:
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf(constants.FIELD_TYPE_MISMATCH, name, "a number")
+		}
+	case KindBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf(constants.FIELD_TYPE_MISMATCH, name, "a boolean")
+		}
+	case KindArray:
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf(constants.FIELD_TYPE_MISMATCH, name, "an array")
+		}
+		for i, item := range items {
+			if err := a.validate(fmt.Sprintf("%s[%d]", path, i), *ref.Elem, item); err != nil {
+				return err
+			}
+		}
+	case KindMap, KindObject:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf(constants.FIELD_TYPE_MISMATCH, name, "an object")
+		}
+		if ref.Kind == KindMap {
+			for _, key := range sortedKeys(m) {
+				if err := a.validate(joinPath(path, key), *ref.Elem, m[key]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		t := a.Types[ref.Name]
+		if t == nil {
+			return nil
+		}
+		for _, f := range t.Fields {
+			v, ok := m[f.JsonName]
+			if !ok {
+				if f.Required {
+					return fmt.Errorf(constants.FIELD_NOT_FOUND, joinPath(path, f.JsonName))
+				}
+				continue
+			}
+			if err := a.validate(joinPath(path, f.JsonName), f.Type, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,54 @@
+package codegen_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/godispatcher/dispatcher/codegen"
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+func TestAPISampleAndValidate(t *testing.T) {
+	registerAPI()
+	department.DispatcherHolder.Add("Orders", transaction.TransactionBucketItem{Name: "list", Transaction: stubServer{request: orderFilter{}, response: []receiptV1{}}})
+	defer func() { department.DispatcherHolder = nil }()
+
+	api, err := codegen.Load(helpBody(t, "format=openapi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := map[string]codegen.Operation{}
+	for _, op := range api.Operations {
+		ops[op.Department+"."+op.Transaction] = op
+	}
+
+	login := ops["Auth.login"]
+	if len(login.Examples) != 1 || fmt.Sprint(login.Examples[0].Response) != "map[token:abc]" {
+		t.Errorf("examples not loaded: %+v", login.Examples)
+	}
+	sample := api.Sample(login.Request).(map[string]interface{})
+	if sample["email"] != "jane@example.com" || fmt.Sprint(sample["remember"]) != "30" {
+		t.Errorf("field examples not used: %v", sample)
+	}
+	if sample := api.Sample(ops["Orders.list"].Request).(map[string]interface{}); sample["status"] != "open" {
+		t.Errorf("first enum value expected: %v", sample)
+	}
+
+	filter := ops["Orders.list"].Request
+	for form, want := range map[string]string{
+		`{"status":"open","limit":5,"tags":["a"]}`: "<nil>",
+		`{"limit":5}`:                   fmt.Sprintf(constants.FIELD_NOT_FOUND, "status"),
+		`{"status":"lost"}`:             fmt.Sprintf(constants.FIELD_NOT_IN_ENUM, "status", "open, shipped, cancelled"),
+		`{"status":"open","limit":1.5}`: fmt.Sprintf(constants.FIELD_TYPE_MISMATCH, "limit", "an integer"),
+		`{"status":"open","tags":[1]}`:  fmt.Sprintf(constants.FIELD_TYPE_MISMATCH, "tags[0]", "a string"),
+	} {
+		var value interface{}
+		_ = json.Unmarshal([]byte(form), &value)
+		if got := fmt.Sprint(api.Validate(filter, value)); got != want {
+			t.Errorf("%s: got %s want %s", form, got, want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package codegen
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ReadSource fetches an http(s) URL or reads a file. A URL without a format query
+// parameter is asked for defaultFormat, e.g. "json" or "openapi".
+func ReadSource(source, defaultFormat string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	if query.Get("format") == "" && defaultFormat != "" {
+		query.Set("format", defaultFormat)
+		u.RawQuery = query.Encode()
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// LoadSource reads a source with ReadSource and loads it with Load.
+func LoadSource(source, defaultFormat string) (*API, error) {
+	data, err := ReadSource(source, defaultFormat)
+	if err != nil {
+		return nil, err
+	}
+	return Load(data)
+}
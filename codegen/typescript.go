@@ -0,0 +1,183 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GenerateTypeScript renders TypeScript interfaces for every request and response type and
+// a fetch based Client with one method per transaction. The client wraps forms into
+// documents, passes X-Verify-Code and throws a DispatcherError for Error documents.
+func GenerateTypeScript(api *API) []byte {
+	w := &bytes.Buffer{}
+	w.WriteString(typeScriptPrelude)
+	for _, op := range api.Operations {
+		request, response := typeScriptType(op.Request), typeScriptType(op.Response)
+		fmt.Fprintln(w)
+		typeScriptComment(w, "  ", op.Summary, op.Description, op.Deprecated)
+		fmt.Fprintf(w, "  %s(form: %s): Promise<%s> {\n", methodName(op), request, response)
+		fmt.Fprintf(w, "    return this.call<%s, %s>(%s, %s, form);\n  }\n", request, response, strconv.Quote(op.Department), strconv.Quote(op.Transaction))
+	}
+	fmt.Fprintf(w, "}\n")
+
+	for _, t := range api.SortedTypes() {
+		fmt.Fprintln(w)
+		typeScriptComment(w, "", "", t.Description, false)
+		fmt.Fprintf(w, "export interface %s {\n", t.Name)
+		for _, f := range t.Fields {
+			typeScriptComment(w, "  ", "", f.Description, f.Deprecated)
+			optional := "?"
+			if f.Required {
+				optional = ""
+			}
+			fmt.Fprintf(w, "  %s%s: %s;\n", typeScriptKey(f.JsonName), optional, typeScriptType(f.Type))
+		}
+		fmt.Fprintf(w, "}\n")
+	}
+	return w.Bytes()
+}
+
+const typeScriptPrelude = `// Code generated by dispatcher-gen. DO NOT EDIT.
+
+export interface Security {
+  licence?: string;
+  verify_code?: string;
+}
+
+export interface DispatcherDocument<F = unknown, O = unknown> {
+  department?: string;
+  transaction?: string;
+  type?: string;
+  form?: F;
+  output?: O;
+  error?: unknown;
+  security?: Security;
+  dispatchings?: DispatcherDocument[];
+}
+
+export class DispatcherError extends Error {
+  constructor(message: string, public readonly document: DispatcherDocument) {
+    super(message);
+    this.name = "DispatcherError";
+  }
+}
+
+export interface ClientOptions {
+  /** ServJsonApi address, e.g. "https://orders.example.com/". */
+  baseUrl: string;
+  /** Sent as the X-Verify-Code header. */
+  verifyCode?: string;
+  /** Sent as security.licence. */
+  licence?: string;
+  headers?: Record<string, string>;
+  fetch?: typeof fetch;
+}
+
+export class Client {
+  constructor(private readonly options: ClientOptions) {}
+
+  async call<F, O>(department: string, transaction: string, form: F): Promise<O> {
+    const document: DispatcherDocument<F> = { department, transaction, form };
+    if (this.options.licence) {
+      document.security = { licence: this.options.licence };
+    }
+    const headers: Record<string, string> = {
+      "Content-Type": "application/json",
+      Accept: "application/json",
+      ...this.options.headers,
+    };
+    if (this.options.verifyCode) {
+      headers["X-Verify-Code"] = this.options.verifyCode;
+    }
+    const doFetch = this.options.fetch ?? fetch;
+    const response = await doFetch(this.options.baseUrl, { method: "POST", headers, body: JSON.stringify(document) });
+    const result = (await response.json()) as DispatcherDocument<F, O>;
+    if (result.type === "Error" || !response.ok) {
+      throw new DispatcherError(String(result.error ?? response.statusText), result);
+    }
+    return result.output as O;
+  }
+`
+
+func typeScriptType(ref TypeRef) string {
+	switch ref.Kind {
+	case KindString:
+		if len(ref.Enum) > 0 {
+			values := make([]string, len(ref.Enum))
+			for i, value := range ref.Enum {
+				values[i] = strconv.Quote(value)
+			}
+			return strings.Join(values, " | ")
+		}
+		return "string"
+	case KindTime:
+		return "string"
+	case KindInteger, KindNumber:
+		return "number"
+	case KindBoolean:
+		return "boolean"
+	case KindArray:
+		elem := typeScriptType(*ref.Elem)
+		if strings.Contains(elem, " | ") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case KindMap:
+		return "Record<string, " + typeScriptType(*ref.Elem) + ">"
+	case KindObject:
+		return ref.Name
+	default:
+		return "unknown"
+	}
+}
+
+// methodName is the lower camel case department + transaction name, e.g. "authLogin".
+func methodName(op Operation) string {
+	name := []rune(ExportedName(op.Department) + ExportedName(op.Transaction))
+	for i := 0; i < len(name) && unicode.IsUpper(name[i]); i++ {
+		// lower the leading initialism but keep the first letter of the next word
+		if i > 0 && i+1 < len(name) && unicode.IsLower(name[i+1]) {
+			break
+		}
+		name[i] = unicode.ToLower(name[i])
+	}
+	return string(name)
+}
+
+// typeScriptKey quotes property names that are not identifiers.
+func typeScriptKey(name string) string {
+	for i, r := range name {
+		if !(r == '_' || r == '$' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return strconv.Quote(name)
+		}
+	}
+	return name
+}
+
+func typeScriptComment(w *bytes.Buffer, indent, summary, description string, deprecated bool) {
+	var lines []string
+	if summary != "" {
+		lines = append(lines, summary)
+	}
+	if description != "" {
+		lines = append(lines, strings.Split(description, "\n")...)
+	}
+	if deprecated {
+		lines = append(lines, "@deprecated")
+	}
+	if len(lines) == 0 {
+		return
+	}
+	if len(lines) == 1 {
+		fmt.Fprintf(w, "%s/** %s */\n", indent, strings.ReplaceAll(lines[0], "*/", "*\\/"))
+		return
+	}
+	fmt.Fprintf(w, "%s/**\n", indent)
+	for _, line := range lines {
+		fmt.Fprintf(w, "%s * %s\n", indent, strings.ReplaceAll(line, "*/", "*\\/"))
+	}
+	fmt.Fprintf(w, "%s */\n", indent)
+}
@@ -0,0 +1,52 @@
+package codegen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/godispatcher/dispatcher/codegen"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+type orderFilter struct {
+	Status string   `json:"status" require:"true" enum:"open,shipped,cancelled"`
+	Limit  int      `json:"limit,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+func TestGenerateTypeScript(t *testing.T) {
+	registerAPI()
+	department.DispatcherHolder.Add("Orders", transaction.TransactionBucketItem{
+		Name:        "list",
+		Transaction: stubServer{request: orderFilter{}, response: []openAPINode{}},
+	})
+	defer func() { department.DispatcherHolder = nil }()
+
+	cases := map[string][]string{
+		"format=openapi": {
+			`authLogin(form: LoginRequest): Promise<Record<string, string>>`,
+			`ordersList(form: OrderFilter): Promise<OpenAPINode[]>`,
+			`status: "open" | "shipped" | "cancelled";`,
+			`limit?: number;`,
+			`email: string;`,
+			`/** Account e-mail address */`,
+			`"X-Verify-Code"`,
+			`result.type === "Error"`,
+		},
+		// the enum survives the JSON help as well
+		"format=json": {`status: "open" | "shipped" | "cancelled";`},
+	}
+	for query, wants := range cases {
+		api, err := codegen.Load(helpBody(t, query))
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		code := string(codegen.GenerateTypeScript(api))
+		for _, want := range wants {
+			if !strings.Contains(code, want) {
+				t.Errorf("%s: typescript misses %q\n%s", query, want, code)
+			}
+		}
+	}
+}
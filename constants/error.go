@@ -9,4 +9,7 @@ const (
 	REQUEST_BODY_READ_ERROR        string = "error reading body: %v"
 	THIS_REQUEST_TYPE_INVALID_JSON string = "this request data is broken"
 	CONTENT_TYPE_NOT_JSON          string = "in this request, header content type is not marked as json, add content-type:application/json to request header to fix it."
+	RATE_LIMIT_EXCEEDED            string = "Rate limit exceeded. Try again in %d seconds."
+	FIELD_NOT_IN_ENUM              string = "the field named %s must be one of %s"
+	FIELD_TYPE_MISMATCH            string = "the field named %s must be %s"
 )
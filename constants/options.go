@@ -4,8 +4,22 @@ const (
 	HTTP_CONTENT_JSON = "application/json"
 	HTTP_CONTENT_TOON = "text/toon"
 	HTTP_CONTENT_YAML = "application/x-yaml"
+	// HTTP_CONTENT_NDJSON is the response of a streaming transaction over HTTP, one document per line.
+	HTTP_CONTENT_NDJSON = "application/x-ndjson"
+	// HTTP_CONTENT_EVENT_STREAM is the Server-Sent Events response of the events endpoint.
+	HTTP_CONTENT_EVENT_STREAM = "text/event-stream"
+
+	HTTP_CONTENT_SCHEMA_JSON = "application/schema+json"
+	HTTP_CONTENT_TYPESCRIPT  = "application/typescript"
 
 	OPTION_REQUIRE = "require"
 	OPTION_ISEMPTY = "isEmpty"
 	OPTION_JSON    = "json"
+	OPTION_FORMAT  = "format"
+	OPTION_ENUM    = "enum"
+
+	OPTION_DESCRIPTION = "description"
+	OPTION_EXAMPLE     = "example"
+	OPTION_DEPRECATED  = "deprecated"
+	OPTION_SENSITIVE   = "sensitive"
 )
@@ -8,4 +8,13 @@ const (
 	DOC_TYPE_PROCEDURE       = "Procedure"       // Transactiın procedure parameters
 	DOC_TYPE_DISPATCH        = "Dispatch"        // Dispatch to transaction and/or fill a form
 	DOC_TYPE_DIRECT_DISPATCH = "Direct Dispatch" // Direct Dispatch to transaction no form filling (Require form transactions)
+	DOC_TYPE_COMPRESSION     = "Compression"     // Stream connection compression negotiation
+	DOC_TYPE_PROTOCOL        = "Protocol"        // Stream connection protocol version negotiation
+	DOC_TYPE_STREAM          = "Stream"          // Partial output of a streaming transaction
+	DOC_TYPE_SUBSCRIBE       = "Subscribe"       // Stream connection topic subscription
+	DOC_TYPE_UNSUBSCRIBE     = "Unsubscribe"     // End of a topic subscription
+	DOC_TYPE_EVENT           = "Event"           // Event published to a topic
+	DOC_TYPE_AUTH            = "Auth"            // WebSocket connection authentication
+	DOC_TYPE_GOING_AWAY      = "GoingAway"       // Server is shutting down, reconnect elsewhere
+	DOC_TYPE_PING            = "Ping"            // Stream connection health check, answered with the same document
 )
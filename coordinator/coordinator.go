@@ -3,6 +3,7 @@ package coordinator
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/godispatcher/dispatcher/department"
 	"github.com/godispatcher/dispatcher/model"
@@ -17,54 +18,95 @@ func ExecuteTransaction(document model.Document) model.Document {
 	return document
 }
 
+// Transport delivers a document to a remote service and returns the response document.
+type Transport func(document model.Document) (model.Document, error)
+
+// HTTPTransport sends documents with server.CallHTTP to address (see ServiceRequest.Address).
+func HTTPTransport(address string) Transport {
+	return func(document model.Document) (model.Document, error) {
+		return server.CallHTTP(address, document)
+	}
+}
+
+// StreamTransport sends documents over the persistent connections of pool.
+func StreamTransport(pool *server.StreamClientPool) Transport {
+	return pool.Send
+}
+
+// BalancedTransport sends documents to the endpoints of balancer, the replicas of a service
+// (see server.NewHTTPBalancer and server.NewStreamBalancer).
+func BalancedTransport(balancer *server.Balancer) Transport {
+	return balancer.Send
+}
+
 // ServiceRequest is a generic request wrapper for calling remote transactions
 // T is the request form model, R is the expected response output model
 // Uses model.Document directly; callers should populate non-form fields on Document.
-// Note: host should be provided without protocol (e.g., "auth:9000").
-// server.CallHTTP will construct the full URL.
-
+// Address is passed to server.CallHTTP as is and must carry a scheme: "http://auth:9000"
+// or "https://auth:9000", or "h2c://auth:9000" for unencrypted HTTP/2.
+// Set Transport instead to use another transport such as StreamTransport, or
+// BalancedTransport for a service with several replicas.
 type ServiceRequest[T any, R any] struct {
-	Address  string
-	Document model.Document
-	Request  T
-	Response model.Document
+	Address   string
+	Transport Transport
+	Document  model.Document
+	Request   T
+	Response  model.Document
 }
 
 // CallTransaction sends the typed request T and returns a typed response R.
 // Internally it fills Document.Form from T, calls the HTTP client and decodes Output into R.
 func (req *ServiceRequest[T, R]) CallTransaction() (R, error) {
+	transport := req.Transport
+	if transport == nil {
+		transport = HTTPTransport(req.Address)
+	}
+	out, resDoc, err := invoke[T, R](transport, req.Document, req.Request)
+	req.Response = resDoc
+	return out, err
+}
+
+// Invoke sends request as the form of document through transport and decodes the output into R.
+// It is the typed call used by ServiceRequest and by generated clients.
+func Invoke[T any, R any](transport Transport, document model.Document, request T) (R, error) {
+	out, _, err := invoke[T, R](transport, document, request)
+	return out, err
+}
+
+func invoke[T any, R any](transport Transport, document model.Document, request T) (R, model.Document, error) {
 	var zero R
 	// Build form from typed request into the provided document
 	form := model.DocumentForm{}
-	if err := form.FromInterface(req.Request); err != nil {
-		return zero, err
+	if err := form.FromInterface(request); err != nil {
+		return zero, model.Document{}, err
 	}
-	req.Document.Form = form
+	document.Form = form
 	// Ensure verify code is propagated to the outgoing request document
-	if (req.Document.Security == nil) || (req.Document.Security.VerifyCode == "") {
+	if (document.Security == nil) || (document.Security.VerifyCode == "") {
 		if vc := model.GetCurrentVerifyCode(); vc != "" {
-			if req.Document.Security == nil {
-				req.Document.Security = &model.Security{}
+			security := model.Security{}
+			if document.Security != nil {
+				security = *document.Security
 			}
-			req.Document.Security.VerifyCode = vc
+			security.VerifyCode = vc
+			document.Security = &security
 		}
 	}
-	resDoc, err := server.CallHTTP(req.Address, req.Document)
-	req.Response = resDoc
+	resDoc, err := transport(document)
 	if err != nil {
-		return zero, err
+		return zero, resDoc, err
 	}
 	if resDoc.Type == "Error" {
-		return zero, errors.New(resDoc.Error.(string))
+		return zero, resDoc, errors.New(fmt.Sprint(resDoc.Error))
 	}
 	// Decode Output into typed response
 	b, err := json.Marshal(resDoc.Output)
 	if err != nil {
-		return zero, err
+		return zero, resDoc, err
 	}
 	var out R
 	if err := json.Unmarshal(b, &out); err != nil {
-		return zero, err
+		return zero, resDoc, err
 	}
-	return out, nil
+	return out, resDoc, nil
 }
@@ -15,6 +15,7 @@ func NewTransaction[T any, TI transaction.Transaction[T]](departmentName, transa
 	tmp.Name = transactionName
 	header := http.Header{}
 	var transactionOptions model.TransactionOptions
+	var doc model.TransactionDoc
 	if options != nil {
 		for _, option := range options {
 			switch opt := option.(type) {
@@ -24,11 +25,13 @@ func NewTransaction[T any, TI transaction.Transaction[T]](departmentName, transa
 				}
 			case model.TransactionOptions:
 				transactionOptions = opt
+			case model.TransactionDoc:
+				doc = opt
 			}
 		}
 	}
 
-	tmp.Transaction = server.Server[T, TI]{Runables: runables, Options: model.ServerOption{Header: header, TransactionOptions: transactionOptions}}
+	tmp.Transaction = server.Server[T, TI]{Runables: runables, Options: model.ServerOption{Header: header, TransactionOptions: transactionOptions, Doc: doc}}
 
 	department.DispatcherHolder.Add(departmentName, tmp)
 }
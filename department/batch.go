@@ -0,0 +1,96 @@
+package department
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// ExecuteDocument finds the transaction addressed by the document, runs it and
+// then runs its chained dispatchings. It mirrors RegisterMainFunc without HTTP specifics.
+func ExecuteDocument(document model.Document) model.Document {
+	return ExecuteDocumentStream(document, nil)
+}
+
+// ExecuteBatch runs every document independently and returns the results in request order.
+// Unlike dispatchings, an error in one document does not stop the others.
+func ExecuteBatch(documents []model.Document, options *model.BatchOptions) ([]model.Document, error) {
+	opts := options.WithDefaults()
+	if len(documents) > opts.MaxSize {
+		return nil, fmt.Errorf("batch size %d exceeds the limit of %d", len(documents), opts.MaxSize)
+	}
+
+	results := make([]model.Document, len(documents))
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i := range documents {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = ExecuteDocument(documents[i])
+		}(i)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// IsBatchPayload reports whether the JSON body is an array of documents.
+func IsBatchPayload(body []byte) bool {
+	trimmed := bytes.TrimSpace(body)
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+func BatchHandler(r *http.Request) ([]model.Document, error) {
+	var documents []model.Document
+	bodyByte, err := io.ReadAll(r.Body)
+	if err != nil {
+		return documents, err
+	}
+	err = json.Unmarshal(bodyByte, &documents)
+	return documents, err
+}
+
+// BatchMainFunc executes a JSON array of documents and writes a JSON array of results.
+func BatchMainFunc(w http.ResponseWriter, r *http.Request) (rw model.RegisterResponseModel) {
+	documents, err := BatchHandler(r)
+	if err != nil {
+		rw = WriteErrorDoc(err, w)
+		return rw
+	}
+	var options *model.BatchOptions
+	if rd := RegisterFromContext(r.Context()); rd != nil {
+		options = rd.Batch
+	}
+	for i := range documents {
+		addressFromPath(&documents[i], r)
+		inheritVerifyCode(&documents[i], r)
+		InheritPeer(&documents[i], PeerFromContext(r.Context()))
+	}
+	disableCompression(w, documents...)
+	results, err := ExecuteBatch(documents, options)
+	if err != nil {
+		outputDoc := model.Document{Error: err.Error(), Type: "Error"}
+		response, _ := json.Marshal(outputDoc)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		rw.StatusCode = http.StatusRequestEntityTooLarge
+		rw.Body = string(response)
+		fmt.Fprint(w, string(response))
+		return rw
+	}
+	response, err := json.Marshal(results)
+	if err != nil {
+		rw = WriteErrorDoc(err, w)
+		return rw
+	}
+	rw.Header = w.Header()
+	rw.Body = string(response)
+	fmt.Fprint(w, string(response))
+	return rw
+}
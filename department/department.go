@@ -1,6 +1,7 @@
 package department
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"time"
@@ -55,14 +56,69 @@ func NewRegisteryDispatcher(port string) *RegisterDispatcher {
 }
 
 type RegisterDispatcher struct {
-	MainFunc     func(http.ResponseWriter, *http.Request) model.RegisterResponseModel
-	Port         string
-	StreamPort   string
+	MainFunc   func(http.ResponseWriter, *http.Request) model.RegisterResponseModel
+	Port       string
+	StreamPort string
+	// PathPrefix mounts the HTTP endpoints below a path, e.g. "/orders"; see server.NewHandler.
+	PathPrefix   string
 	LoggerWriter func(log logger.LogEntry) error
 	CORS         *model.CORSOptions
+	Batch        *model.BatchOptions
+	JSONRPC      *model.JSONRPCOptions
+	Compression  *model.CompressionOptions
+	HTTP2        *model.HTTP2Options
+	Mock         *model.MockOptions
+	Stream       *model.StreamOptions
+	PubSub       *model.PubSubOptions
+	WebSocket    *model.WebSocketOptions
+	SSE          *model.SSEOptions
+	Shutdown     *model.ShutdownOptions
+	UnixSocket   *model.UnixSocketOptions
+}
+
+type registerContextKey struct{}
+
+// RegisterFromContext returns the RegisterDispatcher serving the request, if any.
+func RegisterFromContext(ctx context.Context) *RegisterDispatcher {
+	rd, _ := ctx.Value(registerContextKey{}).(*RegisterDispatcher)
+	return rd
+}
+
+// ContextWithRegister returns a copy of ctx carrying rd, so handlers called outside
+// RegisterDispatcher.ServeHTTP (e.g. MainFunc in tests or custom routers) see its options.
+func ContextWithRegister(ctx context.Context, rd *RegisterDispatcher) context.Context {
+	return context.WithValue(ctx, registerContextKey{}, rd)
+}
+
+type peerContextKey struct{}
+
+// ContextWithPeer returns a copy of ctx carrying the credentials of a unix socket peer.
+func ContextWithPeer(ctx context.Context, peer *model.PeerCredentials) context.Context {
+	return context.WithValue(ctx, peerContextKey{}, peer)
+}
+
+// PeerFromContext returns the credentials of the unix socket peer of a request, if any.
+func PeerFromContext(ctx context.Context) *model.PeerCredentials {
+	peer, _ := ctx.Value(peerContextKey{}).(*model.PeerCredentials)
+	return peer
+}
+
+type shutdownContextKey struct{}
+
+// ContextWithShutdown returns a copy of ctx carrying done, which is closed when the server
+// starts shutting down, so long-lived responses such as topic event streams end in time.
+func ContextWithShutdown(ctx context.Context, done <-chan struct{}) context.Context {
+	return context.WithValue(ctx, shutdownContextKey{}, done)
+}
+
+// shutdownFromContext returns the shutdown channel of ctx; nil, which never fires, without one.
+func shutdownFromContext(ctx context.Context) <-chan struct{} {
+	done, _ := ctx.Value(shutdownContextKey{}).(<-chan struct{})
+	return done
 }
 
 func (rd RegisterDispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(ContextWithRegister(r.Context(), &rd))
 	logger.InitLogFile("log.jsonl")
 	loggerRequest, _ := logger.NewLoggedRequest(r)
 	startTime := time.Now()
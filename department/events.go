@@ -0,0 +1,234 @@
+package department
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/pubsub"
+	"github.com/godispatcher/dispatcher/utilities"
+)
+
+// EventsMainFunc serves Server-Sent Events (RegisterDispatcher.SSE) for clients that cannot use
+// WebSockets:
+//
+//	GET /events?department=Report&transaction=export&form={"year":2024}
+//	GET /events?topic=orders.>&topic=stock.*
+//
+// A transaction stream sends every document of the transaction, partial outputs of streaming
+// transactions first, and ends with the terminal document. A topic stream sends the events
+// published to the topics and resumes after Last-Event-ID from the retained events.
+// The event name is the document type. Security is read like in RegisterMainFunc, plus the
+// licence and token (verify code) query parameters, as EventSource cannot set headers.
+func EventsMainFunc(w http.ResponseWriter, r *http.Request) (rw model.RegisterResponseModel) {
+	var options *model.SSEOptions
+	register := RegisterFromContext(r.Context())
+	if register != nil {
+		options = register.SSE
+	}
+	opts := options.WithDefaults()
+	if r.Method != http.MethodGet {
+		return writeEventsError(w, http.StatusMethodNotAllowed, errors.New("events must be requested with GET"))
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return writeEventsError(w, http.StatusInternalServerError, errors.New("streaming is not supported by the connection"))
+	}
+	query := r.URL.Query()
+	security := eventsSecurity(r)
+	if topics := query["topic"]; len(topics) > 0 {
+		return serveTopicEvents(w, r, flusher, register, opts, topics, security)
+	}
+	if query.Get("department") == "" || query.Get("transaction") == "" {
+		return writeEventsError(w, http.StatusBadRequest, errors.New("department and transaction or topic are required"))
+	}
+	if r.Header.Get("Last-Event-ID") != "" {
+		// a finished transaction stream cannot be resumed; 204 stops EventSource reconnecting
+		w.WriteHeader(http.StatusNoContent)
+		rw.StatusCode = http.StatusNoContent
+		return rw
+	}
+
+	document := model.Document{Department: query.Get("department"), Transaction: query.Get("transaction"), Security: security}
+	if form := query.Get("form"); form != "" {
+		if err := json.Unmarshal([]byte(form), &document.Form); err != nil {
+			return writeEventsError(w, http.StatusBadRequest, fmt.Errorf("form is not a JSON object: %w", err))
+		}
+	}
+	ta := DispatcherHolder.GetTransaction(document.Department, document.Transaction)
+	if ta == nil {
+		return writeEventsError(w, http.StatusBadRequest, errors.New("transaction not found"))
+	}
+	for key := range (*ta).GetTransaction().GetOptions().Header {
+		w.Header().Set(key, (*ta).GetTransaction().GetOptions().Header.Get(key))
+	}
+	events := startEvents(w, flusher, opts)
+	var id uint64
+	emit := func(document model.Document) error {
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		id++
+		return events.send(id, document)
+	}
+	_ = emit(ExecuteDocumentStream(document, emit))
+	rw.Header = w.Header()
+	rw.StatusCode = http.StatusOK
+	rw.Body = fmt.Sprintf("%d events", id)
+	return rw
+}
+
+func serveTopicEvents(w http.ResponseWriter, r *http.Request, flusher http.Flusher, register *RegisterDispatcher, opts *model.SSEOptions, topics []string, security *model.Security) (rw model.RegisterResponseModel) {
+	pubSubOptions, err := AuthorizeSubscription(register, topics, security)
+	if err != nil {
+		return writeEventsError(w, http.StatusForbidden, err)
+	}
+	if limit := opts.RateLimiter; limit.Enabled && limit.Limit > 0 && limit.Window > 0 {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		var licence, verifyCode string
+		if security != nil {
+			licence, verifyCode = security.Licence, security.VerifyCode
+		}
+		key := utilities.GenerateKey("events", strings.Join(topics, ","), string(limit.Scope), host, licence, verifyCode)
+		res := utilities.GetRateLimiter(key, limit.Limit, limit.Window).Allow()
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		if !res.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(res.RetryAfter))
+			return writeEventsError(w, http.StatusTooManyRequests, fmt.Errorf(constants.RATE_LIMIT_EXCEEDED, res.RetryAfter))
+		}
+	}
+
+	var after uint64
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		after, _ = strconv.ParseUint(lastID, 10, 64)
+	}
+	pubsub.DefaultBroker.Retain(opts.ReplaySize)
+	subscription, err := pubsub.DefaultBroker.SubscribeFrom(topics, nil, pubSubOptions.Buffer, after)
+	if err != nil {
+		return writeEventsError(w, http.StatusBadRequest, err)
+	}
+	defer subscription.Cancel()
+
+	events := startEvents(w, flusher, opts)
+	shutdown := shutdownFromContext(r.Context())
+	heartbeat := time.NewTicker(opts.Heartbeat)
+	defer heartbeat.Stop()
+	rw.Header = w.Header()
+	rw.StatusCode = http.StatusOK
+	for {
+		select {
+		case event, ok := <-subscription.C:
+			if !ok {
+				// dropped as a slow subscriber: say why, the client reconnects and resumes
+				if err := subscription.Err(); err != nil {
+					_ = events.send(0, model.Document{Type: constants.DOC_TYPE_ERROR, Error: err.Error()})
+				}
+				return rw
+			}
+			if events.send(event.ID, event.Document) != nil {
+				return rw
+			}
+		case <-heartbeat.C:
+			if events.comment("keep-alive") != nil {
+				return rw
+			}
+		case <-shutdown:
+			// EventSource reconnects, possibly to another instance, and resumes
+			_ = events.send(0, model.Document{Type: constants.DOC_TYPE_GOING_AWAY})
+			return rw
+		case <-r.Context().Done():
+			return rw
+		}
+	}
+}
+
+// AuthorizeSubscription checks a subscription to patterns against RegisterDispatcher.PubSub
+// and returns the pub/sub options with defaults.
+func AuthorizeSubscription(register *RegisterDispatcher, patterns []string, security *model.Security) (*model.PubSubOptions, error) {
+	if register == nil || register.PubSub == nil || !register.PubSub.Enabled {
+		return nil, errors.New("topic subscriptions are not enabled")
+	}
+	options := register.PubSub.WithDefaults()
+	if options.Authorize != nil {
+		for _, pattern := range patterns {
+			if err := options.Authorize(pattern, security); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return options, nil
+}
+
+// eventsSecurity reads the security of an events request from the query and the X-Verify-Code header.
+func eventsSecurity(r *http.Request) *model.Security {
+	document := model.Document{}
+	if licence, token := r.URL.Query().Get("licence"), r.URL.Query().Get("token"); licence != "" || token != "" {
+		document.Security = &model.Security{Licence: licence, VerifyCode: token}
+	}
+	inheritVerifyCode(&document, r)
+	InheritPeer(&document, PeerFromContext(r.Context()))
+	return document.Security
+}
+
+// eventWriter writes text/event-stream events and flushes each of them.
+type eventWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func startEvents(w http.ResponseWriter, flusher http.Flusher, opts *model.SSEOptions) *eventWriter {
+	if cc, ok := w.(model.CompressionController); ok {
+		cc.DisableCompression()
+	}
+	w.Header().Set(constants.HTTP_CONTENT_TYPE, constants.HTTP_CONTENT_EVENT_STREAM)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep proxies such as nginx from buffering
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", opts.Retry.Milliseconds())
+	flusher.Flush()
+	return &eventWriter{w: w, flusher: flusher}
+}
+
+// send writes document as an event named after its type. An id of 0 is left out.
+func (e *eventWriter) send(id uint64, document model.Document) error {
+	b, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	var event strings.Builder
+	if id != 0 {
+		fmt.Fprintf(&event, "id: %d\n", id)
+	}
+	fmt.Fprintf(&event, "event: %s\ndata: %s\n\n", document.Type, b)
+	if _, err := e.w.Write([]byte(event.String())); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+func (e *eventWriter) comment(text string) error {
+	if _, err := fmt.Fprintf(e.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+func writeEventsError(w http.ResponseWriter, status int, err error) (rw model.RegisterResponseModel) {
+	response, _ := json.Marshal(model.Document{Error: err.Error(), Type: constants.DOC_TYPE_ERROR})
+	w.Header().Set(constants.HTTP_CONTENT_TYPE, constants.HTTP_CONTENT_JSON)
+	w.WriteHeader(status)
+	rw.StatusCode = status
+	rw.Body = string(response)
+	fmt.Fprint(w, string(response))
+	return rw
+}
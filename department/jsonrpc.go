@@ -0,0 +1,259 @@
+package department
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// JsonRpcMainFunc is a MainFunc that serves JSON-RPC 2.0 payloads over HTTP.
+// Requests run through ExecuteDocument, so middleware, validation and rate limiting
+// are the same as for RegisterMainFunc.
+func JsonRpcMainFunc(w http.ResponseWriter, r *http.Request) (rw model.RegisterResponseModel) {
+	w.Header().Set(constants.HTTP_CONTENT_TYPE, constants.HTTP_CONTENT_JSON)
+	bodyByte, err := io.ReadAll(r.Body)
+	if err != nil {
+		bodyByte = nil
+	}
+	var security *model.Security
+	if vcode := strings.TrimSpace(r.Header.Get("X-Verify-Code")); vcode != "" {
+		security = &model.Security{VerifyCode: vcode}
+	}
+	if peer := PeerFromContext(r.Context()); peer != nil {
+		if security == nil {
+			security = &model.Security{}
+		}
+		security.Peer = peer
+	}
+	var options *model.BatchOptions
+	if rd := RegisterFromContext(r.Context()); rd != nil {
+		options = rd.Batch
+	}
+	disableCompression(w, jsonRpcAddresses(bodyByte)...)
+	response, ok := HandleJsonRpc(bodyByte, security, options)
+	rw.Header = w.Header()
+	if !ok {
+		// only notifications were received
+		w.WriteHeader(http.StatusNoContent)
+		rw.StatusCode = http.StatusNoContent
+		return rw
+	}
+	rw.Body = string(response)
+	fmt.Fprint(w, string(response))
+	return rw
+}
+
+// IsJsonRpcPayload reports whether the payload is a JSON-RPC 2.0 request or batch.
+func IsJsonRpcPayload(payload []byte) bool {
+	type probe struct {
+		JsonRpc string `json:"jsonrpc"`
+	}
+	trimmed := bytes.TrimSpace(payload)
+	if IsBatchPayload(trimmed) {
+		var probes []probe
+		if json.Unmarshal(trimmed, &probes) != nil || len(probes) == 0 {
+			return false
+		}
+		return probes[0].JsonRpc == model.JsonRpcVersion
+	}
+	var p probe
+	return json.Unmarshal(trimmed, &p) == nil && p.JsonRpc == model.JsonRpcVersion
+}
+
+// HandleJsonRpc executes a JSON-RPC 2.0 request or batch and returns the encoded response.
+// security is used for requests that do not carry their own security member; its Peer is set
+// on every request.
+// The boolean is false when nothing must be written back, i.e. the payload only held notifications.
+func HandleJsonRpc(payload []byte, security *model.Security, options *model.BatchOptions) ([]byte, bool) {
+	trimmed := bytes.TrimSpace(payload)
+	if !json.Valid(trimmed) {
+		return marshalJsonRpc(jsonRpcErrorResponse(nil, model.JsonRpcParseError, "parse error"))
+	}
+	if !IsBatchPayload(trimmed) {
+		req, rpcErr := decodeJsonRpcRequest(trimmed)
+		if rpcErr != nil {
+			return marshalJsonRpc(model.JsonRpcResponse{JsonRpc: model.JsonRpcVersion, Error: rpcErr, Id: req.Id})
+		}
+		document, rpcErr := jsonRpcDocument(req, security)
+		if rpcErr != nil {
+			if req.IsNotification() {
+				return nil, false
+			}
+			return marshalJsonRpc(model.JsonRpcResponse{JsonRpc: model.JsonRpcVersion, Error: rpcErr, Id: req.Id})
+		}
+		outputDoc := ExecuteDocument(document)
+		if req.IsNotification() {
+			return nil, false
+		}
+		return marshalJsonRpc(jsonRpcResultResponse(req.Id, outputDoc))
+	}
+
+	var raws []json.RawMessage
+	if err := json.Unmarshal(trimmed, &raws); err != nil || len(raws) == 0 {
+		return marshalJsonRpc(jsonRpcErrorResponse(nil, model.JsonRpcInvalidRequest, "invalid request"))
+	}
+
+	// Resolve every entry first; valid ones are executed together as a dispatcher batch.
+	responses := make([]*model.JsonRpcResponse, len(raws))
+	requests := make([]model.JsonRpcRequest, len(raws))
+	// notifications never get a response, not even an error
+	notifications := make([]bool, len(raws))
+	var documents []model.Document
+	var positions []int
+	for i, raw := range raws {
+		req, rpcErr := decodeJsonRpcRequest(raw)
+		requests[i] = req
+		if rpcErr == nil {
+			notifications[i] = req.IsNotification()
+			var document model.Document
+			document, rpcErr = jsonRpcDocument(req, security)
+			if rpcErr == nil {
+				documents = append(documents, document)
+				positions = append(positions, i)
+				continue
+			}
+		}
+		responses[i] = &model.JsonRpcResponse{JsonRpc: model.JsonRpcVersion, Error: rpcErr, Id: req.Id}
+	}
+	results, err := ExecuteBatch(documents, options)
+	if err != nil {
+		return marshalJsonRpc(jsonRpcErrorResponse(nil, model.JsonRpcInvalidRequest, err.Error()))
+	}
+	for j, outputDoc := range results {
+		i := positions[j]
+		res := jsonRpcResultResponse(requests[i].Id, outputDoc)
+		responses[i] = &res
+	}
+
+	var out []model.JsonRpcResponse
+	for i, res := range responses {
+		if notifications[i] {
+			continue
+		}
+		out = append(out, *res)
+	}
+	if len(out) == 0 {
+		return nil, false
+	}
+	return marshalJsonRpc(out)
+}
+
+// jsonRpcAddresses returns the documents addressed by the methods of a request or batch.
+// Malformed entries are skipped; HandleJsonRpc reports them.
+func jsonRpcAddresses(payload []byte) []model.Document {
+	var reqs []model.JsonRpcRequest
+	trimmed := bytes.TrimSpace(payload)
+	if IsBatchPayload(trimmed) {
+		if json.Unmarshal(trimmed, &reqs) != nil {
+			return nil
+		}
+	} else {
+		var req model.JsonRpcRequest
+		if json.Unmarshal(trimmed, &req) != nil {
+			return nil
+		}
+		reqs = append(reqs, req)
+	}
+	documents := make([]model.Document, 0, len(reqs))
+	for _, req := range reqs {
+		departmentName, transactionName, _ := strings.Cut(req.Method, ".")
+		documents = append(documents, model.Document{Department: departmentName, Transaction: transactionName})
+	}
+	return documents
+}
+
+// decodeJsonRpcRequest decodes a single request object and checks the envelope.
+func decodeJsonRpcRequest(raw []byte) (model.JsonRpcRequest, *model.JsonRpcError) {
+	var req model.JsonRpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return model.JsonRpcRequest{}, &model.JsonRpcError{Code: model.JsonRpcInvalidRequest, Message: "invalid request"}
+	}
+	if req.JsonRpc != model.JsonRpcVersion || strings.TrimSpace(req.Method) == "" {
+		return req, &model.JsonRpcError{Code: model.JsonRpcInvalidRequest, Message: "invalid request"}
+	}
+	return req, nil
+}
+
+// jsonRpcDocument maps "department.transaction" and params onto a dispatcher document.
+func jsonRpcDocument(req model.JsonRpcRequest, security *model.Security) (model.Document, *model.JsonRpcError) {
+	document := model.Document{}
+	departmentName, transactionName, ok := strings.Cut(req.Method, ".")
+	if !ok || DispatcherHolder.GetTransaction(departmentName, transactionName) == nil {
+		return document, &model.JsonRpcError{Code: model.JsonRpcMethodNotFound, Message: "method not found"}
+	}
+	document.Department = departmentName
+	document.Transaction = transactionName
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		if err := json.Unmarshal(req.Params, &document.Form); err != nil {
+			return document, &model.JsonRpcError{Code: model.JsonRpcInvalidParams, Message: "params must be an object"}
+		}
+	}
+	document.Security = req.Security
+	if document.Security == nil && security != nil {
+		sec := *security
+		document.Security = &sec
+	}
+	if security != nil {
+		// the peer comes from the connection, also for requests with their own security
+		InheritPeer(&document, security.Peer)
+	}
+	return document, nil
+}
+
+func jsonRpcResultResponse(id json.RawMessage, outputDoc model.Document) model.JsonRpcResponse {
+	res := model.JsonRpcResponse{JsonRpc: model.JsonRpcVersion, Id: id}
+	if outputDoc.Error != nil || outputDoc.Type == "Error" {
+		message := fmt.Sprint(outputDoc.Error)
+		res.Error = &model.JsonRpcError{Code: jsonRpcErrorCode(message), Message: message}
+		return res
+	}
+	res.Result = outputDoc.Output
+	if res.Result == nil {
+		res.Result = json.RawMessage("null")
+	}
+	return res
+}
+
+// jsonRpcErrorCodes maps the dispatcher error messages in constants onto JSON-RPC error codes.
+// Unknown transactions never get here, they are answered with JsonRpcMethodNotFound before execution.
+var jsonRpcErrorCodes = []struct {
+	format string
+	code   int
+}{
+	{constants.FIELD_NOT_FOUND, model.JsonRpcInvalidParams},
+	{constants.FIELD_CANNOT_BE_EMPTY, model.JsonRpcInvalidParams},
+	{constants.FIELD_NOT_IN_ENUM, model.JsonRpcInvalidParams},
+	{constants.FIELD_TYPE_MISMATCH, model.JsonRpcInvalidParams},
+	{constants.DOCUMENT_PARSING_ERROR, model.JsonRpcInvalidParams},
+	{constants.RATE_LIMIT_EXCEEDED, model.JsonRpcRateLimited},
+}
+
+// jsonRpcErrorCode maps a dispatcher error message onto a JSON-RPC error code.
+// Messages are matched against the text of the format up to its first verb.
+func jsonRpcErrorCode(message string) int {
+	for _, m := range jsonRpcErrorCodes {
+		prefix, _, _ := strings.Cut(m.format, "%")
+		if strings.HasPrefix(message, prefix) {
+			return m.code
+		}
+	}
+	return model.JsonRpcServerError
+}
+
+func jsonRpcErrorResponse(id json.RawMessage, code int, message string) model.JsonRpcResponse {
+	return model.JsonRpcResponse{JsonRpc: model.JsonRpcVersion, Error: &model.JsonRpcError{Code: code, Message: message}, Id: id}
+}
+
+func marshalJsonRpc(v interface{}) ([]byte, bool) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(jsonRpcErrorResponse(nil, model.JsonRpcInternalError, err.Error()))
+	}
+	return b, true
+}
@@ -0,0 +1,127 @@
+package department
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/utilities"
+)
+
+// MockSchema is implemented by transactions declared by a schema file instead of Go types.
+// It replaces the request type checks and the response samples of MockServer.
+type MockSchema interface {
+	ValidateForm(form interface{}) error
+	SampleResponse() interface{}
+}
+
+// MockServer answers the documents of a transaction with generated responses, see model.MockOptions.
+// Documentation and options are those of the wrapped transaction.
+type MockServer struct {
+	Transaction model.ServerInterface
+	Options     *model.MockOptions
+}
+
+func (s MockServer) GetRequest() any                { return s.Transaction.GetRequest() }
+func (s MockServer) GetResponse() any               { return s.Transaction.GetResponse() }
+func (s MockServer) GetOptions() model.ServerOption { return s.Transaction.GetOptions() }
+
+func (s MockServer) Init(document model.Document) model.Document {
+	opts := s.Options.WithDefaults()
+	delay := opts.Latency
+	if opts.Jitter > 0 {
+		delay += rand.N(opts.Jitter)
+	}
+	time.Sleep(delay)
+	if opts.ErrorRate > 0 && rand.Float64() < opts.ErrorRate {
+		return model.Document{Department: document.Department, Transaction: document.Transaction, Error: opts.Error, Type: constants.DOC_TYPE_ERROR}
+	}
+	form, err := json.Marshal(document.Form)
+	if schema, ok := s.Transaction.(MockSchema); ok && err == nil {
+		var value interface{}
+		_ = json.Unmarshal(form, &value)
+		err = schema.ValidateForm(value)
+	} else if err == nil {
+		err = validateMockForm(form, s.Transaction.GetRequest())
+	}
+	if err != nil {
+		return model.Document{Department: document.Department, Transaction: document.Transaction, Error: err.Error(), Type: constants.DOC_TYPE_ERROR}
+	}
+	document.Output = mockOutput(form, s.Transaction)
+	document.Type = constants.DOC_TYPE_RESULT
+	return document
+}
+
+// validateMockForm runs the form validator and decodes the form into the request type,
+// so wrong field types are reported as they would be by the real transaction.
+func validateMockForm(form []byte, request any) error {
+	t := reflect.TypeOf(request)
+	if t == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		validator := model.DocumentFormValidater{Request: string(form)}
+		if err := validator.Validate(reflect.New(t).Elem().Interface()); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal(form, reflect.New(t).Interface()); err != nil {
+		return fmt.Errorf(constants.DOCUMENT_PARSING_ERROR, err)
+	}
+	return nil
+}
+
+// mockOutput returns the response of the example whose request equals the form,
+// else the first example response, else a sample of the response type.
+func mockOutput(form []byte, ta model.ServerInterface) interface{} {
+	examples := ta.GetOptions().Doc.Examples
+	var received interface{}
+	_ = json.Unmarshal(form, &received)
+	for _, example := range examples {
+		b, err := json.Marshal(example.Request)
+		var request interface{}
+		if err == nil && json.Unmarshal(b, &request) == nil && reflect.DeepEqual(request, received) && example.Response != nil {
+			return example.Response
+		}
+	}
+	for _, example := range examples {
+		if example.Response != nil {
+			return example.Response
+		}
+	}
+	if schema, ok := ta.(MockSchema); ok {
+		return schema.SampleResponse()
+	}
+	return utilities.SampleValue(ta.GetResponse())
+}
+
+// mockOptions holds the options of EnableMock, nil while transactions run for real.
+var mockOptions atomic.Pointer[model.MockOptions]
+
+// EnableMock puts every registered transaction in mock mode, or takes them out of it for nil
+// or disabled options. The registry is left as is: each request wraps its transaction in a
+// MockServer, so servers can enable it while others are already serving.
+func EnableMock(options *model.MockOptions) {
+	if options == nil || !options.Enabled {
+		options = nil
+	}
+	mockOptions.Store(options)
+}
+
+// mocked returns the transaction to run for a request: ta itself, or ta in a MockServer in mock mode.
+func mocked(ta model.ServerInterface) model.ServerInterface {
+	if options := mockOptions.Load(); options != nil {
+		if _, ok := ta.(MockServer); !ok {
+			return MockServer{Transaction: ta, Options: options}
+		}
+	}
+	return ta
+}
@@ -1,6 +1,7 @@
 package department
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -25,11 +26,21 @@ func RegisterMainFunc(w http.ResponseWriter, r *http.Request) (rw model.Register
 	var document model.Document
 	ct := r.Header.Get("Content-Type")
 	if strings.HasPrefix(ct, ContentTypeJSON) {
+		bodyByte, err := io.ReadAll(r.Body)
+		if err != nil {
+			rw = WriteErrorDoc(err, w)
+			return rw
+		}
+		r.Body = io.NopCloser(bytes.NewReader(bodyByte))
+		if IsBatchPayload(bodyByte) {
+			return BatchMainFunc(w, r)
+		}
 		docTmp, err := JsonHandler(r)
 		if err != nil {
 			rw = WriteErrorDoc(err, w)
 			return rw
 		}
+		addressFromPath(&docTmp, r)
 		document = docTmp
 	} else if strings.HasPrefix(ct, ContentTypeFormURLEncoded) {
 		docTmp, err := UrlEncodedHandler(r)
@@ -54,38 +65,21 @@ func RegisterMainFunc(w http.ResponseWriter, r *http.Request) (rw model.Register
 		rw = WriteErrorDoc(errors.New("dad content type"), w)
 		return rw
 	}
-	// If document.Security.VerifyCode is empty, try to obtain it from X-Verify-Code header
-	if document.Security == nil || strings.TrimSpace(document.Security.VerifyCode) == "" {
-		if vcode := strings.TrimSpace(r.Header.Get("X-Verify-Code")); vcode != "" {
-			if document.Security == nil {
-				document.Security = &model.Security{}
-			}
-			document.Security.VerifyCode = vcode
-		}
-	}
+	inheritVerifyCode(&document, r)
+	InheritPeer(&document, PeerFromContext(r.Context()))
 	ta := DispatcherHolder.GetTransaction(document.Department, document.Transaction)
 	if ta != nil {
-		outputDoc := (*ta).GetTransaction().Init(document)
+		disableCompression(w, document)
+		if IsStreaming((*ta).GetTransaction()) && acceptsNDJSON(r) {
+			return writeDocumentStream(w, document, (*ta).GetTransaction().GetOptions())
+		}
+		outputDoc := ExecuteDocument(document)
 
 		response, err := json.Marshal(outputDoc)
 		if err != nil {
 			rw = WriteErrorDoc(errors.New("dad content type"), w)
 			return rw
 		}
-
-		if document.Dispatchings != nil {
-			for _, v := range document.Dispatchings {
-				cta := DispatcherHolder.GetTransaction(v.Department, v.Transaction)
-				if cta != nil {
-					dOutputDoc := (*cta).GetTransaction().Init(*v)
-					outputDoc.Dispatchings = append(outputDoc.Dispatchings, &dOutputDoc)
-					//TODO: if Ignored errors add dispatching ignoredError option params in model.document
-					if dOutputDoc.Error != nil {
-						break
-					}
-				}
-			}
-		}
 		options := (*ta).GetTransaction().GetOptions()
 		if &options != nil {
 			for key, _ := range options.Header {
@@ -111,6 +105,67 @@ func RegisterMainFunc(w http.ResponseWriter, r *http.Request) (rw model.Register
 	return rw
 }
 
+// addressFromPath fills the department and transaction of a JSON document posted to
+// /{department}/{transaction} when the body leaves both of them out.
+func addressFromPath(document *model.Document, r *http.Request) {
+	if document.Department != "" || document.Transaction != "" {
+		return
+	}
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) < 2 {
+		return
+	}
+	document.Department = segments[len(segments)-2]
+	document.Transaction = segments[len(segments)-1]
+}
+
+// inheritVerifyCode fills an empty document.Security.VerifyCode from the X-Verify-Code header.
+func inheritVerifyCode(document *model.Document, r *http.Request) {
+	if document.Security == nil || strings.TrimSpace(document.Security.VerifyCode) == "" {
+		if vcode := strings.TrimSpace(r.Header.Get("X-Verify-Code")); vcode != "" {
+			if document.Security == nil {
+				document.Security = &model.Security{}
+			}
+			document.Security.VerifyCode = vcode
+		}
+	}
+}
+
+// InheritPeer sets the credentials of a unix socket peer on the Security of document and of
+// its dispatchings. A nil peer leaves the document unchanged.
+func InheritPeer(document *model.Document, peer *model.PeerCredentials) {
+	if peer == nil {
+		return
+	}
+	security := model.Security{}
+	if document.Security != nil {
+		security = *document.Security
+	}
+	security.Peer = peer
+	document.Security = &security
+	for _, dispatching := range document.Dispatchings {
+		if dispatching != nil {
+			InheritPeer(dispatching, peer)
+		}
+	}
+}
+
+// disableCompression turns response compression off when any of the addressed
+// transactions opts out with TransactionOptions.DisableCompression.
+func disableCompression(w http.ResponseWriter, documents ...model.Document) {
+	cc, ok := w.(model.CompressionController)
+	if !ok {
+		return
+	}
+	for _, document := range documents {
+		ta := DispatcherHolder.GetTransaction(document.Department, document.Transaction)
+		if ta != nil && (*ta).GetTransaction().GetOptions().TransactionOptions.DisableCompression {
+			cc.DisableCompression()
+			return
+		}
+	}
+}
+
 func WriteErrorDoc(err error, w http.ResponseWriter) (rw model.RegisterResponseModel) {
 	outputDoc := model.Document{Error: errors.New("transaction not found").Error(), Type: "Error"}
 	w.WriteHeader(http.StatusBadRequest)
@@ -0,0 +1,108 @@
+package department
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// ExecuteDocumentStream is ExecuteDocument for streaming transactions: emit receives every
+// partial output document before the terminal document is returned. With a nil emit, or for
+// transactions that do not stream, it behaves like ExecuteDocument. Chained dispatchings
+// never stream; they are attached to the terminal document.
+func ExecuteDocumentStream(document model.Document, emit func(model.Document) error) model.Document {
+	ta := DispatcherHolder.GetTransaction(document.Department, document.Transaction)
+	if ta == nil {
+		return model.Document{Department: document.Department, Transaction: document.Transaction, Error: errors.New("transaction not found").Error(), Type: "Error"}
+	}
+	var outputDoc model.Document
+	tx := mocked((*ta).GetTransaction())
+	if server, ok := tx.(model.StreamingServer); ok && emit != nil && server.Streaming() {
+		outputDoc = server.Stream(document, emit)
+	} else {
+		outputDoc = tx.Init(document)
+	}
+
+	// Chain dispatchings if provided
+	if document.Dispatchings != nil {
+		for _, v := range document.Dispatchings {
+			cta := DispatcherHolder.GetTransaction(v.Department, v.Transaction)
+			if cta != nil {
+				dOutputDoc := mocked((*cta).GetTransaction()).Init(*v)
+				outputDoc.Dispatchings = append(outputDoc.Dispatchings, &dOutputDoc)
+				// if an error occurs in a chained dispatching, stop early
+				if dOutputDoc.Error != nil {
+					break
+				}
+			}
+		}
+	}
+	return outputDoc
+}
+
+// IsStreaming reports whether the transaction emits partial output documents.
+// A transaction in mock mode does not stream, but is still reported as streaming.
+func IsStreaming(server model.ServerInterface) bool {
+	if mock, ok := server.(MockServer); ok {
+		server = mock.Transaction
+	}
+	streaming, ok := server.(model.StreamingServer)
+	return ok && streaming.Streaming()
+}
+
+// acceptsNDJSON reports whether a streaming response may be sent as NDJSON. Clients that
+// only accept application/json, such as CallHTTP, get the single collected document instead.
+func acceptsNDJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return true
+	}
+	onlyJSON := false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case constants.HTTP_CONTENT_NDJSON, "*/*", "application/*":
+			return true
+		case constants.HTTP_CONTENT_JSON:
+			onlyJSON = true
+		}
+	}
+	return !onlyJSON
+}
+
+// writeDocumentStream answers a streaming transaction with chunked NDJSON: one line per
+// partial output document, flushed as soon as it is emitted, and the terminal document last.
+func writeDocumentStream(w http.ResponseWriter, document model.Document, options model.ServerOption) (rw model.RegisterResponseModel) {
+	for key := range options.Header {
+		w.Header().Set(key, options.Header.Get(key))
+	}
+	w.Header().Set(constants.HTTP_CONTENT_TYPE, constants.HTTP_CONTENT_NDJSON)
+	flusher, _ := w.(http.Flusher)
+	var lines []json.RawMessage
+	writeLine := func(document model.Document) error {
+		b, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		lines = append(lines, b)
+		return nil
+	}
+	_ = writeLine(ExecuteDocumentStream(document, writeLine))
+	rw.Header = w.Header()
+	rw.Body = lines
+	return rw
+}
@@ -0,0 +1,79 @@
+package model
+
+import "time"
+
+// BalancerStrategy picks the endpoint of a call, see server.Balancer.
+type BalancerStrategy string
+
+const (
+	BalanceRoundRobin     BalancerStrategy = "round-robin"     // endpoints take turns
+	BalanceLeastInFlight  BalancerStrategy = "least-in-flight" // the endpoint with the fewest running calls
+	BalanceConsistentHash BalancerStrategy = "consistent-hash" // the same key goes to the same endpoint
+)
+
+// BalancerOptions tune a server.Balancer.
+type BalancerOptions struct {
+	Strategy BalancerStrategy // defaults to BalanceRoundRobin
+	// HashKey returns the key of a document for BalanceConsistentHash; defaults to the
+	// licence, else the department. Documents without a key take turns.
+	HashKey func(document Document) string
+
+	// MaxFailures consecutive failed calls eject an endpoint, defaults to 5. Failures are
+	// transport errors; error documents of transactions do not count.
+	MaxFailures int
+	// EjectionTime is how long an endpoint is left out, doubled for every further ejection
+	// up to MaxEjectionTime; defaults to 30s and 5m.
+	EjectionTime    time.Duration
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent caps the share of endpoints that are ejected at the same time,
+	// defaults to 50. At least one endpoint always stays.
+	MaxEjectionPercent int
+	// HealthCheckInterval checks every endpoint this often: a Ping control document on the
+	// stream API, an empty document that must get a JSON answer over HTTP. A
+	// failed ping counts like a failed call; an answer does not end an ejection early.
+	// Zero disables active health checks.
+	HealthCheckInterval time.Duration
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+func (o *BalancerOptions) WithDefaults() *BalancerOptions {
+	if o == nil {
+		return (&BalancerOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.Strategy == "" {
+		out.Strategy = BalanceRoundRobin
+	}
+	if out.HashKey == nil {
+		out.HashKey = func(document Document) string {
+			if document.Security != nil && document.Security.Licence != "" {
+				return document.Security.Licence
+			}
+			return document.Department
+		}
+	}
+	if out.MaxFailures <= 0 {
+		out.MaxFailures = 5
+	}
+	if out.EjectionTime <= 0 {
+		out.EjectionTime = 30 * time.Second
+	}
+	if out.MaxEjectionTime <= 0 {
+		out.MaxEjectionTime = 5 * time.Minute
+	}
+	if out.MaxEjectionPercent <= 0 || out.MaxEjectionPercent > 100 {
+		out.MaxEjectionPercent = 50
+	}
+	return &out
+}
+
+// EndpointStatus is a snapshot of an endpoint of a server.Balancer.
+type EndpointStatus struct {
+	Address      string    `json:"address"`
+	InFlight     int       `json:"inFlight"`
+	Requests     int64     `json:"requests"`
+	Failures     int64     `json:"failures"`
+	Consecutive  int       `json:"consecutiveFailures"`
+	Ejected      bool      `json:"ejected"`
+	EjectedUntil time.Time `json:"ejectedUntil,omitzero"`
+}
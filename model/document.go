@@ -33,4 +33,14 @@ type Document struct {
 type Security struct {
 	Licence    string `json:"licence,omitempty"`
 	VerifyCode string `json:"verify_code,omitempty"`
+	// Peer identifies the process on the other end of a unix socket connection. The
+	// server sets it from the connection; it is never read from a request.
+	Peer *PeerCredentials `json:"-"`
+}
+
+// PeerCredentials are the SO_PEERCRED credentials of a unix socket peer (Linux only).
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
 }
@@ -0,0 +1,62 @@
+package model
+
+import "encoding/json"
+
+const JsonRpcVersion = "2.0"
+
+// Standard JSON-RPC 2.0 error codes plus the server error range used for dispatcher errors.
+const (
+	JsonRpcParseError     = -32700
+	JsonRpcInvalidRequest = -32600
+	JsonRpcMethodNotFound = -32601
+	JsonRpcInvalidParams  = -32602
+	JsonRpcInternalError  = -32603
+	JsonRpcServerError    = -32000 // transaction or middleware returned an error
+	JsonRpcRateLimited    = -32001 // rate limiter rejected the request
+)
+
+// JsonRpcRequest is a JSON-RPC 2.0 request object. Method has the form "department.transaction"
+// and Params (an object) becomes Document.Form. Security is an optional extension member.
+type JsonRpcRequest struct {
+	JsonRpc  string          `json:"jsonrpc"`
+	Method   string          `json:"method"`
+	Params   json.RawMessage `json:"params,omitempty"`
+	Id       json.RawMessage `json:"id,omitempty"`
+	Security *Security       `json:"security,omitempty"`
+}
+
+// IsNotification reports whether the request has no id, meaning no response must be sent.
+func (r JsonRpcRequest) IsNotification() bool {
+	return r.Id == nil
+}
+
+type JsonRpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type JsonRpcResponse struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *JsonRpcError   `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+// JSONRPCOptions enables the JSON-RPC 2.0 adapter on the HTTP and stream transports.
+type JSONRPCOptions struct {
+	Enabled bool
+	Path    string // HTTP path of the endpoint, defaults to "/rpc"
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+func (o *JSONRPCOptions) WithDefaults() *JSONRPCOptions {
+	if o == nil {
+		return (&JSONRPCOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.Path == "" {
+		out.Path = "/rpc"
+	}
+	return &out
+}
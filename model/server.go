@@ -2,11 +2,15 @@ package model
 
 import (
 	"net/http"
+	"os"
+	"syscall"
+	"time"
 )
 
 type ServerOption struct {
 	Header             http.Header
 	TransactionOptions TransactionOptions
+	Doc                TransactionDoc
 }
 
 type CORSOptions struct {
@@ -47,3 +51,137 @@ type ServerInterface interface {
 	GetResponse() any
 	GetOptions() ServerOption
 }
+
+// StreamingServer is a ServerInterface that can deliver partial output documents before
+// its terminal document. Streaming reports whether the transaction actually streams.
+type StreamingServer interface {
+	ServerInterface
+	Streaming() bool
+	Stream(document Document, emit func(Document) error) Document
+}
+
+// BatchOptions controls how a JSON array of documents is executed in a single call.
+type BatchOptions struct {
+	MaxSize     int // maximum number of documents accepted in one batch
+	Concurrency int // number of documents executed in parallel
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+func (o *BatchOptions) WithDefaults() *BatchOptions {
+	if o == nil {
+		return (&BatchOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.MaxSize <= 0 {
+		out.MaxSize = 100
+	}
+	if out.Concurrency <= 0 {
+		out.Concurrency = 4
+	}
+	return &out
+}
+
+// CompressionOptions enables gzip/deflate response compression negotiated through Accept-Encoding.
+// Compressed request bodies (Content-Encoding) are decoded and stream clients may switch
+// their connection to deflate mode.
+type CompressionOptions struct {
+	Enabled bool
+	MinSize int // responses smaller than this many bytes are sent uncompressed
+	Level   int // compression level, see compress/flate
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+func (o *CompressionOptions) WithDefaults() *CompressionOptions {
+	if o == nil {
+		return (&CompressionOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.MinSize <= 0 {
+		out.MinSize = 1024
+	}
+	if out.Level == 0 {
+		out.Level = -1 // flate.DefaultCompression
+	}
+	return &out
+}
+
+// CompressionController is implemented by response writers that may compress their output.
+type CompressionController interface {
+	DisableCompression()
+}
+
+// HTTP2Options enables HTTP/2 on the JSON API. Cleartext turns on unencrypted HTTP/2 (h2c
+// with prior knowledge) next to HTTP/1.1; CertFile and KeyFile serve HTTP/2 over TLS.
+type HTTP2Options struct {
+	Cleartext bool
+	CertFile  string
+	KeyFile   string
+}
+
+// TLSEnabled reports whether both a certificate and a key were configured.
+func (o *HTTP2Options) TLSEnabled() bool {
+	return o != nil && o.CertFile != "" && o.KeyFile != ""
+}
+
+// MockOptions answers every transaction with a generated response instead of running it.
+// Requests are still validated. Responses come from the registered examples or are built
+// from the GetResponse() type.
+type MockOptions struct {
+	Enabled   bool
+	Latency   time.Duration // added to every response
+	Jitter    time.Duration // random extra latency of up to Jitter
+	ErrorRate float64       // share of requests, 0 to 1, answered with an Error document
+	Error     string        // message of injected errors
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+func (o *MockOptions) WithDefaults() *MockOptions {
+	if o == nil {
+		return (&MockOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.Error == "" {
+		out.Error = "mock error"
+	}
+	return &out
+}
+
+// ShutdownOptions tunes server.Run: on one of Signals, or when its context ends, the servers
+// stop accepting work and in-flight transactions get Timeout to finish.
+type ShutdownOptions struct {
+	Timeout time.Duration // defaults to 30s
+	Signals []os.Signal   // defaults to SIGINT and SIGTERM
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+func (o *ShutdownOptions) WithDefaults() *ShutdownOptions {
+	if o == nil {
+		return (&ShutdownOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.Timeout <= 0 {
+		out.Timeout = 30 * time.Second
+	}
+	if len(out.Signals) == 0 {
+		out.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	return &out
+}
+
+// UnixSocketOptions apply to the unix sockets opened for "unix:///path" ports.
+type UnixSocketOptions struct {
+	Mode  os.FileMode // permissions of the socket file, defaults to 0660
+	Group string      // group name or ID owning the socket file; empty keeps the process group
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+func (o *UnixSocketOptions) WithDefaults() *UnixSocketOptions {
+	if o == nil {
+		return (&UnixSocketOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.Mode == 0 {
+		out.Mode = 0o660
+	}
+	return &out
+}
@@ -0,0 +1,239 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// StreamProtocolVersion is the newest stream protocol. Connections start with version 1,
+// one request and one response line at a time, and switch to version 2 with a
+// {"type":"Protocol","procedure":2} control document.
+const StreamProtocolVersion = 2
+
+// StreamFrame is a line of stream protocol version 2. Body holds what a version 1 line
+// holds: a document, a batch array or a JSON-RPC payload. Responses carry the ID of their
+// request and may arrive in any order; a response without a body answers a JSON-RPC notification.
+//
+// A request with Stream set calls a streaming transaction: every partial output document is
+// answered with its own frame with More set, and the frame without More ends the call.
+type StreamFrame struct {
+	ID     uint64          `json:"id"`
+	Stream bool            `json:"stream,omitempty"`
+	More   bool            `json:"more,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// StreamOptions tunes the persistent stream API. Zero limits and timeouts are disabled.
+type StreamOptions struct {
+	Concurrency int `json:"concurrency"` // requests of one version 2 connection executed in parallel
+
+	MaxConnections      int `json:"maxConnections"`      // open connections; further ones are answered with an error and closed
+	MaxConnectionsPerIP int `json:"maxConnectionsPerIP"` // open connections of one remote IP
+	// HandshakeTimeout bounds the wait for the first line of a connection, usually the
+	// protocol or compression negotiation.
+	HandshakeTimeout time.Duration `json:"handshakeTimeout"`
+	// IdleTimeout closes a connection that sends nothing for this long while none of its
+	// requests or subscriptions are running.
+	IdleTimeout  time.Duration `json:"idleTimeout"`
+	ReadTimeout  time.Duration `json:"readTimeout"`  // reading the rest of a line once it has started
+	WriteTimeout time.Duration `json:"writeTimeout"` // writing a single response line
+	// MaxFrameSize is the longest accepted line, defaults to 10 MB. A longer line is skipped
+	// and answered with an error, carrying the frame ID when it can be read.
+	MaxFrameSize int `json:"maxFrameSize"`
+	// AcceptBackoff is the first pause after a failed Accept, doubled on every further
+	// failure up to a second; defaults to 5ms.
+	AcceptBackoff time.Duration `json:"acceptBackoff"`
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+func (o *StreamOptions) WithDefaults() *StreamOptions {
+	if o == nil {
+		return (&StreamOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.Concurrency <= 0 {
+		out.Concurrency = 16
+	}
+	if out.MaxFrameSize <= 0 {
+		out.MaxFrameSize = 10 * 1024 * 1024
+	}
+	if out.AcceptBackoff <= 0 {
+		out.AcceptBackoff = 5 * time.Millisecond
+	}
+	return &out
+}
+
+// StreamStats is a snapshot of the counters of a stream API server, with its options, or of
+// all stream API connections of the process.
+type StreamStats struct {
+	Options           StreamOptions `json:"options"`
+	Active            int64         `json:"active"`   // open connections
+	Accepted          int64         `json:"accepted"` // connections served since start
+	Rejected          int64         `json:"rejected"` // connections refused by MaxConnections or MaxConnectionsPerIP
+	HandshakeTimeouts int64         `json:"handshakeTimeouts"`
+	IdleTimeouts      int64         `json:"idleTimeouts"`
+	ReadTimeouts      int64         `json:"readTimeouts"`
+	OversizedFrames   int64         `json:"oversizedFrames"`
+	AcceptErrors      int64         `json:"acceptErrors"`
+}
+
+// StreamPoolStats is a snapshot of the connections and counters of a StreamClientPool.
+type StreamPoolStats struct {
+	Size  int `json:"size"`
+	Open  int `json:"open"`  // connections, in use, idle or being checked
+	InUse int `json:"inUse"` // lent connections; with Multiplex, shared ones with calls in progress
+	Idle  int `json:"idle"`
+
+	Dials        int64         `json:"dials"`
+	DialFailures int64         `json:"dialFailures"`
+	Waits        int64         `json:"waits"`        // acquires that waited for a connection
+	WaitDuration time.Duration `json:"waitDuration"` // total time spent waiting
+	Timeouts     int64         `json:"timeouts"`     // acquires that gave up waiting
+	// connections closed by MaxIdleTime, MaxLifetime and failed health checks
+	IdleClosed          int64 `json:"idleClosed"`
+	LifetimeClosed      int64 `json:"lifetimeClosed"`
+	HealthCheckFailures int64 `json:"healthCheckFailures"`
+}
+
+// StreamClientState is the state of a StreamClient connection, reported to
+// ReconnectOptions.OnStateChange.
+type StreamClientState string
+
+const (
+	StreamClientConnected    StreamClientState = "connected"    // a new connection is ready
+	StreamClientDisconnected StreamClientState = "disconnected" // the connection failed or the server went away
+	StreamClientReconnecting StreamClientState = "reconnecting" // a dial failed, the next one follows after a pause
+	StreamClientClosed       StreamClientState = "closed"       // Close was called
+)
+
+// ReconnectOptions let a StreamClient replace a failed connection (see
+// StreamClient.EnableReconnect).
+type ReconnectOptions struct {
+	InitialBackoff time.Duration // pause after the first failed dial, doubled on every further one; defaults to 100ms
+	MaxBackoff     time.Duration // longest pause, defaults to 10s
+	Jitter         float64       // random share taken off each pause, 0 to 1; defaults to 0.2, negative for none
+	MaxAttempts    int           // dials of one reconnect before the call fails, defaults to 5
+	MaxRetries     int           // times a failed call is sent again; defaults to 2, negative for none
+	// Idempotent reports whether a document may be sent again when the connection failed
+	// after it was written, so the server may have executed it. Without it only calls that
+	// failed before their request was written, or that the server refused because it was
+	// shutting down, are retried.
+	Idempotent func(document Document) bool
+	// OnStateChange is called, on the goroutine that noticed it, whenever the state changes.
+	OnStateChange func(state StreamClientState, err error)
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+// A negative Jitter or MaxRetries becomes 0, which turns them off.
+func (o *ReconnectOptions) WithDefaults() *ReconnectOptions {
+	if o == nil {
+		return (&ReconnectOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.InitialBackoff <= 0 {
+		out.InitialBackoff = 100 * time.Millisecond
+	}
+	if out.MaxBackoff <= 0 {
+		out.MaxBackoff = 10 * time.Second
+	}
+	switch {
+	case out.Jitter < 0:
+		out.Jitter = 0
+	case out.Jitter == 0 || out.Jitter > 1:
+		out.Jitter = 0.2
+	}
+	if out.MaxAttempts <= 0 {
+		out.MaxAttempts = 5
+	}
+	switch {
+	case out.MaxRetries < 0:
+		out.MaxRetries = 0
+	case out.MaxRetries == 0:
+		out.MaxRetries = 2
+	}
+	return &out
+}
+
+// PubSubOptions lets stream clients subscribe to the topics that transactions publish to
+// with pubsub.Publish. Subscriptions need stream protocol version 2.
+type PubSubOptions struct {
+	Enabled bool
+	// Authorize decides whether the Security of a subscribe document may receive the events of
+	// pattern; the returned error is sent to the client. Without it every subscription is allowed.
+	Authorize func(pattern string, security *Security) error
+	Buffer    int // events queued per subscription before a slow subscriber is dropped
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+func (o *PubSubOptions) WithDefaults() *PubSubOptions {
+	if o == nil {
+		return (&PubSubOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.Buffer <= 0 {
+		out.Buffer = 64
+	}
+	return &out
+}
+
+// WebSocketOptions serves the stream protocol version 2 to browsers on the JSON API: every
+// WebSocket text message is a StreamFrame, with the same request IDs, streaming responses and
+// subscriptions as ServStreamApi.
+type WebSocketOptions struct {
+	Enabled bool
+	Path    string // HTTP path of the endpoint, defaults to "/ws"
+	// Authenticate checks the Security of a connection, taken from the token (verify code)
+	// and licence query parameters or from an "Auth" document in the first frame. Without it
+	// connections are not authenticated, but an "Auth" document still sets their Security.
+	Authenticate   func(security *Security) error
+	MaxMessageSize int64 // larger messages close the connection, defaults to 10 MB
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+func (o *WebSocketOptions) WithDefaults() *WebSocketOptions {
+	if o == nil {
+		return (&WebSocketOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.Path == "" {
+		out.Path = "/ws"
+	}
+	if out.MaxMessageSize <= 0 {
+		out.MaxMessageSize = 10 * 1024 * 1024
+	}
+	return &out
+}
+
+// SSEOptions enables the Server-Sent Events endpoint of the JSON API, which streams the
+// documents of a transaction or the events of pubsub topics as text/event-stream.
+type SSEOptions struct {
+	Enabled    bool
+	Path       string        // HTTP path of the endpoint, defaults to "/events"
+	Retry      time.Duration // reconnection delay suggested to clients, defaults to 3s
+	ReplaySize int           // published events kept for Last-Event-ID resumption, defaults to 256
+	Heartbeat  time.Duration // interval of keep-alive comments on idle topic streams, defaults to 15s
+	// RateLimiter limits how often a client may open a topic stream. Transaction streams are
+	// limited by the transaction's own options, as on the other transports.
+	RateLimiter RateLimitOptions
+}
+
+// WithDefaults returns a copy of options where zero-values are filled with sensible defaults.
+func (o *SSEOptions) WithDefaults() *SSEOptions {
+	if o == nil {
+		return (&SSEOptions{}).WithDefaults()
+	}
+	out := *o
+	if out.Path == "" {
+		out.Path = "/events"
+	}
+	if out.Retry <= 0 {
+		out.Retry = 3 * time.Second
+	}
+	if out.ReplaySize <= 0 {
+		out.ReplaySize = 256
+	}
+	if out.Heartbeat <= 0 {
+		out.Heartbeat = 15 * time.Second
+	}
+	return &out
+}
@@ -28,12 +28,28 @@ type RateLimitOptions struct {
 }
 
 type TransactionOptions struct {
-	Security    SecurityOptions  `json:"security,omitempty" yaml:"security"`
-	RateLimiter RateLimitOptions `json:"rate_limiter,omitempty" yaml:"rate_limiter"`
+	Security           SecurityOptions  `json:"security,omitempty" yaml:"security"`
+	RateLimiter        RateLimitOptions `json:"rate_limiter,omitempty" yaml:"rate_limiter"`
+	DisableCompression bool             `json:"disable_compression,omitempty" yaml:"disable_compression"`
 }
 
 func (m TransactionOptions) GetOptions() TransactionOptions {
 	return m
 }
 
+// TransactionDoc documents a transaction in /help and the generated specifications.
+type TransactionDoc struct {
+	Summary     string               `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Description string               `json:"description,omitempty" yaml:"description,omitempty"`
+	Deprecated  bool                 `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	Examples    []TransactionExample `json:"examples,omitempty" yaml:"examples,omitempty"`
+}
+
+// TransactionExample is a request form together with the output it produces.
+type TransactionExample struct {
+	Name     string      `json:"name,omitempty" yaml:"name,omitempty"`
+	Request  interface{} `json:"request,omitempty" yaml:"request,omitempty"`
+	Response interface{} `json:"response,omitempty" yaml:"response,omitempty"`
+}
+
 type LicenceValidator func(licence string) (isValid bool)
@@ -0,0 +1,267 @@
+// Package pubsub delivers events published by transactions to the subscribers of named topics,
+// e.g. the dashboards subscribed through ServStreamApi.
+//
+// Topics are dot separated ("orders.eu.created"). A subscription pattern may use "*" for exactly
+// one segment and a trailing ">" for one or more segments: "orders.*.created", "orders.>".
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// ErrSlowSubscriber ends a subscription whose buffer is full, so a stalled subscriber never
+// holds up a publishing transaction.
+var ErrSlowSubscriber = errors.New("subscription dropped: events were not consumed in time")
+
+// DefaultBroker is the broker used by Publish and the stream API.
+var DefaultBroker = NewBroker()
+
+// Publish sends payload to the subscribers of topic on DefaultBroker.
+func Publish(topic string, payload interface{}) (int, error) {
+	return DefaultBroker.Publish(topic, payload)
+}
+
+// Broker routes published events to matching subscriptions. Events are numbered in
+// publishing order and the most recent ones can be retained for late subscribers, see Retain.
+type Broker struct {
+	mu            sync.Mutex
+	subscriptions map[*Subscription]struct{}
+	lastID        uint64
+	retain        int
+	history       []Event
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscriptions: map[*Subscription]struct{}{}}
+}
+
+// Event is a published "Event" document: Procedure holds the topic and Output the payload.
+// IDs grow by one with every event published on the broker.
+type Event struct {
+	ID       uint64
+	Document model.Document
+
+	fields    map[string]interface{} // top level payload fields, decoded once for filters
+	fieldsErr error
+	decoded   bool
+}
+
+func (e *Event) payloadFields() (map[string]interface{}, error) {
+	if !e.decoded {
+		e.fields, e.fieldsErr = payloadFields(e.Document.Output)
+		e.decoded = true
+	}
+	return e.fields, e.fieldsErr
+}
+
+// Subscription receives the events of the topics matching one of its patterns on C, which is
+// closed when the subscription ends. Filter limits the events to payloads whose top level
+// fields equal the filter's values.
+type Subscription struct {
+	C        <-chan Event
+	Patterns []string
+	Filter   model.DocumentForm
+
+	c      chan Event
+	broker *Broker
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+// Retain keeps the last n published events, so subscribers can resume with SubscribeFrom.
+func (b *Broker) Retain(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retain = n
+	if len(b.history) > n {
+		b.history = append([]Event(nil), b.history[len(b.history)-n:]...)
+	}
+}
+
+// Subscribe registers a subscription to the topics matching pattern. buffer is the number
+// of events queued for the subscriber; when it is exceeded the subscription ends with ErrSlowSubscriber.
+func (b *Broker) Subscribe(pattern string, filter model.DocumentForm, buffer int) (*Subscription, error) {
+	return b.SubscribeFrom([]string{pattern}, filter, buffer, 0)
+}
+
+// SubscribeFrom registers a subscription to the topics matching any of patterns. When after
+// is not 0, the retained events published after the event with that ID are queued first,
+// e.g. to resume a connection that received events up to after.
+func (b *Broker) SubscribeFrom(patterns []string, filter model.DocumentForm, buffer int, after uint64) (*Subscription, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("no topic to subscribe to")
+	}
+	for _, pattern := range patterns {
+		if err := validateTopic(pattern, true); err != nil {
+			return nil, err
+		}
+	}
+	if buffer <= 0 {
+		buffer = 64
+	}
+	s := &Subscription{Patterns: patterns, Filter: filter, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var replay []Event
+	if after != 0 {
+		for i := range b.history {
+			if b.history[i].ID > after && s.matches(&b.history[i]) {
+				replay = append(replay, b.history[i])
+			}
+		}
+	}
+	s.c = make(chan Event, buffer+len(replay))
+	s.C = s.c
+	for _, event := range replay {
+		s.c <- event
+	}
+	b.subscriptions[s] = struct{}{}
+	return s, nil
+}
+
+// Publish sends payload to every matching subscription and returns how many received it.
+func (b *Broker) Publish(topic string, payload interface{}) (int, error) {
+	if err := validateTopic(topic, false); err != nil {
+		return 0, err
+	}
+	delivered := 0
+	var slow []*Subscription
+	b.mu.Lock()
+	b.lastID++
+	event := Event{ID: b.lastID, Document: model.Document{Type: constants.DOC_TYPE_EVENT, Procedure: topic, Output: payload}}
+	if b.retain > 0 {
+		if len(b.history) == b.retain {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, event)
+	}
+	for s := range b.subscriptions {
+		if !s.matches(&event) {
+			continue
+		}
+		if s.offer(event) {
+			delivered++
+		} else {
+			slow = append(slow, s)
+		}
+	}
+	b.mu.Unlock()
+	for _, s := range slow {
+		s.end(ErrSlowSubscriber)
+	}
+	return delivered, nil
+}
+
+func (s *Subscription) matches(event *Event) bool {
+	topic, _ := event.Document.Procedure.(string)
+	for _, pattern := range s.Patterns {
+		if Match(pattern, topic) {
+			if len(s.Filter) == 0 {
+				return true
+			}
+			fields, err := event.payloadFields()
+			return err == nil && matchFilter(s.Filter, fields)
+		}
+	}
+	return false
+}
+
+// offer queues the event without blocking and reports whether it was accepted.
+func (s *Subscription) offer(event Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return true
+	}
+	select {
+	case s.c <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// Cancel ends the subscription and closes C.
+func (s *Subscription) Cancel() {
+	s.end(nil)
+}
+
+// Err returns why the subscription ended: nil after Cancel, ErrSlowSubscriber when it was dropped.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) end(err error) {
+	s.broker.mu.Lock()
+	delete(s.broker.subscriptions, s)
+	s.broker.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	close(s.c)
+}
+
+// Match reports whether topic matches the subscription pattern.
+func Match(pattern, topic string) bool {
+	patternSegments, topicSegments := strings.Split(pattern, "."), strings.Split(topic, ".")
+	for i, segment := range patternSegments {
+		if segment == ">" {
+			return len(topicSegments) > i
+		}
+		if i >= len(topicSegments) || (segment != "*" && segment != topicSegments[i]) {
+			return false
+		}
+	}
+	return len(patternSegments) == len(topicSegments)
+}
+
+func validateTopic(topic string, pattern bool) error {
+	segments := strings.Split(topic, ".")
+	for i, segment := range segments {
+		switch {
+		case segment == "":
+			return fmt.Errorf("invalid topic %q: empty segment", topic)
+		case !pattern && (segment == "*" || segment == ">"):
+			return fmt.Errorf("invalid topic %q: wildcards are only allowed in subscriptions", topic)
+		case segment == ">" && i != len(segments)-1:
+			return fmt.Errorf("invalid topic %q: > must be the last segment", topic)
+		}
+	}
+	return nil
+}
+
+// payloadFields returns the top level fields of the JSON form of payload.
+func payloadFields(payload interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(b, &fields)
+	return fields, err
+}
+
+func matchFilter(filter model.DocumentForm, fields map[string]interface{}) bool {
+	for key, want := range filter {
+		got, ok := fields[key]
+		if !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			return false
+		}
+	}
+	return true
+}
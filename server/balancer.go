@@ -0,0 +1,459 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// Balancer spreads the calls of a replicated service over its endpoints: HTTP addresses
+// (NewHTTPBalancer) or stream API addresses with a StreamClientPool each (NewStreamBalancer).
+// Endpoints whose calls keep failing are ejected for a while, and the endpoints can be
+// replaced at runtime with SetEndpoints. Send has the signature of coordinator.Transport.
+type Balancer struct {
+	options *model.BalancerOptions
+	connect func(address string) (endpointClient, error)
+	next    atomic.Uint64
+	stop    chan struct{}
+
+	mu        sync.RWMutex
+	endpoints []*balancedEndpoint
+	ring      []ringNode // sorted by hash, for BalanceConsistentHash
+	closed    bool
+}
+
+// endpointClient calls one endpoint of a Balancer.
+type endpointClient interface {
+	send(document model.Document) (model.Document, error)
+	ping() error
+	close()
+}
+
+type balancedEndpoint struct {
+	address            string
+	client             endpointClient
+	inflight           atomic.Int32
+	requests, failures atomic.Int64
+	draining           atomic.Bool // removed by SetEndpoints, closed after its last call
+	closeOnce          sync.Once
+
+	mu           sync.Mutex
+	consecutive  int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+func (e *balancedEndpoint) ejected(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return now.Before(e.ejectedUntil)
+}
+
+// drain closes the client of a removed endpoint now or, with calls in flight, after the
+// last of them.
+func (e *balancedEndpoint) drain() {
+	e.draining.Store(true)
+	if e.inflight.Load() == 0 {
+		e.closeClient()
+	}
+}
+
+func (e *balancedEndpoint) closeClient() {
+	e.closeOnce.Do(e.client.close)
+}
+
+type ringNode struct {
+	hash     uint64
+	endpoint *balancedEndpoint
+}
+
+// ringReplicas is the number of points of every endpoint on the hash ring.
+const ringReplicas = 100
+
+// NewHTTPBalancer balances over addresses in the form CallHTTP takes, e.g.
+// "http://orders-1:9000".
+func NewHTTPBalancer(addresses []string, options *model.BalancerOptions) (*Balancer, error) {
+	return newBalancer(addresses, options, func(address string) (endpointClient, error) {
+		return httpEndpoint(address), nil
+	})
+}
+
+// NewStreamBalancer balances over stream API addresses such as "orders-1:9001" or
+// "unix:///run/orders.sock", with a pool per endpoint made by newPool, e.g. to set
+// Multiplex or MinIdle. A nil newPool makes pools of 4 connections.
+func NewStreamBalancer(addresses []string, newPool func(host, port string) (*StreamClientPool, error), options *model.BalancerOptions) (*Balancer, error) {
+	if newPool == nil {
+		newPool = func(host, port string) (*StreamClientPool, error) {
+			return NewStreamClientPool(host, port, 4, 5*time.Second)
+		}
+	}
+	return newBalancer(addresses, options, func(address string) (endpointClient, error) {
+		host, port := address, ""
+		if !isUnixAddress(address) {
+			var err error
+			if host, port, err = net.SplitHostPort(address); err != nil {
+				return nil, err
+			}
+		}
+		pool, err := newPool(host, port)
+		if err != nil {
+			return nil, err
+		}
+		return streamEndpoint{pool: pool}, nil
+	})
+}
+
+func newBalancer(addresses []string, options *model.BalancerOptions, connect func(string) (endpointClient, error)) (*Balancer, error) {
+	b := &Balancer{options: options.WithDefaults(), connect: connect, stop: make(chan struct{})}
+	if err := b.SetEndpoints(addresses); err != nil {
+		return nil, err
+	}
+	if b.options.HealthCheckInterval > 0 {
+		go b.healthChecks()
+	}
+	return b, nil
+}
+
+// SetEndpoints replaces the endpoints, e.g. after a service discovery update. Endpoints that
+// stay keep their connections and state; removed ones are closed once their running calls
+// have finished.
+func (b *Balancer) SetEndpoints(addresses []string) error {
+	if len(addresses) == 0 {
+		return errors.New("a balancer needs at least one endpoint")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errors.New("balancer is closed")
+	}
+	current := map[string]*balancedEndpoint{}
+	for _, e := range b.endpoints {
+		current[e.address] = e
+	}
+	var endpoints, created []*balancedEndpoint
+	for _, address := range addresses {
+		if slices.ContainsFunc(endpoints, func(e *balancedEndpoint) bool { return e.address == address }) {
+			continue
+		}
+		if e, ok := current[address]; ok {
+			endpoints = append(endpoints, e)
+			delete(current, address)
+			continue
+		}
+		client, err := b.connect(address)
+		if err != nil {
+			for _, e := range created {
+				e.client.close()
+			}
+			return fmt.Errorf("endpoint %s: %w", address, err)
+		}
+		e := &balancedEndpoint{address: address, client: client}
+		endpoints = append(endpoints, e)
+		created = append(created, e)
+	}
+	for _, e := range current {
+		e.drain()
+	}
+	b.endpoints = endpoints
+	b.ring = b.ring[:0]
+	for _, e := range endpoints {
+		for i := range ringReplicas {
+			b.ring = append(b.ring, ringNode{hash: ringHash(e.address + "#" + strconv.Itoa(i)), endpoint: e})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+	return nil
+}
+
+// Send calls the endpoint chosen by the strategy. A call that failed before it reached the
+// endpoint, e.g. because the connection was refused, is tried on another endpoint.
+func (b *Balancer) Send(document model.Document) (model.Document, error) {
+	tried := map[*balancedEndpoint]bool{}
+	var lastErr error
+	for {
+		e, err := b.pick(document, tried)
+		if e == nil {
+			if lastErr != nil {
+				return model.Document{}, lastErr
+			}
+			return model.Document{}, err
+		}
+		tried[e] = true
+		e.requests.Add(1)
+		out, err := e.client.send(document)
+		if e.inflight.Add(-1) == 0 && e.draining.Load() {
+			e.closeClient()
+		}
+		// error documents of transactions come from a working endpoint
+		failed := err != nil && out.Type != constants.DOC_TYPE_ERROR
+		b.record(e, failed)
+		var notSent errNotSent
+		if !failed || !errors.As(err, &notSent) {
+			return out, err
+		}
+		lastErr = err
+	}
+}
+
+// pick returns the endpoint for document among those not tried yet and counts the call as
+// in flight, while b.mu keeps SetEndpoints from closing the endpoint under it.
+func (b *Balancer) pick(document model.Document, tried map[*balancedEndpoint]bool) (*balancedEndpoint, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	e, err := b.chooseLocked(document, tried)
+	if e != nil {
+		e.inflight.Add(1)
+	}
+	return e, err
+}
+
+// chooseLocked returns the endpoint for document among those not tried yet; b.mu is held.
+// Ejected endpoints are skipped while others are left; otherwise the one whose ejection
+// ends first is used.
+func (b *Balancer) chooseLocked(document model.Document, tried map[*balancedEndpoint]bool) (*balancedEndpoint, error) {
+	if b.closed {
+		return nil, errors.New("balancer is closed")
+	}
+	now := time.Now()
+	available := func(e *balancedEndpoint) bool { return !tried[e] && !e.ejected(now) }
+	n := len(b.endpoints)
+	switch b.options.Strategy {
+	case model.BalanceConsistentHash:
+		if key := b.options.HashKey(document); key != "" {
+			h := ringHash(key)
+			start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+			for i := range b.ring {
+				if e := b.ring[(start+i)%len(b.ring)].endpoint; available(e) {
+					return e, nil
+				}
+			}
+			return b.fallback(tried), nil
+		}
+	case model.BalanceLeastInFlight:
+		start := int(b.next.Add(1) % uint64(n))
+		var best *balancedEndpoint
+		for i := range n {
+			e := b.endpoints[(start+i)%n]
+			if available(e) && (best == nil || e.inflight.Load() < best.inflight.Load()) {
+				best = e
+			}
+		}
+		if best != nil {
+			return best, nil
+		}
+		return b.fallback(tried), nil
+	}
+	start := int(b.next.Add(1) % uint64(n))
+	for i := range n {
+		if e := b.endpoints[(start+i)%n]; available(e) {
+			return e, nil
+		}
+	}
+	return b.fallback(tried), nil
+}
+
+// fallback returns the endpoint not tried yet whose ejection ends first, or nil.
+func (b *Balancer) fallback(tried map[*balancedEndpoint]bool) *balancedEndpoint {
+	var best *balancedEndpoint
+	var bestUntil time.Time
+	for _, e := range b.endpoints {
+		if tried[e] {
+			continue
+		}
+		e.mu.Lock()
+		until := e.ejectedUntil
+		e.mu.Unlock()
+		if best == nil || until.Before(bestUntil) {
+			best, bestUntil = e, until
+		}
+	}
+	return best
+}
+
+// record counts the outcome of a call and ejects an endpoint after MaxFailures failures in a row.
+func (b *Balancer) record(e *balancedEndpoint, failed bool) {
+	e.mu.Lock()
+	if !failed {
+		e.consecutive = 0
+		e.mu.Unlock()
+		return
+	}
+	e.failures.Add(1)
+	e.consecutive++
+	due := e.consecutive >= b.options.MaxFailures && !time.Now().Before(e.ejectedUntil)
+	e.mu.Unlock()
+	if due {
+		b.eject(e)
+	}
+}
+
+// eject leaves e out for EjectionTime, doubled for every earlier ejection, unless that
+// would exceed MaxEjectionPercent or leave no endpoint.
+func (b *Balancer) eject(e *balancedEndpoint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	ejected := 0
+	for _, other := range b.endpoints {
+		if other.ejected(now) {
+			ejected++
+		}
+	}
+	n := len(b.endpoints)
+	if ejected+1 >= n || (ejected+1)*100 > b.options.MaxEjectionPercent*n {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ejections++
+	timeout := b.options.EjectionTime
+	for i := 1; i < e.ejections && timeout < b.options.MaxEjectionTime; i++ {
+		timeout *= 2
+	}
+	e.ejectedUntil = now.Add(min(timeout, b.options.MaxEjectionTime))
+	e.consecutive = 0
+}
+
+// healthChecks pings every endpoint each HealthCheckInterval until Close.
+func (b *Balancer) healthChecks() {
+	ticker := time.NewTicker(b.options.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.stop:
+			return
+		}
+		b.mu.RLock()
+		endpoints := slices.Clone(b.endpoints)
+		b.mu.RUnlock()
+		var wg sync.WaitGroup
+		for _, e := range endpoints {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := e.client.ping(); err != nil {
+					b.record(e, true)
+					return
+				}
+				// failures of calls keep counting, and an ejection runs its full time
+				e.mu.Lock()
+				if !time.Now().Before(e.ejectedUntil) {
+					e.ejectedUntil = time.Time{}
+				}
+				e.mu.Unlock()
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// Endpoints returns the state of every endpoint.
+func (b *Balancer) Endpoints() []model.EndpointStatus {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	now := time.Now()
+	statuses := make([]model.EndpointStatus, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		status := model.EndpointStatus{
+			Address:  e.address,
+			InFlight: int(e.inflight.Load()),
+			Requests: e.requests.Load(),
+			Failures: e.failures.Load(),
+		}
+		e.mu.Lock()
+		status.Consecutive = e.consecutive
+		if now.Before(e.ejectedUntil) {
+			status.Ejected, status.EjectedUntil = true, e.ejectedUntil
+		}
+		e.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Close stops the health checks and closes the connections of every endpoint.
+func (b *Balancer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.stop)
+	for _, e := range b.endpoints {
+		e.closeClient()
+	}
+	return nil
+}
+
+// ringHash spreads keys and ring points evenly: FNV-1a followed by the finalizer of MurmurHash3.
+func ringHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return x
+}
+
+// httpEndpoint calls an address with CallHTTP.
+type httpEndpoint string
+
+func (a httpEndpoint) send(document model.Document) (model.Document, error) {
+	out, err := CallHTTP(string(a), document)
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		err = errNotSent{err}
+	}
+	return out, err
+}
+
+// ping posts an empty document. The HTTP API has no ping, but any JSON answer, such as the
+// error document for an unknown transaction, shows that the endpoint works.
+func (a httpEndpoint) ping() error {
+	_, err := CallHTTP(string(a), model.Document{})
+	return err
+}
+
+func (httpEndpoint) close() {}
+
+// streamEndpoint calls a stream API address through a pool.
+type streamEndpoint struct {
+	pool *StreamClientPool
+}
+
+func (s streamEndpoint) send(document model.Document) (model.Document, error) {
+	c, err := s.pool.Acquire()
+	if err != nil {
+		return model.Document{}, errNotSent{err}
+	}
+	out, err := c.Send(document)
+	s.pool.Release(c, err)
+	return out, err
+}
+
+func (s streamEndpoint) ping() error {
+	c, err := s.pool.Acquire()
+	if err != nil {
+		return err
+	}
+	err = c.Ping()
+	s.pool.Release(c, err)
+	return err
+}
+
+func (s streamEndpoint) close() {
+	_ = s.pool.Close()
+}
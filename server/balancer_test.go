@@ -0,0 +1,328 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// balancedServer serves the registered transactions and counts its requests; while broken
+// is set it answers every request with a bare 500.
+type balancedServer struct {
+	*httptest.Server
+	hits   atomic.Int32
+	broken atomic.Bool
+}
+
+func newBalancedServer(t *testing.T) *balancedServer {
+	t.Helper()
+	s := &balancedServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits.Add(1)
+		if s.broken.Load() {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		department.RegisterMainFunc(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func echoDocument(licence string) model.Document {
+	return model.Document{Department: "Test", Transaction: "echo", Security: &model.Security{Licence: licence}, Form: model.DocumentForm{"n": 1}}
+}
+
+func TestBalancer_RoundRobin(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	servers := []*balancedServer{newBalancedServer(t), newBalancedServer(t), newBalancedServer(t)}
+	b, err := NewHTTPBalancer([]string{servers[0].URL, servers[1].URL, servers[2].URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for range 9 {
+		out, err := b.Send(echoDocument(""))
+		if err != nil || out.Type != "Result" {
+			t.Fatalf("unexpected answer: %+v, %v", out, err)
+		}
+	}
+	for i, s := range servers {
+		if s.hits.Load() != 3 {
+			t.Errorf("server %d got %d of 9 calls, want 3", i, s.hits.Load())
+		}
+	}
+}
+
+func TestBalancer_ConsistentHash(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	servers := []*balancedServer{newBalancedServer(t), newBalancedServer(t), newBalancedServer(t)}
+	b, err := NewHTTPBalancer([]string{servers[0].URL, servers[1].URL, servers[2].URL}, &model.BalancerOptions{Strategy: model.BalanceConsistentHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	// served returns the index of the server that answered the call for licence
+	served := func(licence string) int {
+		before := make([]int32, len(servers))
+		for i, s := range servers {
+			before[i] = s.hits.Load()
+		}
+		if _, err := b.Send(echoDocument(licence)); err != nil {
+			t.Fatal(err)
+		}
+		for i, s := range servers {
+			if s.hits.Load() != before[i] {
+				return i
+			}
+		}
+		t.Fatal("no server was called")
+		return -1
+	}
+
+	owners := map[string]int{}
+	used := map[int]bool{}
+	for i := range 30 {
+		licence := "licence-" + strconv.Itoa(i)
+		owners[licence] = served(licence)
+		used[owners[licence]] = true
+		if again := served(licence); again != owners[licence] {
+			t.Fatalf("%s moved from server %d to %d", licence, owners[licence], again)
+		}
+	}
+	if len(used) != len(servers) {
+		t.Errorf("30 keys must spread over every server, used %d", len(used))
+	}
+
+	if err := b.SetEndpoints([]string{servers[0].URL, servers[1].URL}); err != nil {
+		t.Fatal(err)
+	}
+	for licence, owner := range owners {
+		if got := served(licence); owner != 2 && got != owner {
+			t.Errorf("%s moved from server %d to %d although its server stayed", licence, owner, got)
+		}
+	}
+}
+
+func TestBalancer_LeastInFlight(t *testing.T) {
+	b, err := NewHTTPBalancer([]string{"http://a", "http://b", "http://c"}, &model.BalancerOptions{Strategy: model.BalanceLeastInFlight})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	b.endpoints[0].inflight.Store(2)
+	b.endpoints[2].inflight.Store(1)
+	for range 5 {
+		if e, _ := b.chooseLocked(model.Document{}, nil); e.address != "http://b" {
+			t.Fatalf("picked %s with %d calls in flight", e.address, e.inflight.Load())
+		}
+	}
+}
+
+func TestBalancer_EjectsDeadEndpoint(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	live := []*balancedServer{newBalancedServer(t), newBalancedServer(t)}
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	b, err := NewHTTPBalancer([]string{dead.URL, live[0].URL, live[1].URL}, &model.BalancerOptions{MaxFailures: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for range 10 {
+		// a refused connection is tried on the next endpoint
+		if out, err := b.Send(echoDocument("")); err != nil || out.Type != "Result" {
+			t.Fatalf("unexpected answer: %+v, %v", out, err)
+		}
+	}
+	status := b.Endpoints()
+	if !status[0].Ejected || status[0].Failures != 1 {
+		t.Errorf("the dead endpoint must be ejected after its first failure: %+v", status[0])
+	}
+	if status[1].Ejected || status[2].Ejected {
+		t.Errorf("live endpoints must stay: %+v", status)
+	}
+	if live[0].hits.Load()+live[1].hits.Load() != 10 {
+		t.Errorf("live endpoints must answer every call, got %d", live[0].hits.Load()+live[1].hits.Load())
+	}
+}
+
+func TestBalancer_HealthCheck(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	failing, healthy := newBalancedServer(t), newBalancedServer(t)
+	failing.broken.Store(true)
+	b, err := NewHTTPBalancer([]string{failing.URL, healthy.URL}, &model.BalancerOptions{MaxFailures: 2, EjectionTime: 300 * time.Millisecond, HealthCheckInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	failures := 0
+	for range 10 {
+		if _, err := b.Send(echoDocument("")); err != nil {
+			failures++
+		}
+	}
+	if failures > 2 {
+		t.Errorf("the failing endpoint must be ejected after 2 failures, %d calls failed", failures)
+	}
+	if !b.Endpoints()[0].Ejected {
+		t.Fatalf("the failing endpoint must be ejected: %+v", b.Endpoints()[0])
+	}
+
+	failing.broken.Store(false)
+	time.Sleep(100 * time.Millisecond)
+	if !b.Endpoints()[0].Ejected {
+		t.Error("a successful health check must not end an ejection early")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Endpoints()[0].Ejected {
+		if time.Now().After(deadline) {
+			t.Fatal("the endpoint must come back after its ejection time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	before := failing.hits.Load()
+	for range 4 {
+		if _, err := b.Send(echoDocument("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if failing.hits.Load() == before {
+		t.Error("a restored endpoint must receive calls again")
+	}
+}
+
+func TestBalancer_KeepsOneEndpoint(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	s := newBalancedServer(t)
+	s.broken.Store(true)
+	b, err := NewHTTPBalancer([]string{s.URL}, &model.BalancerOptions{MaxFailures: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	for range 3 {
+		_, _ = b.Send(echoDocument(""))
+	}
+	if b.Endpoints()[0].Ejected || s.hits.Load() != 3 {
+		t.Errorf("the last endpoint must never be ejected: %+v, %d calls", b.Endpoints()[0], s.hits.Load())
+	}
+}
+
+func TestBalancer_Stream(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	port1, conns1 := poolServer(t)
+	port2, conns2 := poolServer(t)
+	b, err := NewStreamBalancer([]string{net.JoinHostPort("127.0.0.1", port1), net.JoinHostPort("127.0.0.1", port2)}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 6 {
+		if out, err := b.Send(echoDocument("")); err != nil || out.Type != "Result" {
+			t.Fatalf("unexpected answer: %+v, %v", out, err)
+		}
+	}
+	if len(conns1) == 0 || len(conns2) == 0 {
+		t.Errorf("calls must be spread over both servers: %d and %d connections", len(conns1), len(conns2))
+	}
+	for _, status := range b.Endpoints() {
+		if status.Requests != 3 {
+			t.Errorf("%s got %d of 6 calls, want 3", status.Address, status.Requests)
+		}
+	}
+	b.Close()
+	if _, err := b.Send(echoDocument("")); err == nil {
+		t.Error("a closed balancer must refuse calls")
+	}
+}
+
+// blockingEndpoint answers a call once release is closed and records whether it was closed.
+type blockingEndpoint struct {
+	release chan struct{}
+	closed  atomic.Bool
+}
+
+func (e *blockingEndpoint) send(document model.Document) (model.Document, error) {
+	<-e.release
+	if e.closed.Load() {
+		return model.Document{}, errors.New("endpoint closed during the call")
+	}
+	return model.Document{Type: "Result"}, nil
+}
+
+func (e *blockingEndpoint) ping() error { return nil }
+func (e *blockingEndpoint) close()      { e.closed.Store(true) }
+
+func TestBalancer_SetEndpointsDrains(t *testing.T) {
+	clients := map[string]*blockingEndpoint{}
+	b, err := newBalancer([]string{"a"}, nil, func(address string) (endpointClient, error) {
+		clients[address] = &blockingEndpoint{release: make(chan struct{})}
+		return clients[address], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Send(model.Document{})
+		done <- err
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Endpoints()[0].InFlight == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the call did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := b.SetEndpoints([]string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+	if clients["a"].closed.Load() {
+		t.Fatal("a removed endpoint must stay open while a call is running")
+	}
+	close(clients["a"].release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !clients["a"].closed.Load() {
+		t.Error("a removed endpoint must be closed after its last call")
+	}
+
+	if err := b.SetEndpoints([]string{"c"}); err != nil {
+		t.Fatal(err)
+	}
+	if !clients["b"].closed.Load() {
+		t.Error("a removed endpoint without calls must be closed at once")
+	}
+}
+
+func TestBalancer_HTTPPing(t *testing.T) {
+	s := newBalancedServer(t)
+	if err := httpEndpoint(s.URL).ping(); err != nil {
+		t.Errorf("an endpoint that answers with a document must pass: %v", err)
+	}
+	s.broken.Store(true)
+	if err := httpEndpoint(s.URL).ping(); err == nil {
+		t.Error("an endpoint that does not answer with a document must fail")
+	}
+}
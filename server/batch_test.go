@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+type echoServer struct {
+	model.ServerInterface
+}
+
+func (echoServer) Init(document model.Document) model.Document {
+	if document.Form["fail"] != nil {
+		return model.Document{Department: document.Department, Transaction: document.Transaction, Error: errors.New("failed").Error(), Type: "Error"}
+	}
+	document.Output = document.Form
+	document.Type = "Result"
+	return document
+}
+func (echoServer) GetRequest() any                { return struct{}{} }
+func (echoServer) GetResponse() any               { return struct{}{} }
+func (echoServer) GetOptions() model.ServerOption { return model.ServerOption{} }
+
+func registerEcho() {
+	department.DispatcherHolder = nil
+	department.DispatcherHolder.Add("Test", transaction.TransactionBucketItem{Name: "echo", Transaction: echoServer{}})
+}
+
+func TestRegisterMainFunc_Batch(t *testing.T) {
+	registerEcho()
+	body := `[
+		{"department":"Test","transaction":"echo","form":{"n":1}},
+		{"department":"Test","transaction":"echo","form":{"fail":true}},
+		{"department":"Test","transaction":"missing"},
+		{"department":"Test","transaction":"echo","form":{"n":4}}
+	]`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	department.RegisterMainFunc(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rr.Code)
+	}
+	var results []model.Document
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+		t.Fatalf("response is not an array: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	wantTypes := []string{"Result", "Error", "Error", "Result"}
+	for i, want := range wantTypes {
+		if results[i].Type != want {
+			t.Errorf("result %d: got type %q want %q", i, results[i].Type, want)
+		}
+	}
+	if out, _ := results[3].Output.(map[string]interface{}); out["n"] != float64(4) {
+		t.Errorf("results are not in request order: %v", results[3].Output)
+	}
+}
+
+func TestExecuteBatch_MaxSize(t *testing.T) {
+	registerEcho()
+	docs := make([]model.Document, 3)
+	if _, err := department.ExecuteBatch(docs, &model.BatchOptions{MaxSize: 2}); err == nil {
+		t.Errorf("expected batch size error")
+	}
+}
+
+func TestRegisterMainFunc_AddressFromPath(t *testing.T) {
+	registerEcho()
+	cases := map[string]string{
+		`{"form":{"n":1}}`:   `"type":"Result"`,
+		`[{"form":{"n":1}}]`: `"type":"Result"`,
+		// an address in the body wins over the path
+		`{"department":"Test","transaction":"missing"}`: `"type":"Error"`,
+	}
+	for body, want := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/Test/echo", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		department.RegisterMainFunc(rr, req)
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("%s: got %s want %s", body, rr.Body.String(), want)
+		}
+	}
+}
+
+func TestBatchMainFunc_TooLarge(t *testing.T) {
+	registerEcho()
+	body := `[{"department":"Test","transaction":"echo"},{"department":"Test","transaction":"echo"}]`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(department.ContextWithRegister(req.Context(), &department.RegisterDispatcher{Batch: &model.BatchOptions{MaxSize: 1}}))
+	rr := httptest.NewRecorder()
+
+	department.RegisterMainFunc(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected status: got %d want %d", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+	if !strings.Contains(rr.Body.String(), `"type":"Error"`) {
+		t.Errorf("expected an error document, got %s", rr.Body.String())
+	}
+}
+
+func TestStreamClient_SendBatch(t *testing.T) {
+	registerEcho()
+	serverConn, clientConn := net.Pipe()
+	go handleStreamConn(serverConn, &department.RegisterDispatcher{Batch: &model.BatchOptions{MaxSize: 2}})
+	cli := &StreamClient{conn: clientConn, reader: bufio.NewReader(clientConn)}
+	defer cli.Close()
+
+	results, err := cli.SendBatch([]model.Document{
+		{Department: "Test", Transaction: "echo", Form: model.DocumentForm{"n": 1}},
+		{Department: "Test", Transaction: "missing"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Type != "Result" || results[1].Type != "Error" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+
+	// the whole batch is rejected when it exceeds MaxSize
+	_, err = cli.SendBatch(make([]model.Document, 3))
+	if err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("expected rejected batch error, got %v", err)
+	}
+
+	// the connection stays usable after a rejected batch
+	if _, err := cli.Send(model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Errorf("connection unusable after rejected batch: %v", err)
+	}
+}
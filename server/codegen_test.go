@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+func registerCodegen() {
+	registerDocumented()
+	department.DispatcherHolder.Add("Shop", transaction.TransactionBucketItem{
+		Name:        "item",
+		Transaction: mockServer{request: schemaItem{}, response: openAPINode{}},
+	})
+}
+
+func helpBody(t *testing.T, query string) []byte {
+	rr := httptest.NewRecorder()
+	ApiDocServer{Title: "Shop API"}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help?"+query, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("%s: unexpected status %d", query, rr.Code)
+	}
+	return rr.Body.Bytes()
+}
+
+type orderFilter struct {
+	Status string   `json:"status" require:"true" enum:"open,shipped,cancelled"`
+	Limit  int      `json:"limit,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+func TestApiDocServer_TypeScript(t *testing.T) {
+	registerCodegen()
+	department.DispatcherHolder.Add("Orders", transaction.TransactionBucketItem{
+		Name:        "list",
+		Transaction: mockServer{request: orderFilter{}, response: []openAPINode{}},
+	})
+	defer func() { department.DispatcherHolder = nil }()
+
+	rr := httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help?format=ts", nil))
+	if ct := rr.Header().Get("Content-Type"); ct != "application/typescript" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	for _, want := range []string{"export class Client", `ordersList(form: OrderFilter): Promise<OpenAPINode[]>`} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("typescript misses %q\n%s", want, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/godispatcher/dispatcher/model"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// withCompression decodes compressed request bodies and compresses responses with the
+// encoding negotiated from Accept-Encoding. Responses below MinSize are sent as is.
+func withCompression(next http.Handler, opts *model.CompressionOptions) http.Handler {
+	options := opts.WithDefaults()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ce := strings.TrimSpace(r.Header.Get("Content-Encoding")); ce != "" && !strings.EqualFold(ce, "identity") {
+			body, err := decodeBody(ce, r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+				return
+			}
+			r.Body = body
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: options.MinSize, level: options.Level}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// decodeBody wraps body with a decoder for the given Content-Encoding.
+func decodeBody(encoding string, body io.ReadCloser) (io.ReadCloser, error) {
+	switch strings.ToLower(encoding) {
+	case encodingGzip, "x-gzip":
+		return gzip.NewReader(body)
+	case encodingDeflate:
+		return zlib.NewReader(body)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header, honouring q-values.
+// "*" only applies to codings that are not listed explicitly, and q=0 rules a coding out.
+// On equal q-values gzip is preferred.
+func negotiateEncoding(acceptEncoding string) string {
+	qvalues := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = parsed
+			}
+		}
+		qvalues[name] = q
+	}
+	best, bestQ := "", 0.0
+	for _, name := range []string{encodingGzip, encodingDeflate} {
+		q, ok := qvalues[name]
+		if !ok {
+			q = qvalues["*"]
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the response until MinSize bytes are written, then decides whether
+// to compress it. Flush commits the decision early so streamed responses are not held back.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding  string
+	minSize   int
+	level     int
+	buf       []byte
+	status    int
+	disabled  bool
+	committed bool
+	encoder   io.WriteCloser
+}
+
+// DisableCompression implements model.CompressionController.
+func (cw *compressWriter) DisableCompression() {
+	cw.disabled = true
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.committed {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = statusCode
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.committed {
+		if cw.encoder != nil {
+			return cw.encoder.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.commit(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.committed {
+		_ = cw.commit(len(cw.buf) >= cw.minSize)
+	}
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// commit writes the status line and the buffered data, starting the encoder if compress is set.
+func (cw *compressWriter) commit(compress bool) error {
+	cw.committed = true
+	status := cw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := cw.Header()
+	if compress && !cw.disabled && header.Get("Content-Encoding") == "" &&
+		status != http.StatusNoContent && status != http.StatusNotModified {
+		var err error
+		switch cw.encoding {
+		case encodingGzip:
+			cw.encoder, err = gzip.NewWriterLevel(cw.ResponseWriter, cw.level)
+		case encodingDeflate:
+			cw.encoder, err = zlib.NewWriterLevel(cw.ResponseWriter, cw.level)
+		default:
+			err = errors.New("unsupported encoding")
+		}
+		if err != nil {
+			cw.encoder = nil
+		} else {
+			header.Set("Content-Encoding", cw.encoding)
+			header.Del("Content-Length")
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) close() {
+	if !cw.committed {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// nothing was written; let net/http send its default response
+			return
+		}
+		_ = cw.commit(len(cw.buf) >= cw.minSize)
+	}
+	if cw.encoder != nil {
+		_ = cw.encoder.Close()
+	}
+}
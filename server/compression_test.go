@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+func TestWithCompression(t *testing.T) {
+	large := strings.Repeat("a", 2048)
+	handler := withCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/optout" {
+			w.(model.CompressionController).DisableCompression()
+		}
+		if r.URL.Path == "/small" {
+			w.Write([]byte("small"))
+			return
+		}
+		w.Write(body)
+	}), &model.CompressionOptions{Enabled: true})
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(large))
+	zw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/", &gz)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept-Encoding", "deflate;q=0.5, gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip response, got %q", rr.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(zr); string(b) != large {
+		t.Errorf("round trip mismatch")
+	}
+
+	for _, path := range []string{"/small", "/optout"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(large))
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: expected uncompressed response", path)
+		}
+	}
+}
+
+// rawServer is an echo transaction that opts out of response compression.
+type rawServer struct {
+	echoServer
+}
+
+func (rawServer) GetOptions() model.ServerOption {
+	return model.ServerOption{TransactionOptions: model.TransactionOptions{DisableCompression: true}}
+}
+
+func TestWithCompression_TransactionOptOut(t *testing.T) {
+	registerEcho()
+	department.DispatcherHolder.Add("Test", transaction.TransactionBucketItem{Name: "raw", Transaction: rawServer{}})
+	handler := withCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		department.RegisterMainFunc(w, r)
+	}), &model.CompressionOptions{Enabled: true, MinSize: 1})
+
+	large := strings.Repeat("a", 2048)
+	cases := map[string]string{
+		`{"department":"Test","transaction":"echo","form":{"s":"` + large + `"}}`:                "gzip",
+		`{"department":"Test","transaction":"raw","form":{"s":"` + large + `"}}`:                 "",
+		`[{"department":"Test","transaction":"echo"},{"department":"Test","transaction":"raw"}]`: "",
+	}
+	for body, want := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if got := rr.Header().Get("Content-Encoding"); got != want {
+			t.Errorf("%.60s: got Content-Encoding %q want %q", body, got, want)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                         "",
+		"identity":                 "",
+		"gzip":                     "gzip",
+		"deflate":                  "deflate",
+		"GZIP, deflate":            "gzip",
+		"gzip;q=0.5, deflate":      "deflate",
+		"gzip;q=0":                 "",
+		"gzip;q=0, deflate;q=0":    "",
+		"*":                        "gzip",
+		"*;q=0":                    "",
+		"gzip;q=0, *":              "deflate",
+		"gzip;q=0, deflate;q=0, *": "",
+		"identity;q=0, deflate":    "deflate",
+		"br, identity":             "",
+		"br, *;q=0.1":              "gzip",
+	}
+	for header, want := range cases {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("%q: got %q want %q", header, got, want)
+		}
+	}
+}
+
+func TestStreamCompression(t *testing.T) {
+	registerEcho()
+	serverConn, clientConn := net.Pipe()
+	register := &department.RegisterDispatcher{Compression: &model.CompressionOptions{Enabled: true}}
+	go handleStreamConn(serverConn, register)
+
+	cli := &StreamClient{conn: clientConn, reader: bufio.NewReader(clientConn)}
+	defer cli.Close()
+	if err := cli.EnableCompression(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := cli.Send(model.Document{Department: "Test", Transaction: "echo", Form: model.DocumentForm{"n": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, _ := resp.Output.(map[string]interface{}); out["n"] != float64(i) {
+			t.Errorf("unexpected output: %v", resp.Output)
+		}
+	}
+}
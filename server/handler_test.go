@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/logger"
+)
+
+func TestNewHandler_Prefix(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	var logged atomic.Int32
+	register := department.NewRegisteryDispatcher("")
+	register.PathPrefix = "orders/"
+	register.JSONRPC = &model.JSONRPCOptions{Enabled: true}
+	register.LoggerWriter = func(logger.LogEntry) error {
+		logged.Add(1)
+		return nil
+	}
+	// an existing router with its own routes
+	handler, err := NewHandler(register, &ApiDocServer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := http.NewServeMux()
+	router.Handle("/orders/", handler)
+	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	response, err := http.Post(ts.URL+"/orders/Test/echo", "application/x-www-form-urlencoded", strings.NewReader("name=widget"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document model.Document
+	_ = json.NewDecoder(response.Body).Decode(&document)
+	response.Body.Close()
+	if document.Type != "Result" || document.Department != "Test" {
+		t.Errorf("path addressing must see the path without the prefix: %+v", document)
+	}
+	if _, err := NewHandler(nil, nil); err == nil {
+		t.Errorf("expected an error for a nil register")
+	}
+	if logged.Load() != 1 {
+		t.Errorf("requests must be logged, got %d entries", logged.Load())
+	}
+
+	response, err = http.Post(ts.URL+"/orders/rpc", "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"Test.echo","params":{"n":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if !strings.Contains(string(body), `"result"`) {
+		t.Errorf("JSON-RPC must be served below the prefix: %s", body)
+	}
+
+	response, err = http.Get(ts.URL + "/orders/help")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(body), `data-endpoint="/orders/"`) {
+		t.Errorf("the help console must post below the prefix: %d", response.StatusCode)
+	}
+
+	if _, err := CallHTTP(ts.URL+"/orders", model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Errorf("the prefix itself must reach the JSON endpoint: %v", err)
+	}
+	response, _ = http.Get(ts.URL + "/health")
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("routes of the router must keep working, got %d", response.StatusCode)
+	}
+}
+
+func TestJsonApiServer_Listener(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	register := department.NewRegisteryDispatcher("")
+	register.PathPrefix = "/api"
+	register.LoggerWriter = func(logger.LogEntry) error { return nil }
+	// e.g. a socket passed by systemd
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &JsonApiServer{Register: register, Mux: http.NewServeMux(), HTTPServer: &http.Server{ReadHeaderTimeout: time.Second}}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	if _, err := CallHTTP("http://"+ln.Addr().String()+"/api/", model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Errorf("call through the listener: %v", err)
+	}
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("Serve must return ErrServerClosed, got %v", err)
+	}
+}
+
+func TestJsonApiServer_OwnMux(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	register := department.NewRegisteryDispatcher("")
+	register.LoggerWriter = func(logger.LogEntry) error { return nil }
+	// two servers in one process, neither sets a Mux
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := &JsonApiServer{Register: register}
+		served := make(chan error, 1)
+		go func() { served <- srv.Serve(ln) }()
+		if _, err := CallHTTP("http://"+ln.Addr().String(), model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+			t.Errorf("server %d: %v", i, err)
+		}
+		defer srv.Shutdown(context.Background())
+	}
+
+	if err := (&JsonApiServer{}).ListenAndServe(); err == nil {
+		t.Errorf("expected an error for a server without a register")
+	}
+}
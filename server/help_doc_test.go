@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/transaction"
+	"github.com/godispatcher/dispatcher/utilities"
+)
+
+type loginRequest struct {
+	Email    string `json:"email" require:"true" description:"Account e-mail address" example:"jane@example.com"`
+	Password string `json:"password" require:"true" sensitive:"true"`
+	Remember int    `json:"remember,omitempty" description:"Session length in days" example:"30"`
+	Legacy   string `json:"legacy,omitempty" deprecated:"true"`
+}
+
+type documentedServer struct {
+	mockServer
+}
+
+func (documentedServer) GetOptions() model.ServerOption {
+	return model.ServerOption{Doc: model.TransactionDoc{
+		Summary:     "Sign in",
+		Description: "Creates a session for the account.",
+		Examples: []model.TransactionExample{
+			{Name: "remembered", Request: loginRequest{Email: "jane@example.com", Remember: 30}, Response: map[string]string{"token": "abc"}},
+		},
+	}}
+}
+
+func registerDocumented() {
+	department.DispatcherHolder = nil
+	department.DispatcherHolder.Add("Auth", transaction.TransactionBucketItem{
+		Name:        "login",
+		Transaction: documentedServer{mockServer{request: loginRequest{}, response: map[string]string{}}},
+	})
+}
+
+func TestApiDocServer_Documentation(t *testing.T) {
+	registerDocumented()
+	defer func() { department.DispatcherHolder = nil }()
+
+	rr := httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help?format=json", nil))
+	var list HelperList
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	tr := list.Departments[0].Transactions[0]
+	if tr.Summary != "Sign in" || tr.Description == "" || len(tr.Examples) != 1 {
+		t.Errorf("transaction documentation missing: %+v", tr)
+	}
+	want := map[string]utilities.FieldDoc{
+		"email":    {Path: "email", Type: "string", Required: true, Description: "Account e-mail address", Example: "jane@example.com"},
+		"password": {Path: "password", Type: "string", Required: true, Sensitive: true},
+		"remember": {Path: "remember", Type: "integer", Description: "Session length in days", Example: "30"},
+		"legacy":   {Path: "legacy", Type: "string", Deprecated: true},
+	}
+	if len(tr.ProcedureFields) != len(want) {
+		t.Fatalf("unexpected fields: %+v", tr.ProcedureFields)
+	}
+	for _, field := range tr.ProcedureFields {
+		if !reflect.DeepEqual(field, want[field.Path]) {
+			t.Errorf("got %+v want %+v", field, want[field.Path])
+		}
+	}
+
+	for _, format := range []string{"yaml", "toon"} {
+		rr = httptest.NewRecorder()
+		ApiDocServer{}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help?format="+format, nil))
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Account e-mail address") {
+			t.Errorf("%s help misses the field documentation: %s", format, rr.Body.String())
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help", nil))
+	for _, text := range []string{"Sign in", "Account e-mail address", "Hassas", "remembered"} {
+		if !strings.Contains(rr.Body.String(), text) {
+			t.Errorf("html help does not contain %q", text)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help?format=openapi", nil))
+	var spec OpenAPIDocument
+	if err := json.Unmarshal(rr.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	op := spec.Paths["/Auth/login"].Post
+	if op.Summary != "Sign in" || len(op.RequestBody.Content["application/json"].Examples) != 1 {
+		t.Errorf("operation documentation missing: %+v", op)
+	}
+	remember := spec.Components.Schemas["server.loginRequest"]["properties"].(map[string]interface{})["remember"].(map[string]interface{})
+	if remember["description"] != "Session length in days" || remember["examples"].([]interface{})[0] != float64(30) {
+		t.Errorf("unexpected property schema: %v", remember)
+	}
+}
+
+func TestApiDocServer_Console(t *testing.T) {
+	registerDocumented()
+	department.DispatcherHolder.Add("Shop", transaction.TransactionBucketItem{
+		Name:        "item",
+		Transaction: mockServer{request: schemaItem{}, response: openAPINode{}},
+	})
+	defer func() { department.DispatcherHolder = nil }()
+
+	rr := httptest.NewRecorder()
+	ApiDocServer{ConsoleEndpoint: "/api/"}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help", nil))
+	body := rr.Body.String()
+	for _, text := range []string{`data-endpoint="/api/"`, "sendConsole", "jane@example.com", "&#34;created_by&#34;: &#34;&#34;"} {
+		if !strings.Contains(body, text) {
+			t.Errorf("console does not contain %q", text)
+		}
+	}
+	if strings.Contains(body, "https://") {
+		t.Errorf("help page must not load external assets")
+	}
+
+	rr = httptest.NewRecorder()
+	ApiDocServer{DisableConsole: true}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help", nil))
+	if strings.Contains(rr.Body.String(), "data-endpoint") {
+		t.Errorf("console must be hidden when disabled")
+	}
+}
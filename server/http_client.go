@@ -13,24 +13,56 @@ import (
 	"github.com/godispatcher/dispatcher/model"
 )
 
+// httpClient is shared by all CallHTTP requests so keep-alive connections are reused.
+// It speaks HTTP/1.1, and HTTP/2 when the server offers it over TLS.
+var httpClient = &http.Client{Timeout: 15 * time.Second, Transport: newHTTPTransport(false)}
+
+// h2cClient speaks unencrypted HTTP/2 with prior knowledge; it is used for h2c:// addresses.
+var h2cClient = &http.Client{Timeout: 15 * time.Second, Transport: newHTTPTransport(true)}
+
+func newHTTPTransport(h2c bool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 64
+	protocols := new(http.Protocols)
+	if h2c {
+		// without HTTP1, http:// requests are sent as HTTP/2 cleartext
+		protocols.SetUnencryptedHTTP2(true)
+	} else {
+		protocols.SetHTTP1(true)
+	}
+	protocols.SetHTTP2(true)
+	transport.Protocols = protocols
+	return transport
+}
+
 // CallHTTP sends the given model.Document to a remote ServJsonApi endpoint
 // hosted at http://host:port/ and returns the response document.
 // It uses application/json for both request and response bodies.
+// Use the h2c:// scheme (e.g. "h2c://auth:9000") to talk unencrypted HTTP/2 to servers
+// started with HTTP2Options.Cleartext; calls are multiplexed over a shared connection.
+// A unix socket is addressed as "unix:///run/orders.sock"; the request is posted to "/".
 func CallHTTP(address string, doc model.Document) (model.Document, error) {
 	var out model.Document
 	b, err := json.Marshal(doc)
 	if err != nil {
 		return out, err
 	}
+	client := httpClient
 	// Normalize URL: ensure it has a trailing slash if no path is provided
 	u, err := url.Parse(address)
-	if err == nil {
+	if err == nil && u.Scheme == "unix" {
+		client = unixHTTPClient(u.Path)
+		address = "http://unix/"
+	} else if err == nil {
+		if u.Scheme == "h2c" {
+			u.Scheme = "http"
+			client = h2cClient
+		}
 		if u.Path == "" {
 			u.Path = "/"
-			address = u.String()
 		}
+		address = u.String()
 	}
-	client := &http.Client{Timeout: 15 * time.Second}
 	mkReq := func(closeConn bool) (*http.Request, error) {
 		req, err := http.NewRequest(http.MethodPost, address, bytes.NewReader(b))
 		if err != nil {
@@ -38,6 +70,8 @@ func CallHTTP(address string, doc model.Document) (model.Document, error) {
 		}
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Accept", "application/json")
+		// Advertise compression explicitly so deflate is accepted too; decoding is done below.
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
 		// Propagate verify code if present in the document security
 		if doc.Security != nil && strings.TrimSpace(doc.Security.VerifyCode) != "" {
 			req.Header.Set("X-Verify-Code", doc.Security.VerifyCode)
@@ -67,7 +101,16 @@ func CallHTTP(address string, doc model.Document) (model.Document, error) {
 		return out, err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	var reader io.Reader = resp.Body
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" {
+		decoded, err := decodeBody(ce, resp.Body)
+		if err != nil {
+			return out, err
+		}
+		defer decoded.Close()
+		reader = decoded
+	}
+	body, err := io.ReadAll(reader)
 	if err != nil {
 		return out, err
 	}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+func TestCallHTTP_H2C(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(model.Document{Type: "Result", Output: r.Proto})
+	}))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetHTTP1(true)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	cases := map[string]string{
+		ts.URL: "HTTP/1.1",
+		strings.Replace(ts.URL, "http://", "h2c://", 1): "HTTP/2.0",
+	}
+	for address, proto := range cases {
+		resp, err := CallHTTP(address, model.Document{Department: "Test", Transaction: "echo"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Output != proto {
+			t.Errorf("%s: got protocol %v want %s", address, resp.Output, proto)
+		}
+	}
+}
+
+func TestNewHTTPServer_H2C(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(model.Document{Type: "Result", Output: r.Proto})
+	})
+	ts := httptest.NewUnstartedServer(handler)
+	ts.Config = newHTTPServer(&department.RegisterDispatcher{HTTP2: &model.HTTP2Options{Cleartext: true}})
+	ts.Config.Handler = handler
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := CallHTTP(strings.Replace(ts.URL, "http://", "h2c://", 1), model.Document{Department: "Test", Transaction: "echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Output != "HTTP/2.0" {
+		t.Errorf("got protocol %v want HTTP/2.0", resp.Output)
+	}
+
+	// without HTTP2 options the server only speaks HTTP/1.1
+	if newHTTPServer(&department.RegisterDispatcher{}).Protocols.UnencryptedHTTP2() {
+		t.Errorf("h2c must be off by default")
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/godispatcher/dispatcher/constants"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+// whoamiServer answers with the verify code of the caller and fails validation without one.
+type whoamiServer struct {
+	echoServer
+}
+
+func (whoamiServer) Init(document model.Document) model.Document {
+	if document.Security == nil || document.Security.VerifyCode == "" {
+		return model.Document{Error: fmt.Sprintf(constants.FIELD_NOT_FOUND, "verify_code"), Type: "Error"}
+	}
+	document.Output = document.Security.VerifyCode
+	document.Type = "Result"
+	return document
+}
+
+func registerWhoami() {
+	registerEcho()
+	department.DispatcherHolder.Add("Test", transaction.TransactionBucketItem{Name: "whoami", Transaction: whoamiServer{}})
+}
+
+func TestHandleJsonRpc_Single(t *testing.T) {
+	registerEcho()
+	b, ok := department.HandleJsonRpc([]byte(`{"jsonrpc":"2.0","method":"Test.echo","params":{"n":1},"id":7}`), nil, nil)
+	if !ok {
+		t.Fatal("expected a response")
+	}
+	var res model.JsonRpcResponse
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != nil || string(res.Id) != "7" {
+		t.Fatalf("unexpected response: %s", b)
+	}
+	if out, _ := res.Result.(map[string]interface{}); out["n"] != float64(1) {
+		t.Errorf("unexpected result: %v", res.Result)
+	}
+}
+
+func TestHandleJsonRpc_Errors(t *testing.T) {
+	registerWhoami()
+	cases := map[string]int{
+		`{"jsonrpc":"2.0","method":"Test.missing","id":1}`:                     model.JsonRpcMethodNotFound,
+		`{"jsonrpc":"2.0","method":"Test.echo","params":[1],"id":1}`:           model.JsonRpcInvalidParams,
+		`{"jsonrpc":"1.0","method":"Test.echo","id":1}`:                        model.JsonRpcInvalidRequest,
+		`{"jsonrpc":"2.0","method":"Test.echo","params":{"fail":true},"id":1}`: model.JsonRpcServerError,
+		`{"jsonrpc":`: model.JsonRpcParseError,
+		`{"jsonrpc":"2.0","method":"Test.whoami","id":1}`: model.JsonRpcInvalidParams,
+	}
+	for payload, code := range cases {
+		b, _ := department.HandleJsonRpc([]byte(payload), nil, nil)
+		var res model.JsonRpcResponse
+		if err := json.Unmarshal(b, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Error == nil || res.Error.Code != code {
+			t.Errorf("%s: got %s want code %d", payload, b, code)
+		}
+	}
+}
+
+func TestHandleJsonRpc_BatchAndNotifications(t *testing.T) {
+	registerEcho()
+	if _, ok := department.HandleJsonRpc([]byte(`{"jsonrpc":"2.0","method":"Test.echo"}`), nil, nil); ok {
+		t.Errorf("notification must not produce a response")
+	}
+	payload := `[
+		{"jsonrpc":"2.0","method":"Test.echo","params":{"n":1},"id":1},
+		{"jsonrpc":"2.0","method":"Test.echo","params":{"n":2}},
+		{"foo":"bar"},
+		{"jsonrpc":"2.0","method":"Test.missing","id":"x"}
+	]`
+	b, ok := department.HandleJsonRpc([]byte(payload), nil, nil)
+	if !ok {
+		t.Fatal("expected a response")
+	}
+	var res []model.JsonRpcResponse
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 {
+		t.Fatalf("expected 3 responses, got %s", b)
+	}
+	if res[0].Error != nil || res[1].Error.Code != model.JsonRpcInvalidRequest || res[2].Error.Code != model.JsonRpcMethodNotFound {
+		t.Errorf("unexpected batch response: %s", b)
+	}
+}
+
+func TestJsonRpcMainFunc(t *testing.T) {
+	registerWhoami()
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Verify-Code", "abc")
+		rr := httptest.NewRecorder()
+		department.JsonRpcMainFunc(rr, req)
+		return rr
+	}
+
+	rr := post(`{"jsonrpc":"2.0","method":"Test.echo","params":{"n":1}}`)
+	if rr.Code != http.StatusNoContent || rr.Body.Len() != 0 {
+		t.Errorf("notification: got %d %q want 204 and no body", rr.Code, rr.Body.String())
+	}
+
+	rr = post(`{"jsonrpc":"2.0","method":"Test.whoami","id":1}`)
+	var res model.JsonRpcResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != nil || res.Result != "abc" {
+		t.Errorf("X-Verify-Code was not used: %s", rr.Body.String())
+	}
+
+	// a security member in the request wins over the header
+	rr = post(`{"jsonrpc":"2.0","method":"Test.whoami","security":{"verify_code":"xyz"},"id":2}`)
+	if !strings.Contains(rr.Body.String(), `"result":"xyz"`) {
+		t.Errorf("request security was not used: %s", rr.Body.String())
+	}
+}
+
+func TestStreamJsonRpc(t *testing.T) {
+	registerEcho()
+	serverConn, clientConn := net.Pipe()
+	go handleStreamConn(serverConn, &department.RegisterDispatcher{JSONRPC: &model.JSONRPCOptions{Enabled: true}})
+	defer clientConn.Close()
+	reader := bufio.NewReader(clientConn)
+
+	// the notification gets no line back, so the next line answers the call
+	payload := `{"jsonrpc":"2.0","method":"Test.echo"}` + "\n" + `{"jsonrpc":"2.0","method":"Test.echo","params":{"n":2},"id":5}` + "\n"
+	go clientConn.Write([]byte(payload))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res model.JsonRpcResponse
+	if err := json.Unmarshal(line, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != nil || string(res.Id) != "5" {
+		t.Errorf("unexpected response: %s", line)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+// schemaOnly is a transaction declared without Go types, as dispatcher-mock registers them.
+type schemaOnly struct {
+	mockServer
+}
+
+func (schemaOnly) ValidateForm(form interface{}) error {
+	if m, _ := form.(map[string]interface{}); m["sku"] == nil {
+		return fmt.Errorf(constants.FIELD_NOT_FOUND, "sku")
+	}
+	return nil
+}
+
+func (schemaOnly) SampleResponse() interface{} { return map[string]interface{}{"status": "open"} }
+
+func TestMockMode(t *testing.T) {
+	registerCodegen()
+	department.DispatcherHolder.Add("Orders", transaction.TransactionBucketItem{Name: "create", Transaction: schemaOnly{}})
+	defer func() { department.DispatcherHolder = nil }()
+	department.EnableMock(&model.MockOptions{Enabled: true})
+	defer department.EnableMock(nil)
+
+	execute := func(dep, tx string, form string) model.Document {
+		document := model.Document{Department: dep, Transaction: tx}
+		if err := json.Unmarshal([]byte(form), &document.Form); err != nil {
+			t.Fatal(err)
+		}
+		return department.ExecuteDocument(document)
+	}
+
+	out := execute("Auth", "login", `{"email":"jane@example.com","password":"x"}`)
+	if out.Type != constants.DOC_TYPE_RESULT || fmt.Sprint(out.Output) != "map[token:abc]" {
+		t.Errorf("registered example expected: %+v", out)
+	}
+	out = execute("Auth", "login", `{"password":"x"}`)
+	if out.Error != fmt.Sprintf(constants.FIELD_NOT_FOUND, "Email") {
+		t.Errorf("validation must still run: %+v", out)
+	}
+	out = execute("Shop", "item", `{"id":"7","email":5}`)
+	if out.Type != constants.DOC_TYPE_ERROR || !strings.HasPrefix(fmt.Sprint(out.Error), "error document parsing") {
+		t.Errorf("type mismatch must be reported: %+v", out)
+	}
+	out = execute("Shop", "item", `{"id":"7","email":"a@b.c"}`)
+	if node, ok := out.Output.(map[string]interface{}); !ok || node["name"] != "" {
+		t.Errorf("response sample of openAPINode expected: %#v", out.Output)
+	}
+	out = execute("Orders", "create", `{"sku":"A-1"}`)
+	if fmt.Sprint(out.Output) != "map[status:open]" {
+		t.Errorf("schema sample expected: %+v", out)
+	}
+	if out = execute("Orders", "create", `{}`); out.Type != constants.DOC_TYPE_ERROR {
+		t.Errorf("schema validation expected: %+v", out)
+	}
+
+	// enabling again replaces the options instead of wrapping twice
+	department.EnableMock(&model.MockOptions{Enabled: true, Latency: 20 * time.Millisecond, ErrorRate: 1, Error: "unavailable"})
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"department":"Auth","transaction":"login","form":{"email":"a"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	department.RegisterMainFunc(rr, req)
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Errorf("unexpected latency %v", elapsed)
+	}
+	if !strings.Contains(rr.Body.String(), `"error":"unavailable"`) {
+		t.Errorf("injected error expected: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help?format=json", nil))
+	if !strings.Contains(rr.Body.String(), "Account e-mail address") {
+		t.Errorf("mocked transactions must keep their documentation")
+	}
+}
+
+func TestMockMode_WhileServing(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	defer department.EnableMock(nil)
+	register := &department.RegisterDispatcher{Mock: &model.MockOptions{Enabled: true}}
+
+	// servers enabling mock mode while others already serve, as Run does
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _ = NewHandler(register, nil)
+		}()
+		go func() {
+			defer wg.Done()
+			department.ExecuteDocument(model.Document{Department: "Test", Transaction: "echo"})
+		}()
+	}
+	wg.Wait()
+
+	out := department.ExecuteDocument(model.Document{Department: "Test", Transaction: "echo", Form: model.DocumentForm{"n": 1}})
+	if out.Type != constants.DOC_TYPE_RESULT || fmt.Sprint(out.Output) == "map[n:1]" {
+		t.Errorf("mocked answer expected: %+v", out)
+	}
+	if _, ok := (*department.DispatcherHolder.GetTransaction("Test", "echo")).GetTransaction().(echoServer); !ok {
+		t.Error("the registry must not be changed")
+	}
+	department.EnableMock(nil)
+	if out := department.ExecuteDocument(model.Document{Department: "Test", Transaction: "echo", Form: model.DocumentForm{"n": 1}}); fmt.Sprint(out.Output) != "map[n:1]" {
+		t.Errorf("the transaction must run again: %+v", out)
+	}
+}
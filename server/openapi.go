@@ -0,0 +1,238 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/utilities"
+)
+
+const openAPIRefPrefix = "#/components/schemas/"
+
+// OpenAPISchema is a JSON Schema object as used by OpenAPI 3.1.
+type OpenAPISchema = utilities.Schema
+
+// OpenAPIDocument is the root object of an OpenAPI 3.1 specification.
+type OpenAPIDocument struct {
+	OpenAPI    string                 `json:"openapi" yaml:"openapi"`
+	Info       OpenAPIInfo            `json:"info" yaml:"info"`
+	Tags       []OpenAPITag           `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Paths      map[string]OpenAPIPath `json:"paths" yaml:"paths"`
+	Components OpenAPIComponents      `json:"components" yaml:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title" yaml:"title"`
+	Version string `json:"version" yaml:"version"`
+}
+
+type OpenAPITag struct {
+	Name string `json:"name" yaml:"name"`
+}
+
+type OpenAPIPath struct {
+	Post *OpenAPIOperation `json:"post,omitempty" yaml:"post,omitempty"`
+}
+
+type OpenAPIOperation struct {
+	OperationId string                     `json:"operationId" yaml:"operationId"`
+	Summary     string                     `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Description string                     `json:"description,omitempty" yaml:"description,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	Tags        []string                   `json:"tags,omitempty" yaml:"tags,omitempty"`
+	RequestBody OpenAPIRequestBody         `json:"requestBody" yaml:"requestBody"`
+	Responses   map[string]OpenAPIResponse `json:"responses" yaml:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty" yaml:"security,omitempty"`
+	RateLimit   *model.RateLimitOptions    `json:"x-rate-limit,omitempty" yaml:"x-rate-limit,omitempty"`
+	// Streaming marks transactions that answer with NDJSON, one document per partial output.
+	Streaming bool `json:"x-streaming,omitempty" yaml:"x-streaming,omitempty"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required" yaml:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content" yaml:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description" yaml:"description"`
+	Headers     map[string]OpenAPIHeader    `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty" yaml:"content,omitempty"`
+}
+
+type OpenAPIHeader struct {
+	Description string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Schema      OpenAPISchema `json:"schema" yaml:"schema"`
+}
+
+type OpenAPIMediaType struct {
+	Schema   OpenAPISchema             `json:"schema" yaml:"schema"`
+	Examples map[string]OpenAPIExample `json:"examples,omitempty" yaml:"examples,omitempty"`
+}
+
+type OpenAPIExample struct {
+	Summary string      `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Value   interface{} `json:"value" yaml:"value"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]OpenAPISchema         `json:"schemas" yaml:"schemas"`
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes,omitempty" yaml:"securitySchemes,omitempty"`
+}
+
+type OpenAPISecurityScheme struct {
+	Type        string `json:"type" yaml:"type"`
+	In          string `json:"in,omitempty" yaml:"in,omitempty"`
+	Name        string `json:"name,omitempty" yaml:"name,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// BuildOpenAPI generates an OpenAPI 3.1 document for the registered transactions.
+// Every transaction is described as POST /{department}/{transaction}; the request body is
+// the Document envelope with the transaction request as form and the response wraps the
+// transaction response as output.
+func BuildOpenAPI(holder department.DispacherBucket, title, version string) OpenAPIDocument {
+	generator := utilities.NewSchemaGenerator(openAPIRefPrefix)
+	securityRef := generator.Generate(model.Security{})
+	errorDocument := OpenAPISchema{
+		"type": "object",
+		"properties": OpenAPISchema{
+			"department":  OpenAPISchema{"type": "string"},
+			"transaction": OpenAPISchema{"type": "string"},
+			"type":        OpenAPISchema{"const": "Error"},
+			"error":       OpenAPISchema{"type": "string"},
+		},
+		"required": []string{"type", "error"},
+	}
+	generator.Definitions["ErrorDocument"] = errorDocument
+
+	doc := OpenAPIDocument{
+		OpenAPI: "3.1.0",
+		Info:    OpenAPIInfo{Title: title, Version: version},
+		Paths:   map[string]OpenAPIPath{},
+		Components: OpenAPIComponents{
+			Schemas: generator.Definitions,
+			SecuritySchemes: map[string]OpenAPISecurityScheme{
+				"verifyCode": {Type: "apiKey", In: "header", Name: "X-Verify-Code", Description: "Alternative to security.verify_code in the document"},
+			},
+		},
+	}
+
+	for _, dep := range holder {
+		doc.Tags = append(doc.Tags, OpenAPITag{Name: dep.Name})
+		for _, item := range dep.Transactions {
+			name := (*item).GetName()
+			ta := (*item).GetTransaction()
+			options := ta.GetOptions().TransactionOptions
+
+			security := OpenAPISchema{"$ref": securityRef["$ref"]}
+			requestRequired := []string{"form"}
+			if options.Security.LicenceChecker {
+				security = OpenAPISchema{"allOf": []OpenAPISchema{securityRef, {"required": []string{"licence"}}}}
+				requestRequired = append(requestRequired, "security")
+			}
+			requestSchema := OpenAPISchema{
+				"type": "object",
+				"properties": OpenAPISchema{
+					"department":   OpenAPISchema{"const": dep.Name},
+					"transaction":  OpenAPISchema{"const": name},
+					"form":         formSchema(generator, ta.GetRequest()),
+					"security":     security,
+					"dispatchings": OpenAPISchema{"type": "array", "items": OpenAPISchema{"type": "object"}},
+				},
+				"required": requestRequired,
+			}
+			resultSchema := OpenAPISchema{
+				"type": "object",
+				"properties": OpenAPISchema{
+					"department":   OpenAPISchema{"const": dep.Name},
+					"transaction":  OpenAPISchema{"const": name},
+					"type":         OpenAPISchema{"enum": []string{"Result", "Error"}},
+					"output":       generator.Generate(ta.GetResponse()),
+					"error":        OpenAPISchema{"type": "string"},
+					"dispatchings": OpenAPISchema{"type": "array", "items": OpenAPISchema{"type": "object"}},
+				},
+				"required": []string{"type"},
+			}
+
+			operation := &OpenAPIOperation{
+				OperationId: dep.Name + "." + name,
+				Tags:        []string{dep.Name},
+				RequestBody: OpenAPIRequestBody{
+					Required: true,
+					Content:  map[string]OpenAPIMediaType{constants.HTTP_CONTENT_JSON: {Schema: requestSchema}},
+				},
+				Responses: map[string]OpenAPIResponse{
+					"200": {
+						Description: "Result document, or an Error document when the transaction failed",
+						Content:     map[string]OpenAPIMediaType{constants.HTTP_CONTENT_JSON: {Schema: resultSchema}},
+					},
+					"400": {
+						Description: "Malformed document or unknown transaction",
+						Content:     map[string]OpenAPIMediaType{constants.HTTP_CONTENT_JSON: {Schema: OpenAPISchema{"$ref": openAPIRefPrefix + "ErrorDocument"}}},
+					},
+				},
+				// the verify code header is optional, documents may carry it in security instead
+				Security: []map[string][]string{{}, {"verifyCode": {}}},
+			}
+			transactionDoc := ta.GetOptions().Doc
+			operation.Summary = transactionDoc.Summary
+			operation.Description = transactionDoc.Description
+			operation.Deprecated = transactionDoc.Deprecated
+			operation.Streaming = department.IsStreaming(ta)
+			if len(transactionDoc.Examples) > 0 {
+				requestExamples := map[string]OpenAPIExample{}
+				resultExamples := map[string]OpenAPIExample{}
+				for i, example := range transactionDoc.Examples {
+					key := example.Name
+					if key == "" {
+						key = "example" + strconv.Itoa(i+1)
+					}
+					requestExamples[key] = OpenAPIExample{Summary: example.Name, Value: model.Document{Department: dep.Name, Transaction: name, Form: exampleForm(example.Request)}}
+					resultExamples[key] = OpenAPIExample{Summary: example.Name, Value: model.Document{Department: dep.Name, Transaction: name, Type: "Result", Output: example.Response}}
+				}
+				media := operation.RequestBody.Content[constants.HTTP_CONTENT_JSON]
+				media.Examples = requestExamples
+				operation.RequestBody.Content[constants.HTTP_CONTENT_JSON] = media
+				media = operation.Responses["200"].Content[constants.HTTP_CONTENT_JSON]
+				media.Examples = resultExamples
+				operation.Responses["200"].Content[constants.HTTP_CONTENT_JSON] = media
+			}
+			if options.RateLimiter.Enabled {
+				rateLimit := options.RateLimiter
+				operation.RateLimit = &rateLimit
+				operation.Responses["200"] = withRateLimitHeaders(operation.Responses["200"])
+			}
+			doc.Paths["/"+dep.Name+"/"+name] = OpenAPIPath{Post: operation}
+		}
+	}
+	return doc
+}
+
+// formSchema returns the request schema; a form is always an object so references are kept as is.
+func formSchema(generator *utilities.SchemaGenerator, request interface{}) OpenAPISchema {
+	if request == nil {
+		return OpenAPISchema{"type": "object"}
+	}
+	return generator.Generate(request)
+}
+
+// exampleForm converts a registered example request into a document form.
+func exampleForm(request interface{}) model.DocumentForm {
+	form := model.DocumentForm{}
+	if request != nil {
+		_ = form.FromInterface(request)
+	}
+	return form
+}
+
+func withRateLimitHeaders(response OpenAPIResponse) OpenAPIResponse {
+	integer := OpenAPISchema{"type": "integer"}
+	response.Headers = map[string]OpenAPIHeader{
+		"X-RateLimit-Limit":     {Description: "Requests allowed in the window", Schema: integer},
+		"X-RateLimit-Remaining": {Description: "Requests left in the window", Schema: integer},
+		"X-RateLimit-Reset":     {Description: "Unix time the window resets", Schema: integer},
+	}
+	return response
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+type openAPINode struct {
+	Name     string         `json:"name" require:"true" isEmpty:"false"`
+	Children []*openAPINode `json:"children,omitempty"`
+	Hidden   string         `json:"-"`
+}
+
+type openAPIServer struct {
+	mockServer
+}
+
+func (openAPIServer) GetOptions() model.ServerOption {
+	return model.ServerOption{TransactionOptions: model.TransactionOptions{
+		Security:    model.SecurityOptions{LicenceChecker: true},
+		RateLimiter: model.RateLimitOptions{Enabled: true, Limit: 5, Window: 60, Scope: model.ScopeIP},
+	}}
+}
+
+func TestApiDocServer_OpenAPI(t *testing.T) {
+	department.DispatcherHolder = nil
+	defer func() { department.DispatcherHolder = nil }()
+	department.DispatcherHolder.Add("Tree", transaction.TransactionBucketItem{
+		Name:        "save",
+		Transaction: openAPIServer{mockServer{request: openAPINode{}, response: openAPINode{}}},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/help?format=openapi", nil)
+	rr := httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rr.Code)
+	}
+
+	var spec OpenAPIDocument
+	if err := json.Unmarshal(rr.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.OpenAPI != "3.1.0" {
+		t.Errorf("unexpected version %q", spec.OpenAPI)
+	}
+	op := spec.Paths["/Tree/save"].Post
+	if op == nil {
+		t.Fatalf("missing operation, paths: %v", spec.Paths)
+	}
+	if op.RateLimit == nil || op.RateLimit.Limit != 5 {
+		t.Errorf("rate limit options missing")
+	}
+	node, ok := spec.Components.Schemas["server.openAPINode"]
+	if !ok {
+		t.Fatalf("missing component, got %v", spec.Components.Schemas)
+	}
+	children := node["properties"].(map[string]interface{})["children"].(map[string]interface{})
+	if children["items"].(map[string]interface{})["$ref"] != "#/components/schemas/server.openAPINode" {
+		t.Errorf("recursive field is not a $ref: %v", children)
+	}
+	if _, hidden := node["properties"].(map[string]interface{})["Hidden"]; hidden {
+		t.Errorf(`json:"-" field must not be documented`)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/help?format=openapi-yaml", nil)
+	rr = httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "openapi: 3.1.0") {
+		t.Errorf("unexpected yaml body: %s", rr.Body.String())
+	}
+}
+
+func TestApiDocServer_SelfReferencingType(t *testing.T) {
+	department.DispatcherHolder = nil
+	defer func() { department.DispatcherHolder = nil }()
+	department.DispatcherHolder.Add("Tree", transaction.TransactionBucketItem{
+		Name:        "save",
+		Transaction: mockServer{request: openAPINode{}, response: openAPINode{}},
+	})
+
+	for _, format := range []string{"json", "yaml", "toon", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/help?format="+format, nil)
+		rr := httptest.NewRecorder()
+		ApiDocServer{}.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("format %q: unexpected status %d", format, rr.Code)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/help?format=json", nil)
+	rr := httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "- Parent") {
+		t.Errorf("recursive field should point to its parent: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"syscall"
+
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// peerCredentials returns the SO_PEERCRED credentials of a unix socket connection, or nil
+// for other connections.
+func peerCredentials(conn net.Conn) *model.PeerCredentials {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return nil
+	}
+	return &model.PeerCredentials{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}
+}
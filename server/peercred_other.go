@@ -0,0 +1,14 @@
+//go:build !linux
+
+package server
+
+import (
+	"net"
+
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// peerCredentials is only implemented on Linux.
+func peerCredentials(conn net.Conn) *model.PeerCredentials {
+	return nil
+}
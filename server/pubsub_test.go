@@ -0,0 +1,143 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/pubsub"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+// publishServer publishes its form to form.topic.
+type publishServer struct {
+	echoServer
+}
+
+func (s publishServer) Init(document model.Document) model.Document {
+	topic, _ := document.Form["topic"].(string)
+	if _, err := pubsub.Publish(topic, document.Form); err != nil {
+		return model.Document{Type: constants.DOC_TYPE_ERROR, Error: err.Error()}
+	}
+	return s.echoServer.Init(document)
+}
+
+func TestPubSubMatch(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.*.created", "orders.eu.created", true},
+		{"orders.>", "orders.eu.created", true},
+		{"orders.>", "orders", false},
+		{"*", "orders", true},
+		{"orders.created", "orders", false},
+	}
+	for _, c := range cases {
+		if got := pubsub.Match(c.pattern, c.topic); got != c.want {
+			t.Errorf("Match(%q, %q) = %v", c.pattern, c.topic, got)
+		}
+	}
+	if _, err := pubsub.Publish("orders.*", nil); err == nil {
+		t.Errorf("wildcards must be rejected when publishing")
+	}
+	if _, err := pubsub.DefaultBroker.Subscribe("orders.>.eu", nil, 0); err == nil {
+		t.Errorf("> must be the last segment")
+	}
+}
+
+func TestPubSubSlowSubscriber(t *testing.T) {
+	broker := pubsub.NewBroker()
+	subscription, err := broker.Subscribe("ticks", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := broker.Publish("ticks", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var received int
+	for range subscription.C {
+		received++
+	}
+	if received != 1 || !errors.Is(subscription.Err(), pubsub.ErrSlowSubscriber) {
+		t.Errorf("a full subscription must be dropped: %d events, %v", received, subscription.Err())
+	}
+	if n, _ := broker.Publish("ticks", 3); n != 0 {
+		t.Errorf("dropped subscription still receives events")
+	}
+}
+
+func TestPubSubStream(t *testing.T) {
+	registerEcho()
+	department.DispatcherHolder.Add("Test", transaction.TransactionBucketItem{Name: "publish", Transaction: publishServer{}})
+	defer func() { department.DispatcherHolder = nil }()
+	register := &department.RegisterDispatcher{PubSub: &model.PubSubOptions{
+		Enabled: true,
+		Authorize: func(pattern string, security *model.Security) error {
+			if security == nil || security.Licence != "dashboard" {
+				return errors.New("not allowed to subscribe to " + pattern)
+			}
+			return nil
+		},
+	}}
+	cli := multiplexedPipe(t, register)
+	defer cli.Close()
+
+	if _, _, err := cli.Subscribe("orders.*"); err == nil {
+		t.Fatal("the subscription must be authorized")
+	}
+	events, cancel, err := cli.SubscribeDocument(model.Document{
+		Procedure: "orders.*",
+		Form:      model.DocumentForm{"status": "shipped"},
+		Security:  &model.Security{Licence: "dashboard"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(topic, status string) {
+		if _, err := cli.Send(model.Document{Department: "Test", Transaction: "publish", Form: model.DocumentForm{"topic": topic, "status": status}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("orders.created", "open")       // filtered out
+	publish("orders.eu.shipped", "shipped") // topic does not match
+	publish("orders.shipped", "shipped")
+	select {
+	case event := <-events:
+		if event.Type != constants.DOC_TYPE_EVENT || event.Procedure != "orders.shipped" || event.Output.(map[string]interface{})["status"] != "shipped" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event was not pushed")
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("the channel must be closed after cancel")
+	}
+	if n, _ := pubsub.Publish("orders.shipped", nil); n != 0 {
+		t.Errorf("the subscription must be removed from the broker, %d left", n)
+	}
+}
+
+func TestPubSubDisabled(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	cli := multiplexedPipe(t, &department.RegisterDispatcher{})
+	defer cli.Close()
+	if _, _, err := cli.Subscribe("orders.>"); err == nil {
+		t.Errorf("subscriptions must be rejected when pub/sub is not enabled")
+	}
+	if _, err := cli.Send(model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Errorf("the connection must keep working: %v", err)
+	}
+}
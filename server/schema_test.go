@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+type schemaLevel int
+
+func (schemaLevel) MarshalJSON() ([]byte, error) { return json.Marshal("debug") }
+
+type schemaAudit struct {
+	CreatedBy string `json:"created_by"`
+	Name      string
+}
+
+type schemaOwner struct {
+	Name string
+}
+
+type schemaItem struct {
+	schemaAudit
+	*schemaOwner
+	ID       int64              `json:"id,string" require:"true"`
+	Email    string             `json:"email" format:"email" isEmpty:"false"`
+	At       time.Time          `json:"at"`
+	Level    schemaLevel        `json:"level"`
+	Tags     map[string]float64 `json:"tags,omitempty"`
+	Raw      []byte             `json:"raw,omitempty"`
+	Parent   *schemaItem        `json:"parent,omitempty"`
+	Audit    schemaAudit        `json:"audit,string"`
+	Internal string             `json:"-"`
+	NoTag    bool
+}
+
+func TestApiDocServer_Schema(t *testing.T) {
+	department.DispatcherHolder = nil
+	defer func() { department.DispatcherHolder = nil }()
+	department.DispatcherHolder.Add("Shop", transaction.TransactionBucketItem{
+		Name:        "item",
+		Transaction: mockServer{request: schemaItem{}, response: openAPINode{}},
+	})
+
+	cases := map[string]int{
+		"/help/schema/Shop/item":          http.StatusOK,
+		"/help/schema/Shop/item/request":  http.StatusOK,
+		"/help/schema/Shop/item/response": http.StatusOK,
+		"/help/schema/Shop/missing":       http.StatusNotFound,
+		"/help/schema/Shop/item/other":    http.StatusNotFound,
+	}
+	for path, status := range cases {
+		rr := httptest.NewRecorder()
+		ApiDocServer{}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != status {
+			t.Errorf("%s: got status %d want %d", path, rr.Code, status)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help/schema/Shop/item/response", nil))
+	if rr.Header().Get("Content-Type") != "application/schema+json" || !strings.Contains(rr.Body.String(), `"$defs"`) {
+		t.Errorf("unexpected response schema: %s %s", rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+}
@@ -12,10 +12,12 @@ import (
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/godispatcher/dispatcher/codegen"
 	"github.com/godispatcher/dispatcher/constants"
 	"github.com/godispatcher/dispatcher/department"
 	"github.com/godispatcher/dispatcher/middleware"
 	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/pubsub"
 	"github.com/godispatcher/dispatcher/transaction"
 	"github.com/godispatcher/dispatcher/utilities"
 	"github.com/mateuszkardas/toon-go"
@@ -47,6 +49,24 @@ func (s Server[T, TI]) GetOptions() model.ServerOption {
 }
 
 func (s Server[T, TI]) Init(document model.Document) model.Document {
+	return s.run(document, nil)
+}
+
+// Streaming reports whether T is a transaction.StreamingTransaction.
+func (Server[T, TI]) Streaming() bool {
+	_, ok := any(new(T)).(transaction.StreamingTransaction)
+	return ok
+}
+
+// Stream runs the transaction like Init and passes the partial outputs of a streaming
+// transaction to emit as "Stream" documents before returning the terminal document.
+func (s Server[T, TI]) Stream(document model.Document, emit func(model.Document) error) model.Document {
+	return s.run(document, emit)
+}
+
+// run executes the transaction. Without emit, the partial outputs of a streaming
+// transaction are collected into the output of the returned document.
+func (s Server[T, TI]) run(document model.Document, emit func(model.Document) error) model.Document {
 	// Rate Limiting
 	opts := s.Options.TransactionOptions
 	if document.Options != nil {
@@ -79,7 +99,7 @@ func (s Server[T, TI]) Init(document model.Document) model.Document {
 				return model.Document{
 					Department:  document.Department,
 					Transaction: document.Transaction,
-					Error:       fmt.Sprintf("Rate limit exceeded. Try again in %d seconds.", res.RetryAfter),
+					Error:       fmt.Sprintf(constants.RATE_LIMIT_EXCEEDED, res.RetryAfter),
 					Type:        "Error",
 				}
 			}
@@ -145,21 +165,49 @@ func (s Server[T, TI]) Init(document model.Document) model.Document {
 		}
 	}
 	ta.SetRequest(jsonByteData)
-	err = ta.Transact()
+	streaming, isStreaming := any(ta).(transaction.StreamingTransaction)
+	var outputs []any
+	switch {
+	case isStreaming && emit != nil:
+		err = streaming.TransactStream(func(output any) error {
+			return emit(model.Document{Department: document.Department, Transaction: document.Transaction, Output: output, Type: constants.DOC_TYPE_STREAM})
+		})
+	case isStreaming:
+		outputs = []any{}
+		err = streaming.TransactStream(func(output any) error {
+			outputs = append(outputs, output)
+			return nil
+		})
+	default:
+		err = ta.Transact()
+	}
 	if err != nil {
 		outputErrDoc := model.Document{Department: document.Department, Transaction: document.Transaction, Error: err.Error(), Type: "Error"}
 		return outputErrDoc
 	}
-	document.Output = ta.GetResponse()
+	if outputs != nil {
+		document.Output = outputs
+	} else {
+		document.Output = ta.GetResponse()
+	}
 	document.Type = "Result"
 
 	return document
 }
 
 type TransactionListHelper struct {
-	Name      string      `json:"name"`
-	Procedure interface{} `json:"procedure,omitempty"`
-	Output    interface{} `json:"output,omitempty"`
+	Name            string                     `json:"name"`
+	Summary         string                     `json:"summary,omitempty"`
+	Description     string                     `json:"description,omitempty"`
+	Deprecated      bool                       `json:"deprecated,omitempty"`
+	Streaming       bool                       `json:"streaming,omitempty"`
+	Procedure       interface{}                `json:"procedure,omitempty"`
+	Output          interface{}                `json:"output,omitempty"`
+	ProcedureFields []utilities.FieldDoc       `json:"procedure_fields,omitempty"`
+	OutputFields    []utilities.FieldDoc       `json:"output_fields,omitempty"`
+	Examples        []model.TransactionExample `json:"examples,omitempty"`
+	// Sample pre-fills the request editor of the HTML console.
+	Sample interface{} `json:"-" yaml:"-"`
 }
 type DepartmentListHelper struct {
 	Name         string                  `json:"name"`
@@ -171,11 +219,39 @@ type HelperList struct {
 }
 
 type ApiDocServer struct {
+	Title   string // OpenAPI info.title, defaults to "GoDispatcher API"
+	Version string // OpenAPI info.version, defaults to "1.0.0"
+	// DisableConsole hides the "try it" console of the HTML page, e.g. in production.
+	DisableConsole bool
+	// ConsoleEndpoint is where the console posts documents, defaults to "/" (ServJsonApi on the same server).
+	ConsoleEndpoint string
+}
+
+// helpPage is the data of templates/help.html.
+type helpPage struct {
+	HelperList
+	Console         bool
+	ConsoleEndpoint string
 }
 
-func (ApiDocServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (s ApiDocServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, address, ok := strings.Cut(r.URL.Path, "/help/schema/"); ok {
+		s.serveSchema(w, address)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "openapi" || format == "openapi-yaml" {
+		s.serveOpenAPI(w, format == "openapi-yaml")
+		return
+	}
+	if format == "ts" {
+		s.serveTypeScript(w)
+		return
+	}
+
 	helperList := HelperList{}
 	var nestedTypeCtrl *[]string
+	isStreaming := department.IsStreaming // the loop below shadows the package name
 	for _, val := range department.DispatcherHolder {
 		department := DepartmentListHelper{}
 		department.Name = val.Name
@@ -183,18 +259,30 @@ func (ApiDocServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		for _, v := range val.Transactions {
 			transaction := TransactionListHelper{}
 			transaction.Name = (*v).GetName()
+			doc := (*v).GetTransaction().GetOptions().Doc
+			transaction.Summary = doc.Summary
+			transaction.Description = doc.Description
+			transaction.Deprecated = doc.Deprecated
+			transaction.Streaming = isStreaming((*v).GetTransaction())
 			if !r.URL.Query().Has("short") || r.URL.Query().Get("short") == "0" {
 				nestedTypeCtrl = &[]string{}
 				transaction.Procedure = utilities.Analysis((*v).GetTransaction().GetRequest(), nestedTypeCtrl)
 				nestedTypeCtrl = &[]string{}
 				transaction.Output = utilities.Analysis((*v).GetTransaction().GetResponse(), nestedTypeCtrl)
+				transaction.ProcedureFields = utilities.DescribeFields((*v).GetTransaction().GetRequest())
+				transaction.OutputFields = utilities.DescribeFields((*v).GetTransaction().GetResponse())
+				transaction.Examples = doc.Examples
+				if len(doc.Examples) > 0 && doc.Examples[0].Request != nil {
+					transaction.Sample = doc.Examples[0].Request
+				} else {
+					transaction.Sample = utilities.SampleValue((*v).GetTransaction().GetRequest())
+				}
 			}
 			department.Transactions = append(department.Transactions, transaction)
 		}
 		helperList.Departments = append(helperList.Departments, department)
 	}
 
-	format := r.URL.Query().Get("format")
 	if format == "json" {
 		response, _ := json.Marshal(helperList)
 		w.Header().Add(constants.HTTP_CONTENT_TYPE, constants.HTTP_CONTENT_JSON)
@@ -236,6 +324,12 @@ func (ApiDocServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			a, _ := yaml.Marshal(v)
 			return string(a)
 		},
+		"requestDocument": func(departmentName, transactionName string, sample interface{}) string {
+			form := model.DocumentForm{}
+			_ = form.FromInterface(sample)
+			a, _ := json.MarshalIndent(map[string]interface{}{"department": departmentName, "transaction": transactionName, "form": form}, "", "  ")
+			return string(a)
+		},
 	}).ParseFS(templates, "templates/help.html")
 
 	if err != nil {
@@ -243,14 +337,120 @@ func (ApiDocServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = tmpl.Execute(w, helperList)
+	page := helpPage{HelperList: helperList, Console: !s.DisableConsole, ConsoleEndpoint: s.ConsoleEndpoint}
+	if page.ConsoleEndpoint == "" {
+		page.ConsoleEndpoint = "/"
+	}
+	err = tmpl.Execute(w, page)
 	if err != nil {
 		http.Error(w, "Execution error: "+err.Error(), http.StatusInternalServerError)
 	}
 }
 
+func (s ApiDocServer) info() (title, version string) {
+	title, version = s.Title, s.Version
+	if title == "" {
+		title = "GoDispatcher API"
+	}
+	if version == "" {
+		version = "1.0.0"
+	}
+	return title, version
+}
+
+func (s ApiDocServer) serveOpenAPI(w http.ResponseWriter, asYaml bool) {
+	title, version := s.info()
+	spec := BuildOpenAPI(department.DispatcherHolder, title, version)
+	if asYaml {
+		response, err := yaml.Marshal(spec)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add(constants.HTTP_CONTENT_TYPE, constants.HTTP_CONTENT_YAML)
+		fmt.Fprint(w, string(response))
+		return
+	}
+	response, err := json.Marshal(spec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add(constants.HTTP_CONTENT_TYPE, constants.HTTP_CONTENT_JSON)
+	fmt.Fprint(w, string(response))
+}
+
+// TransactionSchema holds the JSON Schemas of a transaction's form and output.
+type TransactionSchema struct {
+	Department  string           `json:"department"`
+	Transaction string           `json:"transaction"`
+	Request     utilities.Schema `json:"request"`
+	Response    utilities.Schema `json:"response"`
+}
+
+// serveSchema answers /help/schema/{department}/{transaction}[/request|/response]
+// with draft 2020-12 JSON Schemas.
+func (s ApiDocServer) serveSchema(w http.ResponseWriter, address string) {
+	segments := strings.Split(strings.Trim(address, "/"), "/")
+	if len(segments) < 2 || len(segments) > 3 {
+		http.Error(w, "expected /help/schema/{department}/{transaction}", http.StatusNotFound)
+		return
+	}
+	ta := department.DispatcherHolder.GetTransaction(segments[0], segments[1])
+	if ta == nil {
+		http.Error(w, constants.TRANSACTION_NOT_FOUND, http.StatusNotFound)
+		return
+	}
+	schema := TransactionSchema{
+		Department:  segments[0],
+		Transaction: segments[1],
+		Request:     utilities.JsonSchema((*ta).GetTransaction().GetRequest(), false),
+		Response:    utilities.JsonSchema((*ta).GetTransaction().GetResponse(), true),
+	}
+	var body interface{} = schema
+	if len(segments) == 3 {
+		switch segments[2] {
+		case "request":
+			body = schema.Request
+		case "response":
+			body = schema.Response
+		default:
+			http.Error(w, "expected request or response", http.StatusNotFound)
+			return
+		}
+		w.Header().Add(constants.HTTP_CONTENT_TYPE, constants.HTTP_CONTENT_SCHEMA_JSON)
+	} else {
+		w.Header().Add(constants.HTTP_CONTENT_TYPE, constants.HTTP_CONTENT_JSON)
+	}
+	response, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(response))
+}
+
+// serveTypeScript answers format=ts with TypeScript types and a fetch client generated
+// from the OpenAPI description of the registered transactions.
+func (s ApiDocServer) serveTypeScript(w http.ResponseWriter) {
+	title, version := s.info()
+	spec, err := json.Marshal(BuildOpenAPI(department.DispatcherHolder, title, version))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	api, err := codegen.LoadOpenAPI(spec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add(constants.HTTP_CONTENT_TYPE, constants.HTTP_CONTENT_TYPESCRIPT)
+	w.Write(codegen.GenerateTypeScript(api))
+}
+
 func ServJsonApiDoc() {
 	http.Handle("/help", ApiDocServer{})
+	http.Handle("/help/schema/", ApiDocServer{})
 }
 
 func printToonMap(w http.ResponseWriter, data interface{}, indent int) {
@@ -272,18 +472,119 @@ func printToonMap(w http.ResponseWriter, data interface{}, indent int) {
 	}
 }
 
-// ServJsonApi starts the HTTP server and applies CORS/same-origin controls if configured
+// ServJsonApi starts the HTTP server and applies CORS/same-origin controls if configured.
+// It exits the process when the server fails; JsonApiServer returns the error and can be
+// shut down gracefully.
 func ServJsonApi(register *department.RegisterDispatcher) {
-	var handler http.Handler = register
+	log.Fatal((&JsonApiServer{Register: register, Mux: http.DefaultServeMux}).ListenAndServe())
+}
+
+// NewHandler returns the JSON API of register as an http.Handler, to mount in an existing
+// router or to serve with an httptest.Server: the JSON endpoint with request logging, the
+// JSON-RPC, WebSocket and SSE endpoints when enabled, and /help when docs is set, all with
+// the CORS and compression options of register. Paths start with register.PathPrefix,
+// which is stripped before the request reaches the endpoints.
+func NewHandler(register *department.RegisterDispatcher, docs *ApiDocServer) (http.Handler, error) {
+	if register == nil {
+		return nil, errNoRegister
+	}
+	enableMock(register)
+	return newJsonApiHandler(register, docs, nil), nil
+}
+
+// newJsonApiHandler builds the handler of NewHandler; WebSocket connections are tracked by conns.
+func newJsonApiHandler(register *department.RegisterDispatcher, docs *ApiDocServer, conns *connTracker) http.Handler {
+	// apply sensible defaults (permissive CORS) to allow external control later
+	corsOptions := (&model.CORSOptions{}).WithDefaults()
 	if register != nil && register.CORS != nil {
-		handler = withCORS(handler, register.CORS)
-	} else {
-		// apply sensible defaults (permissive CORS) to allow external control later
-		defaults := (&model.CORSOptions{}).WithDefaults()
-		handler = withCORS(handler, defaults)
+		corsOptions = register.CORS
+	}
+	wrap := func(handler http.Handler) http.Handler {
+		if register != nil && register.Compression != nil && register.Compression.Enabled {
+			handler = withCompression(handler, register.Compression)
+		}
+		return withCORS(handler, corsOptions)
+	}
+	prefix := pathPrefix(register)
+	mux := http.NewServeMux()
+	mux.Handle("/", wrap(register))
+	if docs != nil {
+		help := *docs
+		if help.ConsoleEndpoint == "" {
+			help.ConsoleEndpoint = prefix + "/"
+		}
+		mux.Handle("/help", help)
+		mux.Handle("/help/schema/", help)
+	}
+	if register != nil && register.JSONRPC != nil && register.JSONRPC.Enabled {
+		// the JSON-RPC adapter shares logging and execution with the main handler
+		rpc := *register
+		rpc.MainFunc = department.JsonRpcMainFunc
+		mux.Handle(register.JSONRPC.WithDefaults().Path, wrap(rpc))
+	}
+	if register != nil && register.WebSocket != nil && register.WebSocket.Enabled {
+		// no compression wrapper, the connection is hijacked
+		mux.Handle(register.WebSocket.WithDefaults().Path, withCORS(WebSocketServer{Register: register, conns: conns}, corsOptions))
+	}
+	if register != nil && register.SSE != nil && register.SSE.Enabled {
+		events := *register
+		events.MainFunc = department.EventsMainFunc
+		// retain events from the start, so early ones can be replayed to reconnecting clients
+		pubsub.DefaultBroker.Retain(register.SSE.WithDefaults().ReplaySize)
+		mux.Handle(register.SSE.WithDefaults().Path, wrap(events))
+	}
+	return withPathPrefix(prefix, mux)
+}
+
+// pathPrefix returns register.PathPrefix with a leading and without a trailing slash, "" for none.
+func pathPrefix(register *department.RegisterDispatcher) string {
+	if register == nil {
+		return ""
+	}
+	return strings.TrimRight("/"+strings.Trim(register.PathPrefix, "/"), "/")
+}
+
+// withPathPrefix serves the requests below prefix with the prefix removed from their path,
+// like http.StripPrefix, and answers 404 to the others. The prefix itself maps to "/".
+func withPathPrefix(prefix string, next http.Handler) http.Handler {
+	if prefix == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || (rest != "" && rest[0] != '/') {
+			http.NotFound(w, r)
+			return
+		}
+		if rest == "" {
+			rest = "/"
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = rest
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// enableMock switches the registered transactions to mock mode when register.Mock asks for it.
+func enableMock(register *department.RegisterDispatcher) {
+	if register != nil && register.Mock != nil && register.Mock.Enabled {
+		department.EnableMock(register.Mock)
+	}
+}
+
+// newHTTPServer builds the http.Server for the JSON API with the protocols enabled by register.HTTP2.
+func newHTTPServer(register *department.RegisterDispatcher) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	if register.HTTP2 != nil {
+		protocols.SetUnencryptedHTTP2(register.HTTP2.Cleartext)
+		protocols.SetHTTP2(register.HTTP2.TLSEnabled())
 	}
-	http.Handle("/", handler)
-	log.Fatal(http.ListenAndServe(":"+register.Port, nil))
+	return &http.Server{Addr: listenAddress(register.Port), Protocols: protocols}
 }
 
 // withCORS wraps the given handler with CORS and optional same-origin enforcement
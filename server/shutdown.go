@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os/signal"
+	"sync"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// ErrServerClosed is returned by the Serve methods after Shutdown. It is http.ErrServerClosed,
+// so either can be checked.
+var ErrServerClosed = http.ErrServerClosed
+
+// errNoRegister is returned for a server or handler without a RegisterDispatcher.
+var errNoRegister = errors.New("a RegisterDispatcher is required")
+
+// errGoingAway is reported by the frame readers of a draining connection.
+var errGoingAway = errors.New("server is shutting down")
+
+// JsonApiServer runs the HTTP transports of Register (JSON API, JSON-RPC, WebSocket and SSE)
+// like ServJsonApi, but returns errors instead of exiting and can be shut down gracefully.
+type JsonApiServer struct {
+	Register *department.RegisterDispatcher
+	// Docs serves /help next to the API when set (see NewHandler).
+	Docs *ApiDocServer
+	// Mux receives the handler of NewHandler below Register.PathPrefix; nil uses a new
+	// ServeMux. ServJsonApi and Run use http.DefaultServeMux, next to ServJsonApiDoc.
+	Mux *http.ServeMux
+	// HTTPServer is used instead of a server built from Register.Port and Register.HTTP2,
+	// e.g. to set timeouts or a TLS configuration. When its Handler is set it is served as
+	// is, and Mux is not used.
+	HTTPServer *http.Server
+
+	mu       sync.Mutex
+	server   *http.Server
+	shutdown chan struct{}
+	closed   bool
+	conns    connTracker
+}
+
+// ListenAndServe listens on the address of HTTPServer, or else on Register.Port, and calls
+// Serve. Either may be a unix socket such as "unix:///run/orders.sock" (see
+// RegisterDispatcher.UnixSocket).
+func (s *JsonApiServer) ListenAndServe() error {
+	if s.Register == nil {
+		return errNoRegister
+	}
+	addr := listenAddress(s.Register.Port)
+	if s.HTTPServer != nil && s.HTTPServer.Addr != "" {
+		addr = s.HTTPServer.Addr
+	}
+	ln, err := listen(addr, s.Register.UnixSocket)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve answers the requests of ln, e.g. a socket passed by systemd, until Shutdown; it then
+// returns ErrServerClosed. TLS is used when Register.HTTP2 has a certificate or HTTPServer
+// a TLS configuration with certificates.
+func (s *JsonApiServer) Serve(ln net.Listener) error {
+	srv, err := s.start()
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	switch {
+	case s.Register.HTTP2.TLSEnabled():
+		return srv.ServeTLS(ln, s.Register.HTTP2.CertFile, s.Register.HTTP2.KeyFile)
+	case srv.TLSConfig != nil && (len(srv.TLSConfig.Certificates) > 0 || srv.TLSConfig.GetCertificate != nil):
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
+
+func (s *JsonApiServer) start() (*http.Server, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrServerClosed
+	}
+	if s.server != nil {
+		return s.server, nil
+	}
+	if s.Register == nil {
+		return nil, errNoRegister
+	}
+	srv := s.HTTPServer
+	if srv == nil {
+		srv = newHTTPServer(s.Register)
+	}
+	enableMock(s.Register)
+	if srv.Handler == nil {
+		mux := s.Mux
+		if mux == nil {
+			mux = http.NewServeMux()
+		}
+		handler := newJsonApiHandler(s.Register, s.Docs, &s.conns)
+		if prefix := pathPrefix(s.Register); prefix != "" {
+			mux.Handle(prefix, handler)
+			mux.Handle(prefix+"/", handler)
+		} else {
+			mux.Handle("/", handler)
+		}
+		srv.Handler = mux
+	}
+	s.shutdown = make(chan struct{})
+	shutdown, base := s.shutdown, srv.BaseContext
+	srv.BaseContext = func(ln net.Listener) context.Context {
+		ctx := context.Background()
+		if base != nil {
+			ctx = base(ln)
+		}
+		return department.ContextWithShutdown(ctx, shutdown)
+	}
+	connContext := srv.ConnContext
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, c)
+		}
+		return PeerConnContext(ctx, c)
+	}
+	s.server = srv
+	return srv, nil
+}
+
+// Shutdown stops accepting requests and waits until the running ones have finished. Topic
+// event streams end with a GoingAway event and WebSocket connections are drained like stream
+// connections (see StreamServer.Shutdown). When ctx ends first, the remaining connections
+// are closed and the context's error is returned.
+func (s *JsonApiServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	srv := s.server
+	if !s.closed && s.shutdown != nil {
+		close(s.shutdown)
+	}
+	s.closed = true
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	s.conns.drain()
+	err := srv.Shutdown(ctx)
+	return errors.Join(err, s.conns.wait(ctx))
+}
+
+// StreamServer runs the stream API of Register like ServStreamApi, but returns errors instead
+// of logging them and can be shut down gracefully. Serve accepts any listener, e.g. a socket
+// passed by systemd.
+type StreamServer struct {
+	Register *department.RegisterDispatcher
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	closed    bool
+	conns     connTracker
+	stats     streamCounters
+}
+
+// Stats returns the counters of the connections of s and the options it applies.
+func (s *StreamServer) Stats() model.StreamStats {
+	stats := s.stats.snapshot()
+	stats.Options = *streamOptions(s.Register)
+	return stats
+}
+
+// ListenAndServe listens on Register.StreamPort, a port or a unix socket, and calls Serve.
+func (s *StreamServer) ListenAndServe() error {
+	var port string
+	var options *model.UnixSocketOptions
+	if s.Register != nil {
+		port, options = s.Register.StreamPort, s.Register.UnixSocket
+	}
+	ln, err := listen(listenAddress(deriveStreamPort(port)), options)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts the connections of ln until Shutdown; it then returns ErrServerClosed.
+func (s *StreamServer) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = map[net.Listener]struct{}{}
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+
+	enableMock(s.Register)
+	err := serveStreamListener(ln, s.Register, &s.conns, &s.stats)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, ln)
+	if s.closed {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// Shutdown closes the listeners and drains the open connections: each is sent a GoingAway
+// document (a frame with ID 0 on version 2) and reads no further requests, its subscriptions
+// end, and it is closed once its running requests have been answered. When ctx ends first,
+// the remaining connections are closed and the context's error is returned.
+func (s *StreamServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	s.mu.Unlock()
+	s.conns.drain()
+	return s.conns.wait(ctx)
+}
+
+// Run serves the JSON API of register, and the stream API when StreamPort is set, until ctx
+// ends or the process receives one of RegisterDispatcher.Shutdown.Signals. Both servers then
+// stop accepting work and in-flight transactions, with their dispatchings, get
+// Shutdown.Timeout to finish. Run returns nil after a clean shutdown, otherwise the error
+// that stopped a server or the shutdown.
+func Run(ctx context.Context, register *department.RegisterDispatcher) error {
+	options := register.Shutdown.WithDefaults()
+	ctx, stop := signal.NotifyContext(ctx, options.Signals...)
+	defer stop()
+
+	errs := make(chan error, 2)
+	jsonApi := &JsonApiServer{Register: register, Mux: http.DefaultServeMux}
+	go func() { errs <- jsonApi.ListenAndServe() }()
+	var stream *StreamServer
+	if register.StreamPort != "" {
+		stream = &StreamServer{Register: register}
+		go func() { errs <- stream.ListenAndServe() }()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+	log.Printf("shutting down, waiting up to %v for in-flight requests", options.Timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	shutdownErrs := make([]error, 2)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		shutdownErrs[0] = jsonApi.Shutdown(shutdownCtx)
+	}()
+	if stream != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shutdownErrs[1] = stream.Shutdown(shutdownCtx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(err, shutdownErrs[0], shutdownErrs[1])
+}
+
+// drainer is a connection that can stop reading requests for a shutdown.
+type drainer interface {
+	drain()
+}
+
+// connTracker keeps the open connections of a server, so a shutdown can drain them and wait
+// until they are done. The zero value is ready to use; a nil tracker tracks nothing.
+type connTracker struct {
+	mu       sync.Mutex
+	conns    map[net.Conn]drainer
+	draining bool
+	wg       sync.WaitGroup
+}
+
+// add tracks conn until done is called. It reports false once the server is draining.
+func (t *connTracker) add(conn net.Conn, d drainer) (done func(), ok bool) {
+	if t == nil {
+		return func() {}, true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return nil, false
+	}
+	if t.conns == nil {
+		t.conns = map[net.Conn]drainer{}
+	}
+	t.conns[conn] = d
+	t.wg.Add(1)
+	return func() {
+		t.mu.Lock()
+		delete(t.conns, conn)
+		t.mu.Unlock()
+		t.wg.Done()
+	}, true
+}
+
+func (t *connTracker) drain() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.draining = true
+	for _, d := range t.conns {
+		d.drain()
+	}
+}
+
+// wait returns when every tracked connection is done, or closes them when ctx ends first.
+func (t *connTracker) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		for conn := range t.conns {
+			conn.Close()
+		}
+		t.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/logger"
+)
+
+func TestStreamServerShutdown(t *testing.T) {
+	registerSleep()
+	defer func() { department.DispatcherHolder = nil }()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	srv := &StreamServer{Register: &department.RegisterDispatcher{}}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	idle, err := NewStreamClient("127.0.0.1", port, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	busy, err := NewStreamClient("127.0.0.1", port, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	if err := busy.EnableMultiplexing(); err != nil {
+		t.Fatal(err)
+	}
+	running := make(chan error, 1)
+	go func() {
+		_, err := busy.Send(sleepDocument(300))
+		running <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := <-running; err != nil {
+		t.Errorf("the running call must be answered: %v", err)
+	}
+	if _, err := busy.Send(sleepDocument(0)); !errors.Is(err, ErrServerGoingAway) {
+		t.Errorf("calls after the shutdown must fail with ErrServerGoingAway, got %v", err)
+	}
+	if _, err := idle.Send(sleepDocument(0)); !errors.Is(err, ErrServerGoingAway) || !idle.Broken() {
+		t.Errorf("an idle version 1 connection must be told to go away, got %v", err)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve must return ErrServerClosed, got %v", err)
+	}
+}
+
+func TestStreamServerShutdownDeadline(t *testing.T) {
+	srv := &StreamServer{Register: &department.RegisterDispatcher{}}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	done, _ := srv.conns.add(serverConn, noDrain{})
+	defer done()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("a connection that does not finish must end the shutdown at the deadline, got %v", err)
+	}
+	if _, err := clientConn.Write([]byte("{}\n")); err == nil {
+		t.Errorf("the remaining connections must be closed")
+	}
+}
+
+type noDrain struct{}
+
+func (noDrain) drain() {}
+
+func TestJsonApiServerShutdown(t *testing.T) {
+	registerSleep()
+	defer func() { department.DispatcherHolder = nil }()
+	register := department.NewRegisteryDispatcher("0")
+	register.SSE = &model.SSEOptions{Enabled: true}
+	register.PubSub = &model.PubSubOptions{Enabled: true}
+	register.WebSocket = &model.WebSocketOptions{Enabled: true}
+	register.LoggerWriter = func(logger.LogEntry) error { return nil }
+	httpServer := httptest.NewUnstartedServer(nil)
+	srv := &JsonApiServer{Register: register, Mux: http.NewServeMux()}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(httpServer.Listener) }()
+	url := "http://" + httpServer.Listener.Addr().String()
+
+	running := make(chan error, 1)
+	go func() {
+		_, err := CallHTTP(url, sleepDocument(300))
+		running <- err
+	}()
+	events, err := http.Get(url + "/events?topic=shutdown.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+	ws, status := dialWebSocket(t, httpServer, "/ws", "")
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("websocket upgrade failed: %d", status)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := <-running; err != nil {
+		t.Errorf("the running request must be answered: %v", err)
+	}
+	reader := bufio.NewReader(events.Body)
+	if event := readSSE(t, reader); event.name != constants.DOC_TYPE_GOING_AWAY {
+		t.Errorf("topic streams must end with a GoingAway event, got %q", event.name)
+	}
+	if _, document := ws.receiveFrame(t); document.Type != constants.DOC_TYPE_GOING_AWAY {
+		t.Errorf("websocket connections must be told to go away: %+v", document)
+	}
+	if opcode, payload := ws.receive(t); opcode != wsClose || !strings.HasPrefix(string(payload), "\x03\xe9") {
+		t.Errorf("websocket connections must be closed with 1001: %d %q", opcode, payload)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve must return ErrServerClosed, got %v", err)
+	}
+}
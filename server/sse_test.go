@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/pubsub"
+)
+
+type sseEvent struct {
+	id, name string
+	document model.Document
+}
+
+// readSSE returns the next event of a text/event-stream, skipping retry hints and comments.
+func readSSE(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && event.name != "":
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.document); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func sseServer(register *department.RegisterDispatcher) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		department.EventsMainFunc(w, r.WithContext(department.ContextWithRegister(r.Context(), register)))
+	}))
+}
+
+func getEvents(t *testing.T, server *httptest.Server, query, lastEventID string) *http.Response {
+	t.Helper()
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/events?"+query, nil)
+	if lastEventID != "" {
+		request.Header.Set("Last-Event-ID", lastEventID)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestSSE_Transaction(t *testing.T) {
+	registerRows()
+	defer func() { department.DispatcherHolder = nil }()
+	server := sseServer(&department.RegisterDispatcher{SSE: &model.SSEOptions{Enabled: true, Retry: 2 * time.Second}})
+	defer server.Close()
+
+	response := getEvents(t, server, "department=Report&transaction=rows&form="+url.QueryEscape(`{"count":2}`), "")
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != constants.HTTP_CONTENT_EVENT_STREAM {
+		t.Fatalf("unexpected content type %q", response.Header.Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(response.Body)
+	if !strings.HasPrefix(string(body), "retry: 2000\n") {
+		t.Errorf("the retry hint must come first: %q", body)
+	}
+	reader := bufio.NewReader(strings.NewReader(string(body)))
+	var names, ids []string
+	for i := 0; i < 3; i++ {
+		event := readSSE(t, reader)
+		names, ids = append(names, event.name), append(ids, event.id)
+	}
+	if strings.Join(names, ",") != "Stream,Stream,Result" || strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("unexpected events: %v %v", names, ids)
+	}
+
+	// EventSource reconnects after the stream ends; 204 tells it to stop
+	response = getEvents(t, server, "department=Report&transaction=rows", "3")
+	response.Body.Close()
+	if response.StatusCode != http.StatusNoContent {
+		t.Errorf("a finished transaction stream must not be run again, got %d", response.StatusCode)
+	}
+	response = getEvents(t, server, "department=Report&transaction=missing", "")
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown transactions must be rejected, got %d", response.StatusCode)
+	}
+}
+
+func TestSSE_Topics(t *testing.T) {
+	register := &department.RegisterDispatcher{
+		SSE: &model.SSEOptions{Enabled: true, RateLimiter: model.RateLimitOptions{Enabled: true, Limit: 2, Window: 60, Scope: model.ScopeIP}},
+		PubSub: &model.PubSubOptions{Enabled: true, Authorize: func(pattern string, security *model.Security) error {
+			if security == nil || security.Licence != "dashboard" {
+				return errors.New("not allowed")
+			}
+			return nil
+		}},
+	}
+	server := sseServer(register)
+	defer server.Close()
+	// rate limiters are kept per topic list for the whole process
+	prefix := fmt.Sprintf("sse%d", time.Now().UnixNano())
+
+	response := getEvents(t, server, "topic="+prefix+".>", "")
+	response.Body.Close()
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("the subscription must be authorized, got %d", response.StatusCode)
+	}
+
+	query := "topic=" + prefix + ".orders.*&topic=" + prefix + ".stock&licence=dashboard"
+	response = getEvents(t, server, query, "")
+	reader := bufio.NewReader(response.Body)
+	// the subscription exists once the response has started
+	if line, _ := reader.ReadString('\n'); !strings.HasPrefix(line, "retry:") {
+		t.Fatalf("unexpected first line %q", line)
+	}
+	if _, err := pubsub.Publish(prefix+".orders.created", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	first := readSSE(t, reader)
+	if first.name != constants.DOC_TYPE_EVENT || first.document.Procedure != prefix+".orders.created" || first.id == "" {
+		t.Fatalf("unexpected event: %+v", first)
+	}
+	response.Body.Close()
+
+	// published while the client was away
+	_, _ = pubsub.Publish(prefix+".orders.shipped", nil)
+	_, _ = pubsub.Publish(prefix+".other", nil)
+	_, _ = pubsub.Publish(prefix+".stock", nil)
+
+	response = getEvents(t, server, query, first.id)
+	defer response.Body.Close()
+	reader = bufio.NewReader(response.Body)
+	if topic := readSSE(t, reader).document.Procedure; topic != prefix+".orders.shipped" {
+		t.Errorf("missed events must be replayed in order, got %v", topic)
+	}
+	if topic := readSSE(t, reader).document.Procedure; topic != prefix+".stock" {
+		t.Errorf("missed events must be replayed in order, got %v", topic)
+	}
+
+	response = getEvents(t, server, query, "")
+	response.Body.Close()
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("opening topic streams must be rate limited, got %d", response.StatusCode)
+	}
+}
@@ -1,14 +1,18 @@
 package server
 
 import (
-	"bufio"
+	"bytes"
+	"compress/flate"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
-	"strings"
+	"sync"
+	"time"
 
+	"github.com/godispatcher/dispatcher/constants"
 	"github.com/godispatcher/dispatcher/department"
 	"github.com/godispatcher/dispatcher/model"
 )
@@ -17,25 +21,20 @@ import (
 // Each request and response is a single line JSON (newline-delimited JSON).
 // The request JSON must conform to model.Document, at minimum including department, transaction, and form.
 // Responses mirror HTTP behavior and contain a model.Document with either output or error.
+// Connections may switch to protocol version 2 (see model.StreamFrame), where requests are
+// tagged with an ID, executed concurrently and answered in completion order, and where
+// clients may subscribe to pubsub topics (RegisterDispatcher.PubSub).
 func ServStreamApi(register *department.RegisterDispatcher) {
 	// Derive stream port by incrementing HTTP port by 1 (e.g., 9000 -> 9001)
 	port := deriveStreamPort(register.StreamPort)
-	ln, err := net.Listen("tcp", ":"+port)
+	address := listenAddress(port)
+	ln, err := listen(address, register.UnixSocket)
 	if err != nil {
-		log.Printf("stream api listen error on port %s: %v", port, err)
+		log.Printf("stream api listen error on %s: %v", address, err)
 		return
 	}
-	log.Printf("stream api listening on :%s (NDJSON)\n", port)
-	go func() {
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				log.Printf("stream api accept error: %v", err)
-				continue
-			}
-			go handleStreamConn(conn)
-		}
-	}()
+	log.Printf("stream api listening on %s (NDJSON)\n", address)
+	go (&StreamServer{Register: register}).Serve(ln)
 }
 
 func deriveStreamPort(httpPort string) string {
@@ -45,72 +44,382 @@ func deriveStreamPort(httpPort string) string {
 	return httpPort
 }
 
-func handleStreamConn(conn net.Conn) {
+func handleStreamConn(conn net.Conn, register *department.RegisterDispatcher) {
+	serveStreamConn(conn, register, nil, nil)
+}
+
+// serveStreamConn serves a stream connection; conns tracks it for a graceful shutdown and
+// stats counts it, next to the counters of the process.
+func serveStreamConn(conn net.Conn, register *department.RegisterDispatcher, conns *connTracker, stats *streamCounters) {
+	stats.add(counterActive, 1)
+	defer stats.add(counterActive, -1)
 	defer conn.Close()
-	reader := bufio.NewScanner(conn)
-	// increase max token size to allow larger payloads (~10MB)
-	buf := make([]byte, 0, 64*1024)
-	reader.Buffer(buf, 10*1024*1024)
-
-	for reader.Scan() {
-		line := strings.TrimSpace(reader.Text())
-		if line == "" {
-			continue
+	opts := streamOptions(register)
+	src := &switchableReader{r: conn}
+	reader := newStreamLineReader(conn, src, opts, stats)
+	out := &streamWriter{w: conn, conn: conn, timeout: opts.WriteTimeout}
+	defer out.close()
+	done, ok := conns.add(conn, reader)
+	if !ok {
+		_ = out.writeLine(goingAwayLine())
+		return
+	}
+	defer done()
+	peer := peerCredentials(conn)
+
+	for {
+		line, err := reader.next()
+		if err == errGoingAway {
+			_ = out.writeLine(goingAwayLine())
+			return
 		}
-		var document model.Document
-		if err := json.Unmarshal([]byte(line), &document); err != nil {
-			writeStreamError(conn, err)
+		var tooLarge errFrameTooLarge
+		if errors.As(err, &tooLarge) {
+			if out.writeLine(streamErrorLine(tooLarge)) != nil {
+				return
+			}
 			continue
 		}
-
-		responseDoc := executeDocument(document)
-		b, err := json.Marshal(responseDoc)
 		if err != nil {
-			writeStreamError(conn, err)
+			if err != io.EOF {
+				log.Printf("stream conn read error: %v", err)
+			}
+			return
+		}
+		if document, ok := streamControlDocument(line); ok {
+			switch document.Type {
+			case constants.DOC_TYPE_COMPRESSION:
+				err = negotiateStreamCompression(out, src, document, register)
+			case constants.DOC_TYPE_PROTOCOL:
+				var upgraded bool
+				if upgraded, err = negotiateStreamProtocol(out, document); upgraded && err == nil {
+					serveStreamFrames(reader, out, register, peer)
+					return
+				}
+			case constants.DOC_TYPE_PING:
+				err = out.writeLine(streamControlAnswer(document))
+			case constants.DOC_TYPE_SUBSCRIBE, constants.DOC_TYPE_UNSUBSCRIBE:
+				err = out.writeDocument(model.Document{Type: constants.DOC_TYPE_ERROR, Error: "topic subscriptions need stream protocol version 2"})
+			}
+			if err != nil {
+				return
+			}
+			continue
+		}
+		response, ok := streamResponse(line, register, peer)
+		if !ok {
 			continue
 		}
 		// Send response followed by newline
-		if _, err := fmt.Fprintln(conn, string(b)); err != nil {
+		if err := out.writeLine(response); err != nil {
 			return
 		}
 	}
-	// Optionally log scanner error
-	if err := reader.Err(); err != nil {
-		log.Printf("stream conn scanner error: %v", err)
-	}
 }
 
-func writeStreamError(conn net.Conn, err error) {
-	out := model.Document{Type: "Error", Error: err.Error()}
-	b, _ := json.Marshal(out)
-	_, _ = fmt.Fprintln(conn, string(b))
+// frameReader returns the frames of a protocol version 2 connection one by one, e.g. the
+// lines of a TCP connection or WebSocket messages, then an error such as io.EOF.
+type frameReader interface {
+	next() ([]byte, error)
 }
 
-// executeDocument mirrors the core logic from HTTP RegisterMainFunc without HTTP specifics.
-func executeDocument(document model.Document) model.Document {
-	if (&document) == nil {
-		return model.Document{Type: "Error", Error: errors.New("invalid document").Error()}
+// activityTracker is implemented by frame readers that need to know when requests and
+// subscriptions of the connection run, e.g. to apply an idle timeout.
+type activityTracker interface {
+	begin()
+	end()
+}
+
+type noActivity struct{}
+
+func (noActivity) begin() {}
+func (noActivity) end()   {}
+
+// frameWriter writes the response frames of a protocol version 2 connection.
+type frameWriter interface {
+	writeFrame(frame model.StreamFrame) error
+}
+
+// serveStreamFrames runs a protocol version 2 connection: requests are executed concurrently,
+// up to StreamOptions.Concurrency at a time, and each response is written as soon as it is ready.
+// peer, the unix socket peer of the connection, is set on the Security of every request.
+func serveStreamFrames(in frameReader, out frameWriter, register *department.RegisterDispatcher, peer *model.PeerCredentials) {
+	var options *model.StreamOptions
+	if register != nil {
+		options = register.Stream
+	}
+	sem := make(chan struct{}, options.WithDefaults().Concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	activity, ok := in.(activityTracker)
+	if !ok {
+		activity = noActivity{}
 	}
-	// Find transaction
-	ta := department.DispatcherHolder.GetTransaction(document.Department, document.Transaction)
-	if ta != nil {
-		outputDoc := (*ta).GetTransaction().Init(document)
+	subscriptions := &streamSubscriptions{activity: activity, peer: peer}
+	defer subscriptions.close()
 
-		// Chain dispatchings if provided
-		if document.Dispatchings != nil {
-			for _, v := range document.Dispatchings {
-				cta := department.DispatcherHolder.GetTransaction(v.Department, v.Transaction)
-				if cta != nil {
-					dOutputDoc := (*cta).GetTransaction().Init(*v)
-					outputDoc.Dispatchings = append(outputDoc.Dispatchings, &dOutputDoc)
-					// if an error occurs in a chained dispatching, stop early
-					if dOutputDoc.Error != nil {
-						break
-					}
-				}
+	for {
+		line, err := in.next()
+		if err == errGoingAway {
+			_ = out.writeFrame(model.StreamFrame{Body: goingAwayLine()})
+			return
+		}
+		var tooLarge errFrameTooLarge
+		if errors.As(err, &tooLarge) {
+			if out.writeFrame(model.StreamFrame{ID: tooLarge.id, Body: streamErrorLine(tooLarge)}) != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("stream conn read error: %v", err)
+			}
+			return
+		}
+		var frame model.StreamFrame
+		if err := json.Unmarshal(line, &frame); err != nil {
+			if out.writeFrame(model.StreamFrame{Body: streamErrorLine(err)}) != nil {
+				return
+			}
+			continue
+		}
+		if document, ok := streamControlDocument(frame.Body); ok && isSubscriptionDocument(document) {
+			subscriptions.handle(frame.ID, document, out, register)
+			continue
+		}
+		// waiting for a free slot stops reading, which pushes back on the client
+		sem <- struct{}{}
+		wg.Add(1)
+		activity.begin()
+		go func() {
+			defer wg.Done()
+			defer activity.end()
+			defer func() { <-sem }()
+			response := model.StreamFrame{ID: frame.ID}
+			if document, ok := streamControlDocument(frame.Body); ok {
+				response.Body = streamControlAnswer(document)
+			} else if frame.Stream && !department.IsBatchPayload(frame.Body) && !department.IsJsonRpcPayload(frame.Body) {
+				response.Body = streamDocumentFrames(frame.ID, frame.Body, out, peer)
+			} else if body, ok := streamResponse(frame.Body, register, peer); ok {
+				response.Body = body
 			}
+			_ = out.writeFrame(response)
+		}()
+	}
+}
+
+// streamResponse executes a JSON-RPC payload, a batch or a document and returns the response
+// line. Decoding and batch errors are answered with an error document. The boolean is false
+// when nothing must be written back, i.e. for JSON-RPC notifications.
+func streamResponse(line []byte, register *department.RegisterDispatcher, peer *model.PeerCredentials) ([]byte, bool) {
+	if register != nil && register.JSONRPC != nil && register.JSONRPC.Enabled && department.IsJsonRpcPayload(line) {
+		var security *model.Security
+		if peer != nil {
+			security = &model.Security{Peer: peer}
 		}
-		return outputDoc
+		return department.HandleJsonRpc(line, security, register.Batch)
+	}
+	if department.IsBatchPayload(line) {
+		return streamBatchResponse(line, register, peer), true
+	}
+	var document model.Document
+	if err := json.Unmarshal(line, &document); err != nil {
+		return streamErrorLine(err), true
+	}
+	department.InheritPeer(&document, peer)
+	b, err := json.Marshal(department.ExecuteDocument(document))
+	if err != nil {
+		return streamErrorLine(err), true
+	}
+	return b, true
+}
+
+// streamDocumentFrames executes the document of a streaming request. Every partial output
+// document is written right away in a frame with More set; the terminal document is returned.
+func streamDocumentFrames(id uint64, line []byte, out frameWriter, peer *model.PeerCredentials) []byte {
+	var document model.Document
+	if err := json.Unmarshal(line, &document); err != nil {
+		return streamErrorLine(err)
 	}
-	return model.Document{Department: document.Department, Transaction: document.Transaction, Error: errors.New("transaction not found").Error(), Type: "Error"}
+	department.InheritPeer(&document, peer)
+	terminal := department.ExecuteDocumentStream(document, func(partial model.Document) error {
+		b, err := json.Marshal(partial)
+		if err != nil {
+			return err
+		}
+		return out.writeFrame(model.StreamFrame{ID: id, More: true, Body: b})
+	})
+	b, err := json.Marshal(terminal)
+	if err != nil {
+		return streamErrorLine(err)
+	}
+	return b
+}
+
+// streamBatchResponse executes a JSON array of documents and answers with a single line JSON array.
+func streamBatchResponse(line []byte, register *department.RegisterDispatcher, peer *model.PeerCredentials) []byte {
+	var documents []model.Document
+	if err := json.Unmarshal(line, &documents); err != nil {
+		return streamErrorLine(err)
+	}
+	for i := range documents {
+		department.InheritPeer(&documents[i], peer)
+	}
+	var options *model.BatchOptions
+	if register != nil {
+		options = register.Batch
+	}
+	results, err := department.ExecuteBatch(documents, options)
+	if err != nil {
+		return streamErrorLine(err)
+	}
+	b, err := json.Marshal(results)
+	if err != nil {
+		return streamErrorLine(err)
+	}
+	return b
+}
+
+// streamControlDocument reports whether the line is a compression, protocol, ping or subscription control document.
+func streamControlDocument(line []byte) (model.Document, bool) {
+	if len(line) == 0 || line[0] != '{' || !bytes.Contains(line, []byte(`"type"`)) {
+		return model.Document{}, false
+	}
+	var document model.Document
+	if json.Unmarshal(line, &document) != nil {
+		return model.Document{}, false
+	}
+	switch document.Type {
+	case constants.DOC_TYPE_COMPRESSION, constants.DOC_TYPE_PROTOCOL, constants.DOC_TYPE_PING:
+		return document, true
+	}
+	return document, isSubscriptionDocument(document)
+}
+
+// negotiateStreamProtocol answers a protocol control document on a version 1 connection.
+// It reports whether the connection continues with version 2.
+func negotiateStreamProtocol(out *streamWriter, document model.Document) (bool, error) {
+	if err := out.writeLine(streamControlAnswer(document)); err != nil {
+		return false, err
+	}
+	return fmt.Sprint(document.Procedure) == fmt.Sprint(model.StreamProtocolVersion), nil
+}
+
+// streamControlAnswer acknowledges a supported protocol version and answers a ping. Compression
+// cannot be switched on once responses are multiplexed, so a compression document is only
+// answered on version 1.
+func streamControlAnswer(document model.Document) []byte {
+	var answer model.Document
+	switch {
+	case document.Type == constants.DOC_TYPE_PING:
+		answer = model.Document{Type: constants.DOC_TYPE_PING, Procedure: document.Procedure}
+	case document.Type == constants.DOC_TYPE_COMPRESSION:
+		answer = model.Document{Type: constants.DOC_TYPE_ERROR, Error: "stream compression must be negotiated before protocol version 2"}
+	case fmt.Sprint(document.Procedure) == "1" || fmt.Sprint(document.Procedure) == fmt.Sprint(model.StreamProtocolVersion):
+		answer = model.Document{Type: constants.DOC_TYPE_PROTOCOL, Procedure: document.Procedure}
+	default:
+		answer = model.Document{Type: constants.DOC_TYPE_ERROR, Error: fmt.Sprintf("unsupported stream protocol version %v", document.Procedure)}
+	}
+	b, _ := json.Marshal(answer)
+	return b
+}
+
+// negotiateStreamCompression answers a compression control document. When the server has
+// compression enabled the acknowledgement is sent uncompressed and both directions switch
+// to raw deflate right after it; otherwise an error document is returned and the connection stays plain.
+func negotiateStreamCompression(out *streamWriter, src *switchableReader, document model.Document, register *department.RegisterDispatcher) error {
+	if register == nil || register.Compression == nil || !register.Compression.Enabled {
+		return out.writeDocument(model.Document{Type: constants.DOC_TYPE_ERROR, Error: "stream compression is not enabled"})
+	}
+	if document.Procedure != encodingDeflate {
+		return out.writeDocument(model.Document{Type: constants.DOC_TYPE_ERROR, Error: fmt.Sprintf("unsupported stream compression %v", document.Procedure)})
+	}
+	if err := out.writeDocument(model.Document{Type: constants.DOC_TYPE_COMPRESSION, Procedure: encodingDeflate}); err != nil {
+		return err
+	}
+	src.r = flate.NewReader(src.r)
+	return out.enableDeflate(register.Compression.WithDefaults().Level)
+}
+
+// goingAwayLine tells a client that the server is shutting down and reads no further requests.
+func goingAwayLine() []byte {
+	b, _ := json.Marshal(model.Document{Type: constants.DOC_TYPE_GOING_AWAY, Error: errGoingAway.Error()})
+	return b
+}
+
+func streamErrorLine(err error) []byte {
+	b, _ := json.Marshal(model.Document{Type: constants.DOC_TYPE_ERROR, Error: err.Error()})
+	return b
+}
+
+// streamWriter serializes response lines on a stream connection and applies the
+// compression negotiated for the connection.
+type streamWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	deflate *flate.Writer
+	conn    net.Conn      // write deadlines are set on conn when timeout is set
+	timeout time.Duration // StreamOptions.WriteTimeout
+}
+
+func (sw *streamWriter) writeLine(b []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.conn != nil && sw.timeout > 0 {
+		_ = sw.conn.SetWriteDeadline(time.Now().Add(sw.timeout))
+	}
+	if _, err := sw.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if sw.deflate != nil {
+		return sw.deflate.Flush()
+	}
+	return nil
+}
+
+func (sw *streamWriter) writeDocument(document model.Document) error {
+	b, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return sw.writeLine(b)
+}
+
+func (sw *streamWriter) writeFrame(frame model.StreamFrame) error {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return sw.writeLine(b)
+}
+
+func (sw *streamWriter) enableDeflate(level int) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	fw, err := flate.NewWriter(sw.w, level)
+	if err != nil {
+		return err
+	}
+	sw.deflate = fw
+	sw.w = fw
+	return nil
+}
+
+func (sw *streamWriter) close() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.deflate != nil {
+		_ = sw.deflate.Close()
+	}
+}
+
+// switchableReader lets a connection swap its byte source, e.g. to a deflate reader,
+// without recreating the line scanner on top of it.
+type switchableReader struct {
+	r io.Reader
+}
+
+func (s *switchableReader) Read(p []byte) (int, error) {
+	return s.r.Read(p)
 }
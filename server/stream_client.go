@@ -2,14 +2,19 @@ package server
 
 import (
 	"bufio"
+	"bytes"
+	"compress/flate"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"iter"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/godispatcher/dispatcher/constants"
 	"github.com/godispatcher/dispatcher/model"
 )
 
@@ -22,42 +27,107 @@ import (
 //	defer c.Close()
 //	resp, err := c.Send(model.Document{Department: "product", Transaction: "list"})
 //
-// The client maintains a single persistent connection and supports sequential calls.
-// It is safe for concurrent use only for one-inflight request at a time; if you need
-// true concurrency, create multiple clients.
+// The client maintains a single persistent connection and is safe for concurrent use.
+// By default calls are sequential, one request in flight at a time. After
+// EnableMultiplexing many calls share the connection and responses are matched by ID.
 //
 // Timeouts: A write+read deadline is applied per Send call if ReadWriteTimeout > 0.
 // DialTimeout is used when establishing the connection.
 type StreamClient struct {
 	conn             net.Conn
 	reader           *bufio.Reader
+	deflate          *flate.Writer
 	mu               sync.Mutex
 	ReadWriteTimeout time.Duration
+
+	inflight atomic.Int32 // calls sent or waiting to be sent
+	dialed   time.Time
+	lastCall atomic.Int64 // unix nanoseconds of the last call, pings aside
+	lastPing atomic.Int64
+	// protocol version 2
+	multiplexed bool
+	nextID      uint64
+	pendingMu   sync.Mutex
+	pending     map[uint64]*pendingCall
+	readErr     error
+
+	// reconnection, see EnableReconnect
+	network, addr string
+	dialTimeout   time.Duration
+	reconnect     atomic.Pointer[model.ReconnectOptions]
+	reconnectMu   sync.Mutex
+	gen           atomic.Uint64 // incremented for every new connection
+	compressed    bool          // restored on a new connection
+	wantMultiplex bool          // restored on a new connection
+	done          chan struct{} // closed by Close, ends the pauses between dials
+	stateMu       sync.Mutex
+	state         model.StreamClientState
+}
+
+// errClientClosed is returned for calls after Close.
+var errClientClosed = errors.New("client is closed")
+
+// ErrServerGoingAway is returned for calls on a connection whose server is shutting down;
+// the client is Broken and must be replaced, e.g. by a connection to another instance.
+// Calls that were already running on a multiplexed connection are still answered.
+var ErrServerGoingAway = errors.New("server is going away")
+
+// ErrMultiplexingUnsupported is returned by EnableMultiplexing when the server only speaks
+// stream protocol version 1.
+var ErrMultiplexingUnsupported = errors.New("stream protocol version 2 is not supported by the server")
+
+type streamReply struct {
+	body json.RawMessage
+	more bool // a partial output document, the terminal document follows
+	err  error
+}
+
+// pendingCall receives the response frames of one request.
+type pendingCall struct {
+	replies chan streamReply
+	done    chan struct{} // closed when the caller stops waiting
+	once    sync.Once
+}
+
+func (p *pendingCall) deliver(reply streamReply) {
+	select {
+	case p.replies <- reply:
+	case <-p.done:
+	}
 }
 
 // NewStreamClient dials the given host:port and returns a connected client.
 // host examples: "127.0.0.1" or "localhost". port example: "9001".
+// A unix socket is dialed with a host such as "unix:///run/orders.sock"; port is then ignored.
 func NewStreamClient(host, port string, dialTimeout time.Duration) (*StreamClient, error) {
 	if strings.TrimSpace(host) == "" {
 		return nil, errors.New("host is required")
 	}
-	if strings.TrimSpace(port) == "" {
+	network, addr := "tcp", net.JoinHostPort(host, port)
+	if isUnixAddress(host) {
+		network, addr = "unix", strings.TrimPrefix(host, unixScheme)
+	} else if strings.TrimSpace(port) == "" {
 		return nil, errors.New("port is required")
 	}
-	addr := net.JoinHostPort(host, port)
 	if dialTimeout <= 0 {
 		dialTimeout = 5 * time.Second
 	}
-	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	conn, err := net.DialTimeout(network, addr, dialTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("dial %s failed: %w", addr, err)
 	}
-	return &StreamClient{
-		conn:   conn,
-		reader: bufio.NewReader(conn),
+	c := &StreamClient{
+		conn:        conn,
+		reader:      bufio.NewReader(conn),
+		dialed:      time.Now(),
+		network:     network,
+		addr:        addr,
+		dialTimeout: dialTimeout,
 		// default per-call timeout
 		ReadWriteTimeout: 15 * time.Second,
-	}, nil
+	}
+	c.lastCall.Store(c.dialed.UnixNano())
+	return c, nil
 }
 
 // NewStreamClientFromHTTPPort derives the stream port from an HTTP port and dials it.
@@ -78,11 +148,477 @@ func deriveStreamPortLocal(httpPort string) string {
 // Send writes a single line JSON document and reads a single line JSON response.
 // If the response's Type is "Error" and Error is set, an error is returned alongside the document.
 func (c *StreamClient) Send(doc model.Document) (model.Document, error) {
+	c.touch()
+	var out model.Document
+	err := c.withReconnect(c.idempotent(doc), func() error {
+		out = model.Document{}
+		return c.roundTrip(doc, &out)
+	})
+	if err != nil {
+		return model.Document{}, err
+	}
+	if strings.EqualFold(out.Type, "Error") && out.Error != nil {
+		return out, fmt.Errorf("remote error: %v", out.Error)
+	}
+	return out, nil
+}
+
+// SendStream calls a streaming transaction and yields its partial output documents as they
+// arrive, followed by the terminal document. Errors end the sequence: a remote error is
+// yielded together with the terminal document, like Send returns it. Breaking out of the
+// loop stops the delivery; the server finishes the transaction and its remaining output is
+// dropped. ReadWriteTimeout limits the wait for each document.
+//
+// Streaming needs a multiplexed connection (EnableMultiplexing). Otherwise, and for
+// transactions that do not stream, the sequence holds the single document returned by Send.
+//
+//	for doc, err := range c.SendStream(model.Document{Department: "report", Transaction: "rows"}) {
+//		if err != nil {
+//			return err
+//		}
+//		handle(doc.Output)
+//	}
+func (c *StreamClient) SendStream(doc model.Document) iter.Seq2[model.Document, error] {
+	return func(yield func(model.Document, error) bool) {
+		c.touch()
+		if err := c.reconnectIfBroken(); err != nil {
+			yield(model.Document{}, err)
+			return
+		}
+		if !c.Multiplexed() {
+			yield(c.Send(doc))
+			return
+		}
+		c.inflight.Add(1)
+		defer c.inflight.Add(-1)
+		id, p, err := c.request(doc, true, 16)
+		if err != nil {
+			yield(model.Document{}, err)
+			return
+		}
+		defer c.forget(id, p)
+		for {
+			reply, err := c.wait(id, p)
+			if err != nil {
+				yield(model.Document{}, err)
+				return
+			}
+			var out model.Document
+			if err := json.Unmarshal(reply.body, &out); err != nil {
+				yield(model.Document{}, err)
+				return
+			}
+			if !reply.more {
+				if strings.EqualFold(out.Type, "Error") && out.Error != nil {
+					yield(out, fmt.Errorf("remote error: %v", out.Error))
+					return
+				}
+				yield(out, nil)
+				return
+			}
+			if !yield(out, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Subscribe receives the events published to the topics matching topic, see package pubsub.
+// The connection is switched to stream protocol version 2 first if needed. The channel is
+// closed when cancel is called, the server ends the subscription or the connection fails;
+// when the server dropped the subscription, e.g. because events were not read in time, the
+// "Error" document saying why is the last value on the channel. Read events promptly: the
+// other calls on the connection wait while an event is not taken.
+func (c *StreamClient) Subscribe(topic string) (<-chan model.Document, func(), error) {
+	return c.SubscribeDocument(model.Document{Procedure: topic})
+}
+
+// SubscribeDocument is Subscribe with a complete subscribe document, e.g. to send Security
+// for the server's authorization check or a Form that events must match field by field.
+func (c *StreamClient) SubscribeDocument(subscription model.Document) (<-chan model.Document, func(), error) {
+	if err := c.EnableMultiplexing(); err != nil {
+		return nil, nil, err
+	}
+	c.touch()
+	if err := c.reconnectIfBroken(); err != nil {
+		return nil, nil, err
+	}
+	subscription.Type = constants.DOC_TYPE_SUBSCRIBE
+	id, p, err := c.request(subscription, false, 16)
+	if err != nil {
+		return nil, nil, err
+	}
+	reply, err := c.wait(id, p)
+	if err == nil && !reply.more {
+		var errDoc model.Document
+		if err = json.Unmarshal(reply.body, &errDoc); err == nil {
+			err = fmt.Errorf("remote error: %v", errDoc.Error)
+		}
+	}
+	if err != nil {
+		c.forget(id, p)
+		return nil, nil, err
+	}
+
+	events := make(chan model.Document)
+	go func() {
+		defer close(events)
+		defer c.forget(id, p)
+		for {
+			var reply streamReply
+			select {
+			case reply = <-p.replies:
+			case <-p.done:
+				return
+			}
+			if reply.err != nil {
+				return
+			}
+			var event model.Document
+			if json.Unmarshal(reply.body, &event) != nil || (!reply.more && event.Type != constants.DOC_TYPE_ERROR) {
+				return
+			}
+			select {
+			case events <- event:
+			case <-p.done:
+				return
+			}
+			if !reply.more {
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			c.forget(id, p)
+			var ack model.Document
+			_ = c.call(model.Document{Type: constants.DOC_TYPE_UNSUBSCRIBE, Procedure: id}, &ack)
+		})
+	}
+	return events, cancel, nil
+}
+
+// SendBatch writes the documents as a single line JSON array and reads the array of results.
+// Every document is executed independently; per-item errors are reported in the returned documents.
+func (c *StreamClient) SendBatch(docs []model.Document) ([]model.Document, error) {
+	c.touch()
+	var raw json.RawMessage
+	err := c.withReconnect(c.idempotent(docs...), func() error {
+		raw = nil
+		return c.roundTrip(docs, &raw)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(raw, []byte("[")) {
+		// the whole batch was rejected, e.g. because it exceeded the size limit
+		var errDoc model.Document
+		if err := json.Unmarshal(raw, &errDoc); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("remote error: %v", errDoc.Error)
+	}
+	var out []model.Document
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EnableCompression switches the connection to raw deflate in both directions.
+// The server must have compression enabled; otherwise the remote error is returned
+// and the connection stays uncompressed. Call it before EnableMultiplexing.
+func (c *StreamClient) EnableCompression() error {
+	if c.Multiplexed() {
+		return errors.New("compression must be enabled before multiplexing")
+	}
+	var ack model.Document
+	if err := c.roundTrip(model.Document{Type: constants.DOC_TYPE_COMPRESSION, Procedure: encodingDeflate}, &ack); err != nil {
+		return err
+	}
+	if ack.Type != constants.DOC_TYPE_COMPRESSION {
+		return fmt.Errorf("remote error: %v", ack.Error)
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.conn == nil {
+		return errClientClosed
+	}
+	return c.startDeflateLocked()
+}
+
+// startDeflateLocked switches to deflate after the server acknowledged it; c.mu is held.
+func (c *StreamClient) startDeflateLocked() error {
+	fw, err := flate.NewWriter(c.conn, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	c.deflate = fw
+	c.compressed = true
+	// the acknowledgement was the last plain line, anything buffered after it is compressed
+	c.reader = bufio.NewReader(flate.NewReader(c.reader))
+	return nil
+}
 
+// EnableMultiplexing switches the connection to stream protocol version 2: calls no longer
+// wait for each other, the server executes them concurrently and answers in completion order.
+// A server without version 2 answers with an error and ErrMultiplexingUnsupported is returned;
+// the connection then keeps working with sequential calls.
+func (c *StreamClient) EnableMultiplexing() error {
+	if c.Multiplexed() {
+		return nil
+	}
+	var ack model.Document
+	if err := c.roundTrip(model.Document{Type: constants.DOC_TYPE_PROTOCOL, Procedure: model.StreamProtocolVersion}, &ack); err != nil {
+		return err
+	}
+	if ack.Type != constants.DOC_TYPE_PROTOCOL {
+		return fmt.Errorf("%w: %v", ErrMultiplexingUnsupported, ack.Error)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.conn == nil {
-		return model.Document{}, errors.New("client is closed")
+		return errClientClosed
+	}
+	c.startMultiplexingLocked()
+	return nil
+}
+
+// startMultiplexingLocked switches to version 2 after the server acknowledged it; c.mu is held.
+func (c *StreamClient) startMultiplexingLocked() {
+	// per-call timeouts are timers from now on, the reader waits for responses indefinitely
+	_ = c.conn.SetDeadline(time.Time{})
+	c.pendingMu.Lock()
+	c.pending = map[uint64]*pendingCall{}
+	c.pendingMu.Unlock()
+	c.multiplexed = true
+	c.wantMultiplex = true
+	go c.readFrames(c.reader, c.gen.Load())
+}
+
+// Ping checks that the connection works and the server answers, with a Ping control document
+// that runs no transaction. ReadWriteTimeout bounds the wait. An older server answers with an
+// error document, which proves the connection works as well.
+func (c *StreamClient) Ping() error {
+	var pong model.Document
+	if err := c.roundTrip(model.Document{Type: constants.DOC_TYPE_PING}, &pong); err != nil {
+		return err
+	}
+	c.lastPing.Store(time.Now().UnixNano())
+	return nil
+}
+
+func (c *StreamClient) touch() {
+	c.lastCall.Store(time.Now().UnixNano())
+}
+
+// idleSince returns the time of the last call, or of the dial when there was none.
+func (c *StreamClient) idleSince() time.Time {
+	return time.Unix(0, c.lastCall.Load())
+}
+
+// checkedSince returns when the connection was last known to work: the last call or ping.
+func (c *StreamClient) checkedSince() time.Time {
+	return time.Unix(0, max(c.lastCall.Load(), c.lastPing.Load()))
+}
+
+// Multiplexed reports whether the connection uses stream protocol version 2.
+func (c *StreamClient) Multiplexed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.multiplexed
+}
+
+// Pending returns the number of calls in progress on the connection.
+func (c *StreamClient) Pending() int {
+	return int(c.inflight.Load())
+}
+
+// Broken reports whether the connection was closed or failed; a broken client must be replaced.
+func (c *StreamClient) Broken() bool {
+	c.mu.Lock()
+	closed := c.conn == nil
+	c.mu.Unlock()
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	return closed || c.readErr != nil
+}
+
+// readFrames delivers the responses of a multiplexed connection to the waiting calls.
+// gen is the connection the reader belongs to; once it has been replaced, its end changes nothing.
+func (c *StreamClient) readFrames(reader *bufio.Reader, gen uint64) {
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			c.failPending(err, gen)
+			c.noticeBroken()
+			return
+		}
+		var frame model.StreamFrame
+		if json.Unmarshal(line, &frame) != nil {
+			continue
+		}
+		if frame.ID == 0 && isGoingAway(frame.Body) && c.gen.Load() == gen {
+			c.broken(ErrServerGoingAway)
+			c.noticeBroken()
+			continue
+		}
+		c.pendingMu.Lock()
+		p := c.pending[frame.ID]
+		if !frame.More {
+			delete(c.pending, frame.ID)
+		}
+		c.pendingMu.Unlock()
+		if p != nil {
+			// a streaming caller that reads slowly holds up the whole connection
+			p.deliver(streamReply{body: frame.Body, more: frame.More})
+		}
+	}
+}
+
+// failPending ends every waiting call with err and makes later calls fail with it, or with
+// ErrServerGoingAway when the server announced its shutdown.
+func (c *StreamClient) failPending(err error, gen uint64) {
+	c.pendingMu.Lock()
+	if c.gen.Load() != gen {
+		// the connection was replaced, which failed its calls
+		c.pendingMu.Unlock()
+		return
+	}
+	if c.readErr != ErrServerGoingAway {
+		c.readErr = err
+	}
+	pending := c.pending
+	c.pending = map[uint64]*pendingCall{}
+	c.pendingMu.Unlock()
+	for _, p := range pending {
+		p.deliver(streamReply{err: err})
+	}
+}
+
+// request registers a call, sends payload in a frame with its ID and returns the call.
+func (c *StreamClient) request(payload interface{}, stream bool, buffer int) (uint64, *pendingCall, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	p := &pendingCall{replies: make(chan streamReply, buffer), done: make(chan struct{})}
+	c.pendingMu.Lock()
+	if c.readErr != nil || c.pending == nil {
+		err := c.readErr
+		c.pendingMu.Unlock()
+		if err == nil {
+			// replaced by a connection that is not multiplexed yet
+			err = net.ErrClosed
+		}
+		return 0, nil, errNotSent{err}
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = p
+	c.pendingMu.Unlock()
+
+	if err := c.writeLine(model.StreamFrame{ID: id, Stream: stream, Body: b}); err != nil {
+		c.forget(id, p)
+		return 0, nil, err
+	}
+	return id, p, nil
+}
+
+// forget stops waiting for the responses of a call; frames that still arrive are dropped.
+func (c *StreamClient) forget(id uint64, p *pendingCall) {
+	c.pendingMu.Lock()
+	delete(c.pending, id)
+	c.pendingMu.Unlock()
+	p.once.Do(func() { close(p.done) })
+}
+
+// wait returns the next response frame of a call. ReadWriteTimeout limits the wait for each frame.
+func (c *StreamClient) wait(id uint64, p *pendingCall) (streamReply, error) {
+	var timeout <-chan time.Time
+	if c.ReadWriteTimeout > 0 {
+		timer := time.NewTimer(c.ReadWriteTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case reply := <-p.replies:
+		if reply.err == nil && len(reply.body) == 0 {
+			reply.err = errors.New("empty response")
+		}
+		return reply, reply.err
+	case <-timeout:
+		return streamReply{}, fmt.Errorf("stream call %d timed out after %v", id, c.ReadWriteTimeout)
+	}
+}
+
+// call sends payload in a frame and waits for the response with the same ID.
+func (c *StreamClient) call(payload interface{}, out interface{}) error {
+	id, p, err := c.request(payload, false, 1)
+	if err != nil {
+		return err
+	}
+	defer c.forget(id, p)
+	reply, err := c.wait(id, p)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(reply.body, out)
+}
+
+// writeLine writes one JSON line on a multiplexed connection.
+func (c *StreamClient) writeLine(payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return errNotSent{errClientClosed}
+	}
+	if c.ReadWriteTimeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.ReadWriteTimeout))
+	}
+	if c.deflate != nil {
+		if _, err := c.deflate.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		return c.deflate.Flush()
+	}
+	if n, err := c.conn.Write(append(b, '\n')); err != nil {
+		err = c.broken(err)
+		if n == 0 {
+			return errNotSent{err}
+		}
+		return err
+	}
+	return nil
+}
+
+// roundTrip writes payload as one JSON line and decodes the next response line into out.
+func (c *StreamClient) roundTrip(payload interface{}, out interface{}) error {
+	c.inflight.Add(1)
+	defer c.inflight.Add(-1)
+	c.mu.Lock()
+	if c.multiplexed {
+		c.mu.Unlock()
+		return c.call(payload, out)
+	}
+	err := c.exchangeLocked(payload, out)
+	c.mu.Unlock()
+	if err != nil {
+		c.noticeBroken()
+	}
+	return err
+}
+
+// exchangeLocked writes payload as one JSON line and decodes the next response line into
+// out; c.mu is held.
+func (c *StreamClient) exchangeLocked(payload interface{}, out interface{}) error {
+	if c.conn == nil {
+		return errNotSent{errClientClosed}
 	}
 
 	// Apply a per-call deadline if configured
@@ -94,47 +630,82 @@ func (c *StreamClient) Send(doc model.Document) (model.Document, error) {
 	}
 
 	// Marshal and write followed by a newline
-	b, err := json.Marshal(doc)
+	b, err := json.Marshal(payload)
 	if err != nil {
-		return model.Document{}, err
+		return err
 	}
-	if _, err := c.conn.Write(append(b, '\n')); err != nil {
-		return model.Document{}, err
+	if c.deflate != nil {
+		if _, err := c.deflate.Write(append(b, '\n')); err != nil {
+			return c.broken(err)
+		}
+		if err := c.deflate.Flush(); err != nil {
+			return c.broken(err)
+		}
+	} else if n, err := c.conn.Write(append(b, '\n')); err != nil {
+		err = c.broken(err)
+		if n == 0 {
+			return errNotSent{err}
+		}
+		return err
 	}
 
 	// Read one line response
 	line, err := c.reader.ReadString('\n')
 	if err != nil {
-		return model.Document{}, err
+		return c.broken(err)
 	}
 	line = strings.TrimSpace(line)
 	if line == "" {
-		return model.Document{}, errors.New("empty response")
+		return errors.New("empty response")
 	}
-	var out model.Document
-	if err := json.Unmarshal([]byte(line), &out); err != nil {
-		return model.Document{}, err
+	if isGoingAway([]byte(line)) {
+		// the server read no further requests, so this one did not run
+		return errNotSent{c.broken(ErrServerGoingAway)}
 	}
-	if strings.EqualFold(out.Type, "Error") && out.Error != nil {
-		return out, fmt.Errorf("remote error: %v", out.Error)
+	return json.Unmarshal([]byte(line), out)
+}
+
+// isGoingAway reports whether a response line is the notice of a server shutting down.
+func isGoingAway(line []byte) bool {
+	if !bytes.Contains(line, []byte(constants.DOC_TYPE_GOING_AWAY)) {
+		return false
 	}
-	return out, nil
+	var document model.Document
+	return json.Unmarshal(line, &document) == nil && document.Type == constants.DOC_TYPE_GOING_AWAY
+}
+
+// broken records that a write or read failed, which leaves the connection out of step.
+func (c *StreamClient) broken(err error) error {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	c.readErr = err
+	return err
 }
 
 // Close closes the underlying TCP connection.
 func (c *StreamClient) Close() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.conn != nil {
-		err := c.conn.Close()
-		c.conn = nil
-		return err
+	if c.conn == nil {
+		c.mu.Unlock()
+		return nil
 	}
-	return nil
+	if c.deflate != nil {
+		_ = c.deflate.Close()
+		c.deflate = nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	if c.done != nil {
+		close(c.done)
+	}
+	c.mu.Unlock()
+	c.setState(model.StreamClientClosed, nil)
+	return err
 }
 
 // Call is a convenience for a one-shot request using a derived stream port from an HTTP port.
-// It dials, sends the document, reads the response, and closes the connection.
+// It dials, sends the document, reads the response, and closes the connection. Callers that
+// send often keep their connections in a StreamClientPool or a Balancer instead.
 func Call(host, httpPort string, doc model.Document) (model.Document, error) {
 	cli, err := NewStreamClientFromHTTPPort(host, httpPort, 5*time.Second)
 	if err != nil {
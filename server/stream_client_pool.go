@@ -1,8 +1,11 @@
 package server
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/godispatcher/dispatcher/model"
@@ -11,7 +14,8 @@ import (
 // StreamClientPool provides a simple connection pool for the Stream API client.
 // It manages up to `size` persistent StreamClient connections that can be used
 // concurrently by multiple goroutines. Each underlying StreamClient remains
-// single-inflight; concurrency is achieved by using different pooled clients.
+// single-inflight; concurrency is achieved by using different pooled clients,
+// or by sharing multiplexed connections when Multiplex is set.
 //
 // Typical usage:
 //   pool, _ := NewStreamClientPoolFromHTTPPort("127.0.0.1", "9000", 4, 5*time.Second)
@@ -23,14 +27,15 @@ import (
 // Timeouts: DialTimeout is used for creating connections. You can adjust per-call
 // read/write timeouts by setting Pool.ReadWriteTimeout which is applied to
 // borrowed clients for the duration of Send; you can also adjust it manually on
-// acquired clients before Release.
+// acquired clients before Release. AcquireTimeout bounds the wait for a connection
+// when all of them are in use.
 //
 // The pool is lazy: connections are created on demand up to the pool size.
 // If a connection errors during use, it is closed and replaced on the next Acquire.
 // Close() closes all currently pooled connections and prevents further use.
 //
-// Note: Pool focuses on minimalism and avoids background goroutines.
-// If you need smarter health checks or ping, extend as needed.
+// MinIdle, MaxIdleTime, MaxLifetime and HealthCheckInterval are applied by a background
+// goroutine that starts with the first Acquire or Send and ends with Close. Set them before.
 
 type StreamClientPool struct {
 	host             string
@@ -38,15 +43,55 @@ type StreamClientPool struct {
 	size             int
 	dialTimeout      time.Duration
 	ReadWriteTimeout time.Duration
+	// AcquireTimeout bounds the wait of Acquire and Send for a connection while all of them
+	// are in use; defaults to 15 seconds. Zero waits until one is released.
+	AcquireTimeout time.Duration
+	// Compression switches every new connection to deflate mode (see StreamClient.EnableCompression).
+	Compression bool
+	// Multiplex switches every new connection to stream protocol version 2 (see
+	// StreamClient.EnableMultiplexing) and shares connections between callers instead of
+	// lending them out: a call uses the least busy connection, and another one is dialed,
+	// up to the pool size, only while all of them are busy.
+	Multiplex bool
 
-	mu    sync.Mutex
-	conns chan *StreamClient
+	// MinIdle connections are kept open, dialed in the background, so that bursts do not
+	// wait for dials. With Multiplex it is the number of shared connections kept open.
+	MinIdle int
+	// MaxIdleTime closes connections that have not been used for this long, down to MinIdle.
+	MaxIdleTime time.Duration
+	// MaxLifetime closes connections once they are this old and not in use, e.g. to spread
+	// the load over server instances that started later.
+	MaxLifetime time.Duration
+	// HealthCheckInterval pings connections that have not been used for this long (see
+	// StreamClient.Ping) and closes those that do not answer, before a caller gets them.
+	HealthCheckInterval time.Duration
+
+	mu     sync.Mutex
+	idle   []*StreamClient // most recently released last
+	shared []*StreamClient
 	// created tracks how many clients have been created so far; never exceeds size
-	created int
-	closed  bool
+	created  int
+	inUse    int
+	dialing  int // shared connections being dialed by sharedClient
+	closed   bool
+	changed  chan struct{} // closed when a connection is released or dropped
+	maintain sync.Once
+	stop     chan struct{}
+	stats    struct {
+		dials, dialFailures, waits, waitTime, timeouts atomic.Int64
+		idleClosed, lifetimeClosed, healthFailures     atomic.Int64
+	}
 }
 
+// ErrPoolClosed is returned by the calls of a pool after Close.
+var ErrPoolClosed = errors.New("pool is closed")
+
+// ErrPoolTimeout is returned when no connection became available within AcquireTimeout or
+// before the context of AcquireContext ended.
+var ErrPoolTimeout = errors.New("timed out waiting for a pooled connection")
+
 // NewStreamClientPool creates a pool for the given host:port with the specified size.
+// host may be a unix socket such as "unix:///run/orders.sock" (see NewStreamClient).
 // Size must be > 0.
 func NewStreamClientPool(host, port string, size int, dialTimeout time.Duration) (*StreamClientPool, error) {
 	if size <= 0 {
@@ -59,7 +104,8 @@ func NewStreamClientPool(host, port string, size int, dialTimeout time.Duration)
 		dialTimeout: dialTimeout,
 		// reasonable default per-call timeout
 		ReadWriteTimeout: 15 * time.Second,
-		conns:            make(chan *StreamClient, size),
+		AcquireTimeout:   15 * time.Second,
+		stop:             make(chan struct{}),
 	}
 	return p, nil
 }
@@ -69,61 +115,256 @@ func NewStreamClientPoolFromHTTPPort(host, httpPort string, size int, dialTimeou
 	return NewStreamClientPool(host, deriveStreamPortLocal(httpPort), size, dialTimeout)
 }
 
-// Acquire returns a StreamClient from the pool, creating one if necessary.
-// The caller must Release the client when done.
+// Acquire returns a StreamClient from the pool, creating one if necessary, and waits up to
+// AcquireTimeout while all of them are in use.
+// The caller must Release the client when done. With Multiplex the client is shared.
 func (p *StreamClientPool) Acquire() (*StreamClient, error) {
-	p.mu.Lock()
-	if p.closed {
-		p.mu.Unlock()
-		return nil, errors.New("pool is closed")
+	ctx := context.Background()
+	if p.AcquireTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.AcquireTimeout)
+		defer cancel()
 	}
-	// Try to take an existing connection without holding the lock too long.
-	select {
-	case c := <-p.conns:
-		p.mu.Unlock()
-		return c, nil
-	default:
-		// none available; create if we can
-		if p.created >= p.size {
-			// wait for an existing one to become available
+	return p.AcquireContext(ctx)
+}
+
+// AcquireContext is Acquire waiting until ctx ends instead of AcquireTimeout. It fails with
+// ErrPoolTimeout, wrapping the context's error, when no connection became available.
+func (p *StreamClientPool) AcquireContext(ctx context.Context) (*StreamClient, error) {
+	p.startMaintenance()
+	if p.Multiplex {
+		return p.sharedClient(ctx)
+	}
+	var waitStart time.Time
+	defer func() {
+		if !waitStart.IsZero() {
+			p.stats.waitTime.Add(int64(time.Since(waitStart)))
+		}
+	}()
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if n := len(p.idle); n > 0 {
+			c := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			if c.Broken() {
+				p.dropLocked(c)
+				p.mu.Unlock()
+				continue
+			}
+			if p.expired(c) {
+				p.stats.lifetimeClosed.Add(1)
+				p.dropLocked(c)
+				p.mu.Unlock()
+				continue
+			}
+			p.inUse++
 			p.mu.Unlock()
-			c := <-p.conns
 			return c, nil
 		}
-		p.created++
-		host := p.host
-		port := p.port
-		dialTimeout := p.dialTimeout
+		if p.created < p.size {
+			p.created++
+			p.inUse++
+			p.mu.Unlock()
+			cli, err := p.dial()
+			if err != nil {
+				p.mu.Lock()
+				// rollback creation count on failure
+				p.created--
+				p.inUse--
+				p.notifyLocked()
+				p.mu.Unlock()
+				return nil, err
+			}
+			return cli, nil
+		}
+		if waitStart.IsZero() {
+			waitStart = time.Now()
+			p.stats.waits.Add(1)
+		}
+		changed := p.changedLocked()
 		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			p.stats.timeouts.Add(1)
+			return nil, fmt.Errorf("%w: %w", ErrPoolTimeout, ctx.Err())
+		}
+	}
+}
 
-		cli, err := NewStreamClient(host, port, dialTimeout)
-		if err != nil {
-			p.mu.Lock()
-			p.created-- // rollback creation count on failure
-			p.mu.Unlock()
+// changedLocked returns the channel that is closed on the next release or drop.
+func (p *StreamClientPool) changedLocked() chan struct{} {
+	if p.changed == nil {
+		p.changed = make(chan struct{})
+	}
+	return p.changed
+}
+
+// notifyLocked wakes up the callers waiting for a connection.
+func (p *StreamClientPool) notifyLocked() {
+	if p.changed != nil {
+		close(p.changed)
+		p.changed = nil
+	}
+}
+
+// dropLocked closes a connection of the pool and frees its slot.
+func (p *StreamClientPool) dropLocked(c *StreamClient) {
+	_ = c.Close()
+	if p.created > 0 {
+		p.created--
+	}
+	p.notifyLocked()
+}
+
+func (p *StreamClientPool) expired(c *StreamClient) bool {
+	return p.MaxLifetime > 0 && time.Since(c.dialed) >= p.MaxLifetime
+}
+
+// dial connects a new client with the pool's timeout, compression and protocol settings.
+func (p *StreamClientPool) dial() (*StreamClient, error) {
+	p.stats.dials.Add(1)
+	cli, err := p.connect()
+	if err != nil {
+		p.stats.dialFailures.Add(1)
+	}
+	return cli, err
+}
+
+func (p *StreamClientPool) connect() (*StreamClient, error) {
+	cli, err := NewStreamClient(p.host, p.port, p.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// inherit the pool's per-call timeout as default
+	cli.ReadWriteTimeout = p.ReadWriteTimeout
+	if p.Compression {
+		if err := cli.EnableCompression(); err != nil {
+			_ = cli.Close()
+			return nil, err
+		}
+	}
+	if p.Multiplex {
+		// an older server keeps the connection sequential, which is still safe to share
+		if err := cli.EnableMultiplexing(); err != nil && !errors.Is(err, ErrMultiplexingUnsupported) {
+			_ = cli.Close()
 			return nil, err
 		}
-		// inherit the pool's per-call timeout as default
-		cli.ReadWriteTimeout = p.ReadWriteTimeout
-		return cli, nil
 	}
+	return cli, nil
 }
 
-// Release returns a StreamClient back to the pool.
-// If the provided error is non-nil, the connection is closed and discarded.
-// If the pool is closed or already full, the connection is closed.
-func (p *StreamClientPool) Release(c *StreamClient, err error) {
-	if c == nil {
-		return
+// sharedClient returns the least busy shared connection, dialing a new one when there is
+// none or all are busy and the pool is not full. Broken connections are dropped. Only one
+// connection is dialed at a time; callers without a connection wait for it until ctx ends.
+func (p *StreamClientPool) sharedClient(ctx context.Context) (*StreamClient, error) {
+	var waitStart time.Time
+	defer func() {
+		if !waitStart.IsZero() {
+			p.stats.waitTime.Add(int64(time.Since(waitStart)))
+		}
+	}()
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		var best *StreamClient
+		live := p.shared[:0]
+		for _, c := range p.shared {
+			if c.Broken() {
+				p.dropLocked(c)
+				continue
+			}
+			live = append(live, c)
+			if best == nil || c.Pending() < best.Pending() {
+				best = c
+			}
+		}
+		p.shared = live
+		full := p.dialing > 0 || p.created >= p.size
+		if best != nil && (best.Pending() == 0 || full) {
+			p.mu.Unlock()
+			return best, nil
+		}
+		if best == nil && full {
+			// another caller, or fillMinIdle, is dialing the connection
+			if waitStart.IsZero() {
+				waitStart = time.Now()
+				p.stats.waits.Add(1)
+			}
+			changed := p.changedLocked()
+			p.mu.Unlock()
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				p.stats.timeouts.Add(1)
+				return nil, fmt.Errorf("%w: %w", ErrPoolTimeout, ctx.Err())
+			}
+		}
+		p.created++
+		p.dialing++
+		p.mu.Unlock()
+		cli, err := p.dialShared(ctx)
+		switch {
+		case err == nil:
+			return cli, nil
+		case best != nil:
+			// the busy connection still works
+			return best, nil
+		case errors.Is(err, ErrPoolTimeout):
+			p.stats.timeouts.Add(1)
+		}
+		return nil, err
 	}
-	// If an error occurred during use, drop the connection.
-	if err != nil {
-		_ = c.Close()
+}
+
+// dialShared dials a shared connection without holding p.mu. The dial keeps its slot when
+// ctx ends first, and a connection that is established later is still shared.
+func (p *StreamClientPool) dialShared(ctx context.Context) (*StreamClient, error) {
+	type result struct {
+		cli *StreamClient
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		cli, err := p.dial()
 		p.mu.Lock()
-		if p.created > 0 {
+		p.dialing--
+		switch {
+		case err != nil:
 			p.created--
+		case p.closed:
+			p.dropLocked(cli)
+			cli, err = nil, ErrPoolClosed
+		default:
+			p.shared = append(p.shared, cli)
 		}
+		p.notifyLocked()
 		p.mu.Unlock()
+		done <- result{cli, err}
+	}()
+	select {
+	case r := <-done:
+		return r.cli, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", ErrPoolTimeout, ctx.Err())
+	}
+}
+
+// Release returns a StreamClient back to the pool.
+// If the provided error is non-nil, the connection is closed and discarded, as are
+// connections older than MaxLifetime.
+// If the pool is closed or already full, the connection is closed.
+func (p *StreamClientPool) Release(c *StreamClient, err error) {
+	if c == nil || p.Multiplex {
+		// shared connections stay in use; broken ones are dropped by the next call
 		return
 	}
 	// Reset per-call timeout to pool default in case caller changed it.
@@ -131,22 +372,18 @@ func (p *StreamClientPool) Release(c *StreamClient, err error) {
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.closed {
-		_ = c.Close()
-		if p.created > 0 {
-			p.created--
-		}
-		return
+	if p.inUse > 0 {
+		p.inUse--
 	}
-	select {
-	case p.conns <- c:
-		// returned to pool
+	switch {
+	case err != nil, p.closed, c.Broken(), len(p.idle) >= p.size:
+		p.dropLocked(c)
+	case p.expired(c):
+		p.stats.lifetimeClosed.Add(1)
+		p.dropLocked(c)
 	default:
-		// pool already full; close extra
-		_ = c.Close()
-		if p.created > 0 {
-			p.created--
-		}
+		p.idle = append(p.idle, c)
+		p.notifyLocked()
 	}
 }
 
@@ -162,23 +399,218 @@ func (p *StreamClientPool) Send(doc model.Document) (model.Document, error) {
 	return resp, sendErr
 }
 
+// Stats returns the connections and counters of the pool.
+func (p *StreamClientPool) Stats() model.StreamPoolStats {
+	p.mu.Lock()
+	stats := model.StreamPoolStats{Size: p.size, Open: p.created, InUse: p.inUse, Idle: len(p.idle)}
+	if p.Multiplex {
+		for _, c := range p.shared {
+			if c.Pending() > 0 {
+				stats.InUse++
+			} else {
+				stats.Idle++
+			}
+		}
+	}
+	p.mu.Unlock()
+	stats.Dials = p.stats.dials.Load()
+	stats.DialFailures = p.stats.dialFailures.Load()
+	stats.Waits = p.stats.waits.Load()
+	stats.WaitDuration = time.Duration(p.stats.waitTime.Load())
+	stats.Timeouts = p.stats.timeouts.Load()
+	stats.IdleClosed = p.stats.idleClosed.Load()
+	stats.LifetimeClosed = p.stats.lifetimeClosed.Load()
+	stats.HealthCheckFailures = p.stats.healthFailures.Load()
+	return stats
+}
+
+// startMaintenance starts the background goroutine when one of MinIdle, MaxIdleTime,
+// MaxLifetime or HealthCheckInterval is set.
+func (p *StreamClientPool) startMaintenance() {
+	p.maintain.Do(func() {
+		var interval time.Duration
+		for _, d := range []time.Duration{p.HealthCheckInterval, p.MaxIdleTime / 2, p.MaxLifetime / 2} {
+			if d > 0 && (interval == 0 || d < interval) {
+				interval = d
+			}
+		}
+		if interval == 0 && p.MinIdle > 0 {
+			interval = time.Second
+		}
+		if interval == 0 {
+			return
+		}
+		interval = max(interval, 10*time.Millisecond)
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				p.maintainConns()
+				select {
+				case <-ticker.C:
+				case <-p.stop:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// maintainConns closes expired and idle connections, pings those due for a health check
+// and dials up to MinIdle.
+func (p *StreamClientPool) maintainConns() {
+	now := time.Now()
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	var check []*StreamClient
+	if p.Multiplex {
+		evictable := len(p.shared) - p.MinIdle
+		live := p.shared[:0]
+		for _, c := range p.shared {
+			switch {
+			case c.Broken():
+				p.dropLocked(c)
+				evictable--
+			case c.Pending() > 0:
+				live = append(live, c)
+			case p.expired(c):
+				p.stats.lifetimeClosed.Add(1)
+				p.dropLocked(c)
+				evictable--
+			case p.MaxIdleTime > 0 && evictable > 0 && now.Sub(c.idleSince()) >= p.MaxIdleTime:
+				p.stats.idleClosed.Add(1)
+				p.dropLocked(c)
+				evictable--
+			default:
+				live = append(live, c)
+				if p.HealthCheckInterval > 0 && now.Sub(c.checkedSince()) >= p.HealthCheckInterval {
+					check = append(check, c)
+				}
+			}
+		}
+		p.shared = live
+	} else {
+		// the least recently released connections come first
+		evictable := len(p.idle) - p.MinIdle
+		keep := p.idle[:0]
+		for _, c := range p.idle {
+			switch {
+			case c.Broken():
+				p.dropLocked(c)
+				evictable--
+			case p.expired(c):
+				p.stats.lifetimeClosed.Add(1)
+				p.dropLocked(c)
+				evictable--
+			case p.MaxIdleTime > 0 && evictable > 0 && now.Sub(c.idleSince()) >= p.MaxIdleTime:
+				p.stats.idleClosed.Add(1)
+				p.dropLocked(c)
+				evictable--
+			case p.HealthCheckInterval > 0 && now.Sub(c.checkedSince()) >= p.HealthCheckInterval:
+				// taken out of the pool while it is checked
+				check = append(check, c)
+			default:
+				keep = append(keep, c)
+			}
+		}
+		p.idle = keep
+	}
+	p.mu.Unlock()
+
+	for _, c := range check {
+		err := c.Ping()
+		p.mu.Lock()
+		switch {
+		case p.Multiplex:
+			// a shared connection may have been dropped meanwhile as broken
+			if err != nil && p.removeShared(c) {
+				p.stats.healthFailures.Add(1)
+				p.dropLocked(c)
+			}
+		case err != nil:
+			p.stats.healthFailures.Add(1)
+			p.dropLocked(c)
+		case p.closed:
+			p.dropLocked(c)
+		default:
+			p.idle = append(p.idle, c)
+			p.notifyLocked()
+		}
+		p.mu.Unlock()
+	}
+	p.fillMinIdle()
+}
+
+// removeShared reports whether c was still a shared connection and removes it.
+func (p *StreamClientPool) removeShared(c *StreamClient) bool {
+	for i, s := range p.shared {
+		if s == c {
+			p.shared = append(p.shared[:i], p.shared[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fillMinIdle dials connections until MinIdle are open, within the pool size.
+func (p *StreamClientPool) fillMinIdle() {
+	for {
+		p.mu.Lock()
+		open := len(p.idle)
+		if p.Multiplex {
+			open = len(p.shared)
+		}
+		if p.closed || open >= p.MinIdle || p.created >= p.size {
+			p.mu.Unlock()
+			return
+		}
+		p.created++
+		p.mu.Unlock()
+		cli, err := p.dial()
+		p.mu.Lock()
+		switch {
+		case err != nil:
+			p.created--
+			p.notifyLocked()
+		case p.closed:
+			p.dropLocked(cli)
+		case p.Multiplex:
+			p.shared = append(p.shared, cli)
+			p.notifyLocked()
+		default:
+			p.idle = append(p.idle, cli)
+			p.notifyLocked()
+		}
+		p.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
 // Close closes the pool and all currently idle connections.
 // Ongoing borrowed connections continue to work but will be discarded on Release.
+// Callers waiting for a connection fail with ErrPoolClosed.
 func (p *StreamClientPool) Close() error {
 	p.mu.Lock()
+	defer p.mu.Unlock()
 	if p.closed {
-		p.mu.Unlock()
 		return nil
 	}
 	p.closed = true
-	close(p.conns)
-	// Drain and close all currently pooled connections.
-	for c := range p.conns {
-		_ = c.Close()
-		if p.created > 0 {
-			p.created--
-		}
+	close(p.stop)
+	// Close all currently pooled connections.
+	for _, c := range p.idle {
+		p.dropLocked(c)
 	}
-	p.mu.Unlock()
+	p.idle = nil
+	for _, c := range p.shared {
+		p.dropLocked(c)
+	}
+	p.shared = nil
+	p.notifyLocked()
 	return nil
 }
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// streamCounter is a counter of model.StreamStats.
+type streamCounter int
+
+const (
+	counterActive streamCounter = iota
+	counterAccepted
+	counterRejected
+	counterHandshakeTimeouts
+	counterIdleTimeouts
+	counterReadTimeouts
+	counterOversizedFrames
+	counterAcceptErrors
+	streamCounterCount
+)
+
+// streamCounters holds the counters of a StreamServer; streamStats those of the process.
+type streamCounters [streamCounterCount]atomic.Int64
+
+var streamStats streamCounters
+
+// add adds delta to a counter of c, which may be nil, and to the same counter of the process.
+func (c *streamCounters) add(counter streamCounter, delta int64) {
+	if c != nil && c != &streamStats {
+		c[counter].Add(delta)
+	}
+	streamStats[counter].Add(delta)
+}
+
+func (c *streamCounters) snapshot() model.StreamStats {
+	return model.StreamStats{
+		Active:            c[counterActive].Load(),
+		Accepted:          c[counterAccepted].Load(),
+		Rejected:          c[counterRejected].Load(),
+		HandshakeTimeouts: c[counterHandshakeTimeouts].Load(),
+		IdleTimeouts:      c[counterIdleTimeouts].Load(),
+		ReadTimeouts:      c[counterReadTimeouts].Load(),
+		OversizedFrames:   c[counterOversizedFrames].Load(),
+		AcceptErrors:      c[counterAcceptErrors].Load(),
+	}
+}
+
+// StreamStats returns the counters of all stream API connections of the process. Use
+// StreamServer.Stats for the counters and options of one server.
+func StreamStats() model.StreamStats {
+	return streamStats.snapshot()
+}
+
+// serveStreamListener accepts stream connections until ln is closed; conns tracks them and
+// stats counts them. A failing Accept is retried after a pause that doubles up to a second,
+// so persistent errors such as running out of file descriptors do not spin.
+func serveStreamListener(ln net.Listener, register *department.RegisterDispatcher, conns *connTracker, stats *streamCounters) error {
+	opts := streamOptions(register)
+	limiter := &connectionLimiter{max: opts.MaxConnections, perClient: opts.MaxConnectionsPerIP}
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			stats.add(counterAcceptErrors, 1)
+			if delay = 2 * delay; delay == 0 {
+				delay = opts.AcceptBackoff
+			}
+			delay = min(delay, time.Second)
+			log.Printf("stream api accept error: %v; retrying in %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		client := limitKey(conn)
+		if err := limiter.acquire(client); err != nil {
+			stats.add(counterRejected, 1)
+			go rejectStreamConn(conn, err)
+			continue
+		}
+		stats.add(counterAccepted, 1)
+		go func() {
+			defer limiter.release(client)
+			serveStreamConn(conn, register, conns, stats)
+		}()
+	}
+}
+
+func streamOptions(register *department.RegisterDispatcher) *model.StreamOptions {
+	if register == nil {
+		return (*model.StreamOptions)(nil).WithDefaults()
+	}
+	return register.Stream.WithDefaults()
+}
+
+// rejectStreamConn tells a refused client why before closing the connection.
+func rejectStreamConn(conn net.Conn, err error) {
+	defer conn.Close()
+	_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = conn.Write(append(streamErrorLine(err), '\n'))
+}
+
+// limitKey returns the client MaxConnectionsPerIP counts conn for: the remote IP, or the user
+// ID of a unix socket peer. Unix socket peers without credentials are not limited per client.
+func limitKey(conn net.Conn) string {
+	if _, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		if peer := peerCredentials(conn); peer != nil {
+			return "uid " + strconv.FormatUint(uint64(peer.UID), 10)
+		}
+		return ""
+	}
+	return remoteIP(conn.RemoteAddr())
+}
+
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// connectionLimiter counts the open connections, in total and per client (see limitKey).
+type connectionLimiter struct {
+	max, perClient int
+	mu             sync.Mutex
+	total          int
+	byClient       map[string]int
+}
+
+func (l *connectionLimiter) acquire(client string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.total >= l.max {
+		return errors.New("too many connections")
+	}
+	if client == "" {
+		l.total++
+		return nil
+	}
+	if l.perClient > 0 && l.byClient[client] >= l.perClient {
+		return fmt.Errorf("too many connections from %s", client)
+	}
+	if l.byClient == nil {
+		l.byClient = map[string]int{}
+	}
+	l.total++
+	l.byClient[client]++
+	return nil
+}
+
+func (l *connectionLimiter) release(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if client == "" {
+		return
+	}
+	if l.byClient[client]--; l.byClient[client] <= 0 {
+		delete(l.byClient, client)
+	}
+}
+
+// errFrameTooLarge reports a line longer than StreamOptions.MaxFrameSize. The rest of the
+// line has been skipped, so the connection can go on.
+type errFrameTooLarge struct {
+	id    uint64 // ID of the version 2 frame, when its start could be read
+	limit int
+}
+
+func (e errFrameTooLarge) Error() string {
+	return fmt.Sprintf("frame exceeds the maximum size of %d bytes", e.limit)
+}
+
+// frameIDPrefix reads the ID of a frame written with its id first, as StreamClient does.
+var frameIDPrefix = regexp.MustCompile(`^\s*\{\s*"id"\s*:\s*(\d+)`)
+
+// streamLineReader reads the lines of a stream connection and applies the timeouts and the
+// frame size limit of StreamOptions. It counts the running requests and subscriptions of
+// the connection, as the idle timeout only applies while there are none.
+type streamLineReader struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	options *model.StreamOptions
+	stats   *streamCounters
+
+	mu       sync.Mutex
+	started  bool // the first line has arrived
+	waiting  bool // waiting for a line to start
+	draining bool
+	busy     int
+}
+
+func newStreamLineReader(conn net.Conn, src io.Reader, options *model.StreamOptions, stats *streamCounters) *streamLineReader {
+	return &streamLineReader{conn: conn, reader: bufio.NewReaderSize(src, 64*1024), options: options, stats: stats}
+}
+
+// next returns the next non-empty line without surrounding spaces. An idle timeout ends the
+// connection like io.EOF; an oversized line is reported with errFrameTooLarge and a drained
+// connection with errGoingAway.
+func (r *streamLineReader) next() ([]byte, error) {
+	for {
+		r.mu.Lock()
+		if r.draining {
+			r.mu.Unlock()
+			return nil, errGoingAway
+		}
+		handshake := !r.started
+		r.waiting = true
+		if handshake {
+			r.setReadDeadline(r.options.HandshakeTimeout)
+		} else if r.busy == 0 {
+			r.setReadDeadline(r.options.IdleTimeout)
+		} else {
+			r.setReadDeadline(0)
+		}
+		r.mu.Unlock()
+		_, err := r.reader.Peek(1)
+		r.mu.Lock()
+		r.waiting = false
+		draining := r.draining
+		r.mu.Unlock()
+		if err != nil && draining {
+			return nil, errGoingAway
+		}
+		if err != nil {
+			if isTimeout(err) {
+				if handshake {
+					r.stats.add(counterHandshakeTimeouts, 1)
+					return nil, errors.New("stream handshake timeout")
+				}
+				r.stats.add(counterIdleTimeouts, 1)
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		r.mu.Lock()
+		r.started = true
+		r.setReadDeadline(r.options.ReadTimeout)
+		r.mu.Unlock()
+		line, err := r.readLine()
+		if err != nil {
+			if isTimeout(err) {
+				r.stats.add(counterReadTimeouts, 1)
+				return nil, errors.New("stream read timeout")
+			}
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+func (r *streamLineReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > r.options.MaxFrameSize {
+			return nil, r.skipLine(append(line, chunk...), err)
+		}
+		line = append(line, chunk...)
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(line) > 0:
+			return line, nil
+		case err != nil:
+			return nil, err
+		}
+		return line, nil
+	}
+}
+
+// skipLine drops the rest of an oversized line whose start is head.
+func (r *streamLineReader) skipLine(head []byte, err error) error {
+	for err == bufio.ErrBufferFull {
+		_, err = r.reader.ReadSlice('\n')
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	r.stats.add(counterOversizedFrames, 1)
+	tooLarge := errFrameTooLarge{limit: r.options.MaxFrameSize}
+	if match := frameIDPrefix.FindSubmatch(head); match != nil {
+		tooLarge.id, _ = strconv.ParseUint(string(match[1]), 10, 64)
+	}
+	return tooLarge
+}
+
+func (r *streamLineReader) setReadDeadline(timeout time.Duration) {
+	if r.conn == nil {
+		return
+	}
+	if timeout <= 0 {
+		_ = r.conn.SetReadDeadline(time.Time{})
+		return
+	}
+	_ = r.conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// begin and end mark a running request or subscription of the connection.
+func (r *streamLineReader) begin() {
+	r.mu.Lock()
+	r.busy++
+	r.mu.Unlock()
+}
+
+func (r *streamLineReader) end() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// the reader may be waiting without a deadline since the connection was busy
+	if r.busy--; r.busy == 0 && r.waiting && r.started && !r.draining {
+		r.setReadDeadline(r.options.IdleTimeout)
+	}
+}
+
+// drain stops reading requests: a line that has started is still read, then next reports errGoingAway.
+func (r *streamLineReader) drain() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.draining = true
+	if r.waiting && r.conn != nil {
+		_ = r.conn.SetReadDeadline(time.Now())
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+func streamListener(t *testing.T, register *department.RegisterDispatcher) (net.Listener, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go (&StreamServer{Register: register}).Serve(ln)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return ln, port
+}
+
+func readStreamDocument(t *testing.T, reader *bufio.Reader) model.Document {
+	t.Helper()
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("no response: %v", err)
+	}
+	var document model.Document
+	if err := json.Unmarshal(line, &document); err != nil {
+		t.Fatal(err)
+	}
+	return document
+}
+
+func TestStreamConnectionLimits(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	srv := &StreamServer{Register: &department.RegisterDispatcher{Stream: &model.StreamOptions{MaxConnectionsPerIP: 1}}}
+	go srv.Serve(ln)
+	// another server of the process does not share the counters and options
+	_, otherPort := streamListener(t, &department.RegisterDispatcher{Stream: &model.StreamOptions{MaxConnections: 10}})
+	rejected := StreamStats().Rejected
+
+	first, err := NewStreamClient("127.0.0.1", port, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Send(model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	reader := bufio.NewReader(second)
+	if document := readStreamDocument(t, reader); !strings.Contains(document.Error.(string), "too many connections") {
+		t.Errorf("unexpected answer: %+v", document)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("a refused connection must be closed, got %v", err)
+	}
+	if stats := srv.Stats(); stats.Rejected != 1 || stats.Accepted != 1 || stats.Options.MaxConnectionsPerIP != 1 {
+		t.Errorf("unexpected server stats: %+v", stats)
+	}
+	if stats := StreamStats(); stats.Rejected != rejected+1 {
+		t.Errorf("the process counters must include every server: %+v", stats)
+	}
+	if other, err := NewStreamClient("127.0.0.1", otherPort, time.Second); err != nil {
+		t.Error(err)
+	} else {
+		other.Close()
+	}
+
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cli, err := NewStreamClient("127.0.0.1", port, time.Second)
+		if err == nil {
+			_, err = cli.Send(model.Document{Department: "Test", Transaction: "echo"})
+			cli.Close()
+		}
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the slot of a closed connection must be released: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestStreamFrameSize(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	register := &department.RegisterDispatcher{Stream: &model.StreamOptions{MaxFrameSize: 256}}
+	large := model.Document{Department: "Test", Transaction: "echo", Form: model.DocumentForm{"text": strings.Repeat("x", 1000)}}
+
+	serverConn, clientConn := net.Pipe()
+	go handleStreamConn(serverConn, register)
+	defer clientConn.Close()
+	reader := bufio.NewReader(clientConn)
+	b, _ := json.Marshal(large)
+	if _, err := clientConn.Write(append(b, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	if document := readStreamDocument(t, reader); !strings.Contains(document.Error.(string), "maximum size of 256 bytes") {
+		t.Errorf("an oversized line must be answered with an error: %+v", document)
+	}
+	if _, err := clientConn.Write([]byte(`{"department":"Test","transaction":"echo"}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if document := readStreamDocument(t, reader); document.Type != "Result" {
+		t.Errorf("the connection must go on after an oversized line: %+v", document)
+	}
+
+	// on version 2 the error answers the call of the oversized frame
+	cli := multiplexedPipe(t, register)
+	defer cli.Close()
+	if _, err := cli.Send(large); err == nil || !strings.Contains(err.Error(), "maximum size") {
+		t.Errorf("the oversized call must fail, got %v", err)
+	}
+	if _, err := cli.Send(model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Errorf("the connection must go on after an oversized frame: %v", err)
+	}
+}
+
+func TestStreamTimeouts(t *testing.T) {
+	registerSleep()
+	defer func() { department.DispatcherHolder = nil }()
+	register := &department.RegisterDispatcher{Stream: &model.StreamOptions{HandshakeTimeout: 100 * time.Millisecond, IdleTimeout: 100 * time.Millisecond}}
+	handshakes, idles := StreamStats().HandshakeTimeouts, StreamStats().IdleTimeouts
+
+	serverConn, clientConn := net.Pipe()
+	go handleStreamConn(serverConn, register)
+	if _, err := clientConn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("a silent connection must be closed, got %v", err)
+	}
+	clientConn.Close()
+
+	cli := multiplexedPipe(t, register)
+	defer cli.Close()
+	// a running request keeps the connection open past the idle timeout
+	if _, err := cli.Send(sleepDocument(300)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err := cli.Send(sleepDocument(0)); err == nil {
+		t.Errorf("an idle connection must be closed")
+	}
+	if stats := StreamStats(); stats.HandshakeTimeouts != handshakes+1 || stats.IdleTimeouts != idles+1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
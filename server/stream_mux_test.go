@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+// sleepServer answers after form.ms milliseconds.
+type sleepServer struct {
+	echoServer
+}
+
+func (s sleepServer) Init(document model.Document) model.Document {
+	ms, _ := document.Form["ms"].(float64)
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	return s.echoServer.Init(document)
+}
+
+func registerSleep() {
+	registerEcho()
+	department.DispatcherHolder.Add("Test", transaction.TransactionBucketItem{Name: "sleep", Transaction: sleepServer{}})
+}
+
+func multiplexedPipe(t *testing.T, register *department.RegisterDispatcher) *StreamClient {
+	serverConn, clientConn := net.Pipe()
+	go handleStreamConn(serverConn, register)
+	cli := &StreamClient{conn: clientConn, reader: bufio.NewReader(clientConn), ReadWriteTimeout: 5 * time.Second}
+	if err := cli.EnableMultiplexing(); err != nil {
+		t.Fatal(err)
+	}
+	return cli
+}
+
+func sleepDocument(ms int) model.Document {
+	return model.Document{Department: "Test", Transaction: "sleep", Form: model.DocumentForm{"ms": ms}}
+}
+
+func TestStreamMultiplexing(t *testing.T) {
+	registerSleep()
+	defer func() { department.DispatcherHolder = nil }()
+	cli := multiplexedPipe(t, &department.RegisterDispatcher{})
+	defer cli.Close()
+
+	var order []int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, ms := range []int{200, 0} {
+		wg.Add(1)
+		go func(ms int) {
+			defer wg.Done()
+			resp, err := cli.Send(sleepDocument(ms))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, int(resp.Output.(map[string]interface{})["ms"].(float64)))
+			mu.Unlock()
+		}(ms)
+		// make sure the slow call is sent first
+		time.Sleep(20 * time.Millisecond)
+	}
+	wg.Wait()
+	if fmt.Sprint(order) != "[0 200]" {
+		t.Errorf("the fast call must not wait for the slow one: %v", order)
+	}
+	if elapsed := time.Since(start); elapsed > 350*time.Millisecond {
+		t.Errorf("calls were not executed concurrently: %v", elapsed)
+	}
+
+	results, err := cli.SendBatch([]model.Document{sleepDocument(0), {Department: "Test", Transaction: "missing"}})
+	if err != nil || len(results) != 2 || results[1].Type != "Error" {
+		t.Errorf("batch over a multiplexed connection: %v %+v", err, results)
+	}
+	if _, err := cli.Send(model.Document{Department: "Test", Transaction: "echo", Form: model.DocumentForm{"fail": true}}); err == nil {
+		t.Errorf("remote errors must be returned")
+	}
+	if err := cli.EnableCompression(); err == nil {
+		t.Errorf("compression cannot be enabled after multiplexing")
+	}
+}
+
+func TestStreamMultiplexing_ConcurrencyLimit(t *testing.T) {
+	registerSleep()
+	defer func() { department.DispatcherHolder = nil }()
+	cli := multiplexedPipe(t, &department.RegisterDispatcher{Stream: &model.StreamOptions{Concurrency: 1}})
+	defer cli.Close()
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cli.Send(sleepDocument(100)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("a connection must not run more than Concurrency requests at once: %v", elapsed)
+	}
+}
+
+func TestStreamMultiplexing_WithCompression(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	serverConn, clientConn := net.Pipe()
+	go handleStreamConn(serverConn, &department.RegisterDispatcher{Compression: &model.CompressionOptions{Enabled: true}})
+	cli := &StreamClient{conn: clientConn, reader: bufio.NewReader(clientConn)}
+	defer cli.Close()
+	if err := cli.EnableCompression(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.EnableMultiplexing(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cli.Send(model.Document{Department: "Test", Transaction: "echo", Form: model.DocumentForm{"n": 1}})
+	if err != nil || resp.Output.(map[string]interface{})["n"] != float64(1) {
+		t.Errorf("unexpected response %+v %v", resp, err)
+	}
+}
+
+// TestStreamMultiplexing_OldServer talks to a server that only knows protocol version 1.
+func TestStreamMultiplexing_OldServer(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	go func() {
+		reader := bufio.NewReader(serverConn)
+		for {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return
+			}
+			serverConn.Write([]byte(`{"type":"Error","error":"transaction not found"}` + "\n"))
+		}
+	}()
+	cli := &StreamClient{conn: clientConn, reader: bufio.NewReader(clientConn)}
+	defer cli.Close()
+	if err := cli.EnableMultiplexing(); !errors.Is(err, ErrMultiplexingUnsupported) {
+		t.Fatalf("expected ErrMultiplexingUnsupported, got %v", err)
+	}
+	if cli.Multiplexed() {
+		t.Errorf("the connection must stay on version 1")
+	}
+	if _, err := cli.Send(model.Document{Department: "Test", Transaction: "echo"}); err == nil || cli.Broken() {
+		t.Errorf("version 1 calls must keep working: %v", err)
+	}
+}
+
+func TestStreamClientPool_Multiplex(t *testing.T) {
+	registerSleep()
+	defer func() { department.DispatcherHolder = nil }()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go handleStreamConn(conn, &department.RegisterDispatcher{})
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	pool, _ := NewStreamClientPool("127.0.0.1", port, 1, time.Second)
+	pool.Multiplex = true
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Send(sleepDocument(100)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("calls over one shared connection must overlap: %v", elapsed)
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("expected one connection, got %d", n)
+	}
+}
+
+func TestStreamClientPool_MultiplexSlowDial(t *testing.T) {
+	// the server accepts connections but never answers the protocol handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conns := make(chan net.Conn, 8)
+	defer func() {
+		for len(conns) > 0 {
+			(<-conns).Close()
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	pool, _ := NewStreamClientPool("127.0.0.1", port, 2, time.Second)
+	pool.Multiplex = true
+	pool.ReadWriteTimeout = 500 * time.Millisecond
+	pool.AcquireTimeout = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Acquire(); !errors.Is(err, ErrPoolTimeout) {
+				t.Errorf("expected ErrPoolTimeout, got %v", err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if stats := pool.Stats(); stats.Dials != 1 {
+		t.Errorf("waiting callers must share one dial: %+v", stats)
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("Acquire must honour AcquireTimeout during a dial: %v", elapsed)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.AcquireContext(ctx); !errors.Is(err, ErrPoolTimeout) {
+		t.Errorf("AcquireContext must honour its context, got %v", err)
+	}
+	start = time.Now()
+	pool.Close()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Close must not wait for a dial: %v", elapsed)
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// poolServer serves the stream API and passes the server side of every connection to conns.
+func poolServer(t *testing.T) (port string, conns chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	conns = make(chan net.Conn, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go handleStreamConn(conn, &department.RegisterDispatcher{})
+		}
+	}()
+	_, port, _ = net.SplitHostPort(ln.Addr().String())
+	return port, conns
+}
+
+func TestStreamClientPool_AcquireTimeout(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	port, _ := poolServer(t)
+	pool, _ := NewStreamClientPool("127.0.0.1", port, 1, time.Second)
+	pool.AcquireTimeout = 100 * time.Millisecond
+
+	held, err := pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Send(model.Document{Department: "Test", Transaction: "echo"}); !errors.Is(err, ErrPoolTimeout) {
+		t.Errorf("an exhausted pool must time out, got %v", err)
+	}
+	waiting := make(chan error, 1)
+	go func() {
+		c, err := pool.AcquireContext(context.Background())
+		pool.Release(c, err)
+		waiting <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	pool.Release(held, nil)
+	if err := <-waiting; err != nil {
+		t.Errorf("a released connection must go to a waiting caller: %v", err)
+	}
+	stats := pool.Stats()
+	if stats.Waits != 2 || stats.Timeouts != 1 || stats.WaitDuration < 100*time.Millisecond || stats.Dials != 1 || stats.Idle != 1 || stats.InUse != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	held, _ = pool.Acquire()
+	go func() {
+		_, err := pool.AcquireContext(context.Background())
+		waiting <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	pool.Close()
+	if err := <-waiting; !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("waiting callers must fail when the pool is closed, got %v", err)
+	}
+	pool.Release(held, nil)
+	if _, err := pool.Acquire(); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("a closed pool must refuse calls, got %v", err)
+	}
+}
+
+func TestStreamClientPool_HealthCheck(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	port, conns := poolServer(t)
+	pool, _ := NewStreamClientPool("127.0.0.1", port, 2, time.Second)
+	pool.HealthCheckInterval = 50 * time.Millisecond
+	defer pool.Close()
+
+	if _, err := pool.Send(model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	// the server side goes away while the connection is idle
+	(<-conns).Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.Stats().HealthCheckFailures == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the dead connection must fail its health check: %+v", pool.Stats())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, err := pool.Send(model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Errorf("the next call must get a new connection: %v", err)
+	}
+}
+
+func TestStreamClientPool_Eviction(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	port, _ := poolServer(t)
+	pool, _ := NewStreamClientPool("127.0.0.1", port, 3, time.Second)
+	pool.MinIdle = 1
+	pool.MaxIdleTime = 100 * time.Millisecond
+	defer pool.Close()
+
+	var held []*StreamClient
+	for range 3 {
+		c, err := pool.Acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, c)
+	}
+	for _, c := range held {
+		pool.Release(c, nil)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if stats := pool.Stats(); stats.Idle != 1 || stats.Open != 1 || stats.IdleClosed != 2 {
+		t.Errorf("idle connections must be closed down to MinIdle: %+v", stats)
+	}
+
+	lifetime, _ := NewStreamClientPool("127.0.0.1", port, 1, time.Second)
+	lifetime.MaxLifetime = 100 * time.Millisecond
+	defer lifetime.Close()
+	first, _ := lifetime.Acquire()
+	time.Sleep(150 * time.Millisecond)
+	lifetime.Release(first, nil)
+	second, err := lifetime.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lifetime.Release(second, nil)
+	if second == first || lifetime.Stats().LifetimeClosed != 1 {
+		t.Errorf("an expired connection must be replaced: %+v", lifetime.Stats())
+	}
+}
+
+func TestStreamClient_Ping(t *testing.T) {
+	port, _ := poolServer(t)
+	cli, err := NewStreamClient("127.0.0.1", port, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if err := cli.Ping(); err != nil {
+		t.Errorf("ping on version 1: %v", err)
+	}
+	if err := cli.EnableMultiplexing(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Ping(); err != nil {
+		t.Errorf("ping on version 2: %v", err)
+	}
+}
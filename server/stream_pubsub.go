@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/pubsub"
+)
+
+// streamSubscriptions are the topic subscriptions of a version 2 connection. A subscription
+// is a call that does not end: the subscribe frame is acknowledged and every event follows
+// with the same ID and More set, until an unsubscribe document or the connection ends it.
+type streamSubscriptions struct {
+	mu       sync.Mutex
+	byID     map[uint64]*pubsub.Subscription
+	wg       sync.WaitGroup
+	activity activityTracker
+	peer     *model.PeerCredentials
+}
+
+// isSubscriptionDocument reports whether a control document is handled by streamSubscriptions.
+func isSubscriptionDocument(document model.Document) bool {
+	return document.Type == constants.DOC_TYPE_SUBSCRIBE || document.Type == constants.DOC_TYPE_UNSUBSCRIBE
+}
+
+// handle answers a subscribe or unsubscribe frame.
+func (ss *streamSubscriptions) handle(id uint64, document model.Document, out frameWriter, register *department.RegisterDispatcher) {
+	if document.Type == constants.DOC_TYPE_UNSUBSCRIBE {
+		var target uint64
+		if err := remarshal(document.Procedure, &target); err != nil {
+			_ = out.writeFrame(model.StreamFrame{ID: id, Body: streamErrorLine(fmt.Errorf("unsubscribe needs the ID of the subscribe frame: %w", err))})
+			return
+		}
+		ss.mu.Lock()
+		subscription := ss.byID[target]
+		ss.mu.Unlock()
+		if subscription != nil {
+			subscription.Cancel()
+		}
+		b, _ := json.Marshal(model.Document{Type: constants.DOC_TYPE_UNSUBSCRIBE, Procedure: target})
+		_ = out.writeFrame(model.StreamFrame{ID: id, Body: b})
+		return
+	}
+
+	department.InheritPeer(&document, ss.peer)
+	subscription, err := subscribe(document, register)
+	if err != nil {
+		_ = out.writeFrame(model.StreamFrame{ID: id, Body: streamErrorLine(err)})
+		return
+	}
+	ss.mu.Lock()
+	if ss.byID == nil {
+		ss.byID = map[uint64]*pubsub.Subscription{}
+	}
+	ss.byID[id] = subscription
+	ss.mu.Unlock()
+
+	ack, _ := json.Marshal(model.Document{Type: constants.DOC_TYPE_SUBSCRIBE, Procedure: document.Procedure})
+	if out.writeFrame(model.StreamFrame{ID: id, More: true, Body: ack}) != nil {
+		subscription.Cancel()
+	}
+	ss.wg.Add(1)
+	ss.activity.begin()
+	go func() {
+		defer ss.wg.Done()
+		defer ss.activity.end()
+		for event := range subscription.C {
+			b, err := json.Marshal(event.Document)
+			if err != nil {
+				b = streamErrorLine(err)
+			}
+			if out.writeFrame(model.StreamFrame{ID: id, More: true, Body: b}) != nil {
+				subscription.Cancel()
+			}
+		}
+		ss.mu.Lock()
+		delete(ss.byID, id)
+		ss.mu.Unlock()
+		end, _ := json.Marshal(model.Document{Type: constants.DOC_TYPE_UNSUBSCRIBE, Procedure: document.Procedure})
+		if err := subscription.Err(); err != nil {
+			end = streamErrorLine(err)
+		}
+		_ = out.writeFrame(model.StreamFrame{ID: id, Body: end})
+	}()
+}
+
+// close ends the subscriptions of a closed connection.
+func (ss *streamSubscriptions) close() {
+	ss.mu.Lock()
+	for _, subscription := range ss.byID {
+		subscription.Cancel()
+	}
+	ss.mu.Unlock()
+	ss.wg.Wait()
+}
+
+// subscribe checks a subscribe document against RegisterDispatcher.PubSub and registers it
+// on pubsub.DefaultBroker. Procedure holds the topic pattern and Form the optional filter.
+func subscribe(document model.Document, register *department.RegisterDispatcher) (*pubsub.Subscription, error) {
+	pattern, ok := document.Procedure.(string)
+	if !ok || pattern == "" {
+		return nil, errors.New("subscribe needs a topic in procedure")
+	}
+	options, err := department.AuthorizeSubscription(register, []string{pattern}, document.Security)
+	if err != nil {
+		return nil, err
+	}
+	return pubsub.DefaultBroker.Subscribe(pattern, document.Form, options.Buffer)
+}
+
+func remarshal(in interface{}, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
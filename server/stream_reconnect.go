@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// errNotSent marks a call that failed before any of its request was written, or that the
+// server refused unread, so it can be sent again safely.
+type errNotSent struct {
+	err error
+}
+
+func (e errNotSent) Error() string { return e.err.Error() }
+func (e errNotSent) Unwrap() error { return e.err }
+
+// EnableReconnect lets the client replace a failed connection. The next call dials again,
+// pausing between failed dials with exponential backoff and jitter, and restores compression
+// and protocol version 2 when they were enabled. A call is sent again on the new connection
+// when it failed before its request was written, when the server refused it while shutting
+// down, or when options.Idempotent accepts its documents. Subscriptions end with their
+// connection and must be made again. A nil options uses the defaults.
+func (c *StreamClient) EnableReconnect(options *model.ReconnectOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done == nil && c.conn != nil {
+		c.done = make(chan struct{})
+	}
+	c.stateMu.Lock()
+	c.state = model.StreamClientConnected
+	c.stateMu.Unlock()
+	c.reconnect.Store(options.WithDefaults())
+}
+
+// withReconnect runs call, first replacing a failed connection when reconnection is enabled,
+// and runs it again on a new connection when it failed in a way that is safe to repeat.
+func (c *StreamClient) withReconnect(idempotent bool, call func() error) error {
+	options := c.reconnect.Load()
+	if options == nil {
+		return call()
+	}
+	for retry := 0; ; retry++ {
+		if err := c.reconnectIfBroken(); err != nil {
+			return err
+		}
+		err := call()
+		if err == nil || retry >= options.MaxRetries || !c.retryable(err, idempotent) {
+			return err
+		}
+	}
+}
+
+func (c *StreamClient) retryable(err error, idempotent bool) bool {
+	var notSent errNotSent
+	switch {
+	case errors.Is(err, errClientClosed):
+		return false
+	case errors.As(err, &notSent):
+		return true
+	}
+	return idempotent && c.Broken()
+}
+
+// idempotent reports whether ReconnectOptions.Idempotent accepts all of documents.
+func (c *StreamClient) idempotent(documents ...model.Document) bool {
+	options := c.reconnect.Load()
+	if options == nil || options.Idempotent == nil {
+		return false
+	}
+	for _, document := range documents {
+		if !options.Idempotent(document) {
+			return false
+		}
+	}
+	return true
+}
+
+// reconnectIfBroken replaces a failed connection when reconnection is enabled. Concurrent
+// callers wait for one of them to reconnect.
+func (c *StreamClient) reconnectIfBroken() error {
+	options := c.reconnect.Load()
+	gen := c.gen.Load()
+	if options == nil || !c.Broken() {
+		return nil
+	}
+	c.reconnectMu.Lock()
+	defer c.reconnectMu.Unlock()
+	if c.gen.Load() != gen {
+		// replaced meanwhile
+		return nil
+	}
+	c.noticeBroken()
+	delay := options.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := c.redial()
+		if err == nil {
+			c.setState(model.StreamClientConnected, nil)
+			return nil
+		}
+		if errors.Is(err, errClientClosed) {
+			return err
+		}
+		if attempt >= options.MaxAttempts {
+			return fmt.Errorf("reconnect failed after %d attempts: %w", attempt, err)
+		}
+		c.setState(model.StreamClientReconnecting, err)
+		pause := delay - time.Duration(options.Jitter*rand.Float64()*float64(delay))
+		select {
+		case <-time.After(pause):
+		case <-c.done:
+			return errClientClosed
+		}
+		delay = min(2*delay, options.MaxBackoff)
+	}
+}
+
+// redial replaces the connection, failing the calls still waiting on the old one, and
+// negotiates compression and protocol version 2 again when they were enabled.
+func (c *StreamClient) redial() error {
+	conn, err := net.DialTimeout(c.network, c.addr, c.dialTimeout)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		_ = conn.Close()
+		return errClientClosed
+	}
+	old := c.conn
+	c.gen.Add(1)
+	c.conn, c.reader, c.deflate, c.multiplexed, c.dialed = conn, bufio.NewReader(conn), nil, false, time.Now()
+	_ = old.Close()
+	c.pendingMu.Lock()
+	pending := c.pending
+	c.pending, c.readErr = nil, nil
+	c.pendingMu.Unlock()
+	for _, p := range pending {
+		p.deliver(streamReply{err: net.ErrClosed})
+	}
+	if err := c.negotiateLocked(); err != nil {
+		return c.broken(err)
+	}
+	return nil
+}
+
+// negotiateLocked enables compression and protocol version 2 on a new connection; c.mu is held.
+func (c *StreamClient) negotiateLocked() error {
+	if c.compressed {
+		var ack model.Document
+		if err := c.exchangeLocked(model.Document{Type: constants.DOC_TYPE_COMPRESSION, Procedure: encodingDeflate}, &ack); err != nil {
+			return err
+		}
+		if ack.Type != constants.DOC_TYPE_COMPRESSION {
+			return fmt.Errorf("remote error: %v", ack.Error)
+		}
+		if err := c.startDeflateLocked(); err != nil {
+			return err
+		}
+	}
+	if c.wantMultiplex {
+		var ack model.Document
+		if err := c.exchangeLocked(model.Document{Type: constants.DOC_TYPE_PROTOCOL, Procedure: model.StreamProtocolVersion}, &ack); err != nil {
+			return err
+		}
+		// a server that no longer speaks version 2 is still used with sequential calls
+		if ack.Type == constants.DOC_TYPE_PROTOCOL {
+			c.startMultiplexingLocked()
+		}
+	}
+	return nil
+}
+
+// noticeBroken reports a failed connection to ReconnectOptions.OnStateChange.
+func (c *StreamClient) noticeBroken() {
+	c.pendingMu.Lock()
+	err := c.readErr
+	c.pendingMu.Unlock()
+	if err != nil {
+		c.setState(model.StreamClientDisconnected, err)
+	}
+}
+
+// setState calls ReconnectOptions.OnStateChange when the state changes; every failed dial
+// is reported. Nothing follows StreamClientClosed.
+func (c *StreamClient) setState(state model.StreamClientState, err error) {
+	options := c.reconnect.Load()
+	if options == nil || options.OnStateChange == nil {
+		return
+	}
+	c.stateMu.Lock()
+	if c.state == model.StreamClientClosed || (c.state == state && state != model.StreamClientReconnecting) {
+		c.stateMu.Unlock()
+		return
+	}
+	c.state = state
+	c.stateMu.Unlock()
+	options.OnStateChange(state, err)
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// restartableStream serves the stream API on a fixed port that survives restarts.
+type restartableStream struct {
+	t    *testing.T
+	port string
+	srv  *StreamServer
+}
+
+func newRestartableStream(t *testing.T) *restartableStream {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	s := &restartableStream{t: t, port: port}
+	s.start()
+	t.Cleanup(s.stop)
+	return s
+}
+
+func (s *restartableStream) start() {
+	s.srv = &StreamServer{Register: &department.RegisterDispatcher{StreamPort: s.port}}
+	go s.srv.ListenAndServe()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+s.port)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("the stream server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *restartableStream) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = s.srv.Shutdown(ctx)
+}
+
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []model.StreamClientState
+}
+
+func (r *stateRecorder) record(state model.StreamClientState, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = append(r.states, state)
+}
+
+func (r *stateRecorder) seen(state model.StreamClientState) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStreamClient_Reconnect(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	server := newRestartableStream(t)
+	cli, err := NewStreamClient("127.0.0.1", server.port, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	states := &stateRecorder{}
+	cli.EnableReconnect(&model.ReconnectOptions{InitialBackoff: 20 * time.Millisecond, MaxAttempts: 50, OnStateChange: states.record})
+	echo := model.Document{Department: "Test", Transaction: "echo"}
+	if _, err := cli.Send(echo); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server restarts while the client is idle
+	server.stop()
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		server.start()
+	}()
+	if _, err := cli.Send(echo); err != nil {
+		t.Fatalf("the call must be sent again on a new connection: %v", err)
+	}
+	for _, state := range []model.StreamClientState{model.StreamClientDisconnected, model.StreamClientReconnecting, model.StreamClientConnected} {
+		if !states.seen(state) {
+			t.Errorf("state %q was not reported: %v", state, states.states)
+		}
+	}
+
+	// a multiplexed connection is multiplexed again after a restart
+	if err := cli.EnableMultiplexing(); err != nil {
+		t.Fatal(err)
+	}
+	server.stop()
+	server.start()
+	if _, err := cli.Send(echo); err != nil {
+		t.Fatalf("call after the second restart: %v", err)
+	}
+	if !cli.Multiplexed() {
+		t.Errorf("protocol version 2 must be negotiated again")
+	}
+	cli.Close()
+	if !states.seen(model.StreamClientClosed) {
+		t.Errorf("closing must be reported")
+	}
+}
+
+func TestStreamClient_RetryOnlyIdempotent(t *testing.T) {
+	// a server that reads every request and drops the connection without answering
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var received atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := bufio.NewReader(conn).ReadBytes('\n'); err == nil {
+					received.Add(1)
+				}
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	cli, err := NewStreamClient("127.0.0.1", port, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	cli.EnableReconnect(&model.ReconnectOptions{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxRetries:     2,
+		Idempotent:     func(document model.Document) bool { return document.Transaction == "get" },
+	})
+
+	if _, err := cli.Send(model.Document{Department: "Orders", Transaction: "create"}); err == nil {
+		t.Fatal("the call must fail")
+	}
+	waitForCount(t, &received, 1)
+	if _, err := cli.Send(model.Document{Department: "Orders", Transaction: "get"}); err == nil {
+		t.Fatal("the call must fail")
+	}
+	// the first attempt and two retries
+	waitForCount(t, &received, 4)
+	time.Sleep(50 * time.Millisecond)
+	if n := received.Load(); n != 4 {
+		t.Errorf("a call that may have run must not be sent again unless it is idempotent: %d requests", n)
+	}
+
+	cli.EnableReconnect(&model.ReconnectOptions{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxRetries:     -1,
+		Idempotent:     func(document model.Document) bool { return true },
+	})
+	if _, err := cli.Send(model.Document{Department: "Orders", Transaction: "get"}); err == nil {
+		t.Fatal("the call must fail")
+	}
+	waitForCount(t, &received, 5)
+	time.Sleep(50 * time.Millisecond)
+	if n := received.Load(); n != 5 {
+		t.Errorf("a negative MaxRetries must not retry: %d requests", n)
+	}
+	if options := (&model.ReconnectOptions{Jitter: -1}).WithDefaults(); options.Jitter != 0 || options.MaxRetries != 2 {
+		t.Errorf("unexpected defaults: %+v", options)
+	}
+}
+
+func waitForCount(t *testing.T, counter *atomic.Int32, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for counter.Load() < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d, got %d", want, counter.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
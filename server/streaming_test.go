@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/middleware"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/transaction"
+)
+
+type rowsRequest struct {
+	Count  int `json:"count"`
+	FailAt int `json:"fail_at,omitempty"`
+}
+
+type rowsTotal struct {
+	Rows int `json:"rows"`
+}
+
+// rowsTransaction streams form.count rows and reports their number in the terminal document.
+type rowsTransaction struct {
+	middleware.Middleware[rowsRequest, rowsTotal]
+}
+
+func (t *rowsTransaction) SetSelfRunables() error  { return nil }
+func (t *rowsTransaction) SetupTransaction() error { return nil }
+func (t *rowsTransaction) Transact() error         { return errors.New("rows must be streamed") }
+
+func (t *rowsTransaction) TransactStream(emit func(output any) error) error {
+	for i := 1; i <= t.Request.Count; i++ {
+		if i == t.Request.FailAt {
+			return errors.New("row failed")
+		}
+		if err := emit(map[string]int{"row": i}); err != nil {
+			return err
+		}
+		t.Response.Rows++
+	}
+	return nil
+}
+
+func registerRows() {
+	registerEcho()
+	department.DispatcherHolder.Add("Report", transaction.TransactionBucketItem{Name: "rows", Transaction: Server[rowsTransaction, *rowsTransaction]{}})
+}
+
+func rowsDocument(count, failAt int) model.Document {
+	return model.Document{Department: "Report", Transaction: "rows", Form: model.DocumentForm{"count": count, "fail_at": failAt}}
+}
+
+func TestStreamingTransaction_StreamClient(t *testing.T) {
+	registerRows()
+	defer func() { department.DispatcherHolder = nil }()
+	cli := multiplexedPipe(t, &department.RegisterDispatcher{})
+	defer cli.Close()
+
+	var docs []model.Document
+	for doc, err := range cli.SendStream(rowsDocument(3, 0)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) != 4 {
+		t.Fatalf("want 3 partial documents and the terminal one, got %+v", docs)
+	}
+	for i, doc := range docs[:3] {
+		if doc.Type != constants.DOC_TYPE_STREAM || doc.Output.(map[string]interface{})["row"] != float64(i+1) {
+			t.Errorf("unexpected partial document %d: %+v", i, doc)
+		}
+	}
+	if terminal := docs[3]; terminal.Type != constants.DOC_TYPE_RESULT || terminal.Output.(map[string]interface{})["rows"] != float64(3) {
+		t.Errorf("unexpected terminal document: %+v", terminal)
+	}
+
+	docs = nil
+	var streamErr error
+	for doc, err := range cli.SendStream(rowsDocument(3, 2)) {
+		docs = append(docs, doc)
+		streamErr = err
+	}
+	if len(docs) != 2 || streamErr == nil || docs[1].Error != "row failed" {
+		t.Errorf("a failing transaction must end the stream with its error: %+v %v", docs, streamErr)
+	}
+
+	// leaving the loop early must not hold up the connection
+	for range cli.SendStream(rowsDocument(100, 0)) {
+		break
+	}
+	if _, err := cli.Send(model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	if cli.Pending() != 0 {
+		t.Errorf("no call must be pending: %d", cli.Pending())
+	}
+
+	// a transaction that does not stream answers with its single document
+	docs = nil
+	for doc, err := range cli.SendStream(model.Document{Department: "Test", Transaction: "echo", Form: model.DocumentForm{"n": 1}}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) != 1 || docs[0].Type != constants.DOC_TYPE_RESULT {
+		t.Errorf("unexpected documents: %+v", docs)
+	}
+}
+
+func TestStreamingTransaction_Collected(t *testing.T) {
+	registerRows()
+	defer func() { department.DispatcherHolder = nil }()
+	serverConn, clientConn := net.Pipe()
+	go handleStreamConn(serverConn, &department.RegisterDispatcher{})
+	cli := &StreamClient{conn: clientConn, reader: bufio.NewReader(clientConn), ReadWriteTimeout: 5 * time.Second}
+	defer cli.Close()
+
+	// without protocol version 2 the partial outputs are collected into one document
+	var docs []model.Document
+	for doc, err := range cli.SendStream(rowsDocument(2, 0)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) != 1 || len(docs[0].Output.([]interface{})) != 2 {
+		t.Errorf("unexpected documents: %+v", docs)
+	}
+
+	results, err := cli.SendBatch([]model.Document{rowsDocument(1, 0), rowsDocument(2, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results[1].Output.([]interface{})) != 2 {
+		t.Errorf("unexpected batch results: %+v", results)
+	}
+}
+
+func TestStreamingTransaction_HTTP(t *testing.T) {
+	registerRows()
+	defer func() { department.DispatcherHolder = nil }()
+
+	post := func(accept string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(rowsDocument(3, 0))
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+		r.Header.Set("Content-Type", "application/json")
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		department.RegisterMainFunc(rr, r)
+		return rr
+	}
+
+	rr := post("")
+	if rr.Header().Get("Content-Type") != constants.HTTP_CONTENT_NDJSON || !rr.Flushed {
+		t.Errorf("streaming transactions must answer with flushed NDJSON: %v", rr.Header())
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("want 4 lines, got %q", rr.Body.String())
+	}
+	var terminal model.Document
+	if err := json.Unmarshal([]byte(lines[3]), &terminal); err != nil {
+		t.Fatal(err)
+	}
+	if terminal.Type != constants.DOC_TYPE_RESULT {
+		t.Errorf("unexpected terminal document: %s", lines[3])
+	}
+
+	rr = post("application/json")
+	var doc model.Document
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("clients that only accept JSON must get one document: %v %q", err, rr.Body.String())
+	}
+	if len(doc.Output.([]interface{})) != 3 {
+		t.Errorf("the partial outputs must be collected: %+v", doc)
+	}
+}
+
+func TestStreamingTransaction_Help(t *testing.T) {
+	registerRows()
+	defer func() { department.DispatcherHolder = nil }()
+
+	rr := httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help?format=json", nil))
+	var list HelperList
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	streaming := map[string]bool{}
+	for _, dep := range list.Departments {
+		for _, tr := range dep.Transactions {
+			streaming[dep.Name+"."+tr.Name] = tr.Streaming
+		}
+	}
+	if !streaming["Report.rows"] || streaming["Test.echo"] {
+		t.Errorf("unexpected streaming marks: %v", streaming)
+	}
+
+	rr = httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help", nil))
+	if !strings.Contains(rr.Body.String(), "Akış") {
+		t.Errorf("html help does not mark the streaming transaction")
+	}
+
+	rr = httptest.NewRecorder()
+	ApiDocServer{}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/help?format=openapi", nil))
+	var spec OpenAPIDocument
+	if err := json.Unmarshal(rr.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if !spec.Paths["/Report/rows"].Post.Streaming {
+		t.Errorf("openapi does not mark the streaming transaction")
+	}
+}
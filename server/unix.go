@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// unixScheme prefixes the ports and hosts that address a unix domain socket, e.g.
+// "unix:///run/orders.sock".
+const unixScheme = "unix://"
+
+func isUnixAddress(address string) bool {
+	return strings.HasPrefix(address, unixScheme)
+}
+
+// listenAddress turns a port of RegisterDispatcher into a listen address.
+func listenAddress(port string) string {
+	if isUnixAddress(port) {
+		return port
+	}
+	return ":" + port
+}
+
+// listen opens a TCP listener for an address such as ":9000", or a unix socket for
+// "unix:///path". A socket file left behind by a process that is gone is removed first; one
+// that still accepts connections is in use.
+func listen(address string, options *model.UnixSocketOptions) (net.Listener, error) {
+	if !isUnixAddress(address) {
+		return net.Listen("tcp", address)
+	}
+	path := strings.TrimPrefix(address, unixScheme)
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSocketPermissions(path, options.WithDefaults()); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+func setSocketPermissions(path string, options *model.UnixSocketOptions) error {
+	if err := os.Chmod(path, options.Mode); err != nil {
+		return err
+	}
+	if options.Group == "" {
+		return nil
+	}
+	gid, err := strconv.Atoi(options.Group)
+	if err != nil {
+		group, err := user.LookupGroup(options.Group)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return err
+		}
+	}
+	return os.Chown(path, -1, gid)
+}
+
+// PeerConnContext stores the credentials of the unix socket peer of c in ctx, where
+// RegisterMainFunc and the other handlers pick them up. JsonApiServer sets it as the
+// ConnContext of its http.Server; custom servers serving NewHandler on a unix socket can
+// do the same.
+func PeerConnContext(ctx context.Context, c net.Conn) context.Context {
+	if peer := peerCredentials(c); peer != nil {
+		return department.ContextWithPeer(ctx, peer)
+	}
+	return ctx
+}
+
+// unixClients holds the http.Client of each unix socket called by CallHTTP, so connections are reused.
+var unixClients sync.Map
+
+func unixHTTPClient(path string) *http.Client {
+	if client, ok := unixClients.Load(path); ok {
+		return client.(*http.Client)
+	}
+	transport := newHTTPTransport(false)
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", path)
+	}
+	client, _ := unixClients.LoadOrStore(path, &http.Client{Timeout: 15 * time.Second, Transport: transport})
+	return client.(*http.Client)
+}
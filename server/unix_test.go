@@ -0,0 +1,182 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/transaction"
+	"github.com/godispatcher/logger"
+)
+
+// peerServer answers with the unix socket peer of the request.
+type peerServer struct {
+	model.ServerInterface
+}
+
+func (peerServer) Init(document model.Document) model.Document {
+	if document.Security == nil || document.Security.Peer == nil {
+		return model.Document{Error: "no peer", Type: "Error"}
+	}
+	document.Output = map[string]any{"uid": document.Security.Peer.UID}
+	document.Type = "Result"
+	return document
+}
+func (peerServer) GetRequest() any                { return struct{}{} }
+func (peerServer) GetResponse() any               { return struct{}{} }
+func (peerServer) GetOptions() model.ServerOption { return model.ServerOption{} }
+
+func registerPeer() {
+	registerEcho()
+	department.DispatcherHolder.Add("Test", transaction.TransactionBucketItem{Name: "peer", Transaction: peerServer{}})
+}
+
+func TestUnixSocket_HTTP(t *testing.T) {
+	registerPeer()
+	defer func() { department.DispatcherHolder = nil }()
+	path := filepath.Join(t.TempDir(), "api.sock")
+	register := department.NewRegisteryDispatcher("unix://" + path)
+	register.UnixSocket = &model.UnixSocketOptions{Mode: 0o600}
+	register.LoggerWriter = func(logger.LogEntry) error { return nil }
+	srv := &JsonApiServer{Register: register, Mux: http.NewServeMux()}
+	go srv.ListenAndServe()
+	defer srv.Shutdown(t.Context())
+	waitForSocket(t, path)
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("the socket must have the configured mode: %v %v", info.Mode(), err)
+	}
+	document, err := CallHTTP("unix://"+path, model.Document{Department: "Test", Transaction: "echo", Form: model.DocumentForm{"n": 1}})
+	if err != nil || document.Type != "Result" {
+		t.Fatalf("call over the unix socket: %+v %v", document, err)
+	}
+	if runtime.GOOS == "linux" {
+		document, err = CallHTTP("unix://"+path, model.Document{Department: "Test", Transaction: "peer"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output, _ := document.Output.(map[string]any); output["uid"] != float64(os.Getuid()) {
+			t.Errorf("the transaction must see the peer credentials: %+v", document)
+		}
+	}
+}
+
+func TestUnixSocket_Stream(t *testing.T) {
+	registerPeer()
+	defer func() { department.DispatcherHolder = nil }()
+	path := filepath.Join(t.TempDir(), "stream.sock")
+	srv := &StreamServer{Register: &department.RegisterDispatcher{StreamPort: "unix://" + path}}
+	go srv.ListenAndServe()
+	defer srv.Shutdown(t.Context())
+	waitForSocket(t, path)
+
+	cli, err := NewStreamClient("unix://"+path, "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if _, err := cli.Send(model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Errorf("call over the unix socket: %v", err)
+	}
+	if runtime.GOOS == "linux" {
+		// a security sent by the client does not hide the peer
+		if _, err := cli.Send(model.Document{Department: "Test", Transaction: "peer", Security: &model.Security{Licence: "x"}}); err != nil {
+			t.Errorf("the transaction must see the peer credentials: %v", err)
+		}
+	}
+
+	pool, err := NewStreamClientPool("unix://"+path, "", 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if _, err := pool.Send(model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Errorf("pooled call over the unix socket: %v", err)
+	}
+}
+
+func TestUnixSocket_StreamLimitPerUser(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are read on Linux")
+	}
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	path := filepath.Join(t.TempDir(), "limit.sock")
+	ln, err := listen("unix://"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &StreamServer{Register: &department.RegisterDispatcher{Stream: &model.StreamOptions{MaxConnectionsPerIP: 1}}}
+	go srv.Serve(ln)
+	defer srv.Shutdown(t.Context())
+
+	first, err := NewStreamClient("unix://"+path, "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if _, err := first.Send(model.Document{Department: "Test", Transaction: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	answer := make([]byte, 512)
+	n, _ := second.Read(answer)
+	if want := "too many connections from uid " + strconv.Itoa(os.Getuid()); !strings.Contains(string(answer[:n]), want) {
+		t.Errorf("local peers must be limited per user: %s", answer[:n])
+	}
+}
+
+func TestUnixSocket_StaleFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stale.sock")
+	// a socket file left behind by a process that is gone
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := listen("unix://"+path, nil)
+	if err != nil {
+		t.Fatalf("a stale socket must be replaced: %v", err)
+	}
+	defer ln.Close()
+	if _, err := listen("unix://"+path, nil); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("a socket in use must not be replaced, got %v", err)
+	}
+	regular := filepath.Join(dir, "regular")
+	if err := os.WriteFile(regular, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen("unix://"+regular, nil); err == nil {
+		t.Errorf("a file that is not a socket must not be removed")
+	}
+}
+
+func waitForSocket(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is not listening", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
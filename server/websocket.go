@@ -0,0 +1,380 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+)
+
+// WebSocketServer carries stream protocol version 2 over WebSocket (RFC 6455) for browsers,
+// which cannot reach ServStreamApi. Every text message is a model.StreamFrame; requests,
+// streaming responses and subscriptions work as on a version 2 stream connection.
+// ServJsonApi mounts it at WebSocketOptions.Path when RegisterDispatcher.WebSocket is enabled.
+type WebSocketServer struct {
+	Register *department.RegisterDispatcher
+	conns    *connTracker
+}
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// close codes of RFC 6455
+const (
+	wsGoingAway       = 1001
+	wsProtocolError   = 1002
+	wsPolicyViolation = 1008
+	wsMessageTooBig   = 1009
+)
+
+func (s WebSocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var options *model.WebSocketOptions
+	var cors *model.CORSOptions
+	if s.Register != nil {
+		options, cors = s.Register.WebSocket, s.Register.CORS
+	}
+	opts := options.WithDefaults()
+
+	if r.Method != http.MethodGet || !headerContainsToken(r.Header, "Connection", "upgrade") || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+	// browsers do not apply CORS to WebSockets, so the origin is checked here
+	if origin := r.Header.Get("Origin"); origin != "" && !websocketOriginAllowed(origin, r.Host, cors) {
+		http.Error(w, "forbidden: origin not allowed", http.StatusForbidden)
+		return
+	}
+	session := &websocketSession{options: opts, authenticated: opts.Authenticate == nil}
+	if token, licence := r.URL.Query().Get("token"), r.URL.Query().Get("licence"); token != "" || licence != "" {
+		security := &model.Security{VerifyCode: token, Licence: licence}
+		if opts.Authenticate != nil {
+			if err := opts.Authenticate(security); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		session.security, session.authenticated = security, true
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket needs an HTTP/1.1 connection", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(accept[:]))
+	if rw.Flush() != nil {
+		return
+	}
+	session.ws = &wsConn{conn: conn, reader: rw.Reader, maxSize: opts.MaxMessageSize}
+	done, ok := s.conns.add(conn, session)
+	if !ok {
+		_ = session.ws.writeFrame(model.StreamFrame{Body: goingAwayLine()})
+		_ = session.ws.close(wsGoingAway, errGoingAway.Error())
+		return
+	}
+	defer done()
+	serveStreamFrames(session, session.ws, s.Register, peerCredentials(conn))
+	if session.isDraining() {
+		_ = session.ws.close(wsGoingAway, errGoingAway.Error())
+	}
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func websocketOriginAllowed(origin, host string, cors *model.CORSOptions) bool {
+	options := cors.WithDefaults()
+	if options.EnforceSameOrigin && !sameOrigin(origin, host) {
+		return false
+	}
+	return originAllowed(origin, options.AllowedOrigins)
+}
+
+// websocketSession authenticates a WebSocket connection and hands its frames to serveStreamFrames.
+type websocketSession struct {
+	ws            *wsConn
+	options       *model.WebSocketOptions
+	security      *model.Security
+	authenticated bool
+
+	mu       sync.Mutex
+	draining bool
+}
+
+// drain stops reading messages for a shutdown; next then reports errGoingAway.
+func (s *websocketSession) drain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draining = true
+	_ = s.ws.conn.SetReadDeadline(time.Now())
+}
+
+func (s *websocketSession) isDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// next returns the next frame to execute. "Auth" documents are answered here; until one
+// succeeds an authenticating connection is closed at its first other frame. Documents
+// without security inherit the Security of the connection.
+func (s *websocketSession) next() ([]byte, error) {
+	for {
+		if s.isDraining() {
+			return nil, errGoingAway
+		}
+		message, err := s.ws.readMessage()
+		if err != nil && s.isDraining() {
+			return nil, errGoingAway
+		}
+		if err != nil {
+			return nil, err
+		}
+		var frame model.StreamFrame
+		if json.Unmarshal(message, &frame) != nil {
+			return message, nil
+		}
+		var document model.Document
+		if bytes.Contains(frame.Body, []byte(`"`+constants.DOC_TYPE_AUTH+`"`)) && json.Unmarshal(frame.Body, &document) == nil && document.Type == constants.DOC_TYPE_AUTH {
+			if err := s.authenticate(frame.ID, document); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !s.authenticated {
+			return nil, s.reject(frame.ID, errors.New("authentication required"))
+		}
+		if s.security == nil {
+			return message, nil
+		}
+		frame.Body = inheritSecurity(frame.Body, s.security)
+		return json.Marshal(frame)
+	}
+}
+
+func (s *websocketSession) authenticate(id uint64, document model.Document) error {
+	if s.options.Authenticate != nil {
+		if err := s.options.Authenticate(document.Security); err != nil {
+			return s.reject(id, err)
+		}
+	}
+	s.security, s.authenticated = document.Security, true
+	ack, _ := json.Marshal(model.Document{Type: constants.DOC_TYPE_AUTH})
+	return s.ws.writeFrame(model.StreamFrame{ID: id, Body: ack})
+}
+
+// reject answers the frame with err and closes the connection.
+func (s *websocketSession) reject(id uint64, err error) error {
+	_ = s.ws.writeFrame(model.StreamFrame{ID: id, Body: streamErrorLine(err)})
+	_ = s.ws.close(wsPolicyViolation, err.Error())
+	return io.EOF
+}
+
+// inheritSecurity sets security on the documents of body, a document or a batch, that carry
+// none. JSON-RPC payloads are left as they are.
+func inheritSecurity(body json.RawMessage, security *model.Security) json.RawMessage {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return body
+	}
+	switch trimmed[0] {
+	case '[':
+		var items []json.RawMessage
+		if json.Unmarshal(trimmed, &items) != nil {
+			return body
+		}
+		for i := range items {
+			items[i] = inheritSecurity(items[i], security)
+		}
+		if b, err := json.Marshal(items); err == nil {
+			return b
+		}
+	case '{':
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &fields) != nil {
+			return body
+		}
+		if _, ok := fields["jsonrpc"]; ok {
+			return body
+		}
+		if _, ok := fields["security"]; ok {
+			return body
+		}
+		fields["security"], _ = json.Marshal(security)
+		if b, err := json.Marshal(fields); err == nil {
+			return b
+		}
+	}
+	return body
+}
+
+// wsConn reads and writes the messages of a server side WebSocket connection.
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	maxSize int64
+	mu      sync.Mutex
+}
+
+// readMessage returns the next text or binary message. Fragments are joined, pings are
+// answered and a close frame is answered and reported as io.EOF.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			return nil, err
+		}
+		fin, opcode := header[0]&0x80 != 0, header[0]&0x0f
+		length := int64(header[1] & 0x7f)
+		switch length {
+		case 126:
+			var extended [2]byte
+			if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+				return nil, err
+			}
+			length = int64(binary.BigEndian.Uint16(extended[:]))
+		case 127:
+			var extended [8]byte
+			if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+				return nil, err
+			}
+			length = int64(binary.BigEndian.Uint64(extended[:]))
+		}
+		switch {
+		case header[0]&0x70 != 0:
+			return nil, c.fail(wsProtocolError, "extensions are not supported")
+		case header[1]&0x80 == 0:
+			return nil, c.fail(wsProtocolError, "client frames must be masked")
+		case opcode >= wsClose && (!fin || length > 125):
+			return nil, c.fail(wsProtocolError, "invalid control frame")
+		case length < 0 || int64(len(message))+length > c.maxSize:
+			return nil, c.fail(wsMessageTooBig, "message too big")
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return nil, err
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case wsClose:
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			_ = c.writeMessage(wsClose, payload)
+			return nil, io.EOF
+		case wsPing:
+			if err := c.writeMessage(wsPong, payload); err != nil {
+				return nil, err
+			}
+		case wsPong:
+		case wsText, wsBinary:
+			if started {
+				return nil, c.fail(wsProtocolError, "expected a continuation frame")
+			}
+			started, message = true, payload
+		case wsContinuation:
+			if !started {
+				return nil, c.fail(wsProtocolError, "unexpected continuation frame")
+			}
+			message = append(message, payload...)
+		default:
+			return nil, c.fail(wsProtocolError, "unknown opcode")
+		}
+		if started && fin {
+			return message, nil
+		}
+	}
+}
+
+// writeMessage writes a single unfragmented frame; server frames are not masked.
+func (c *wsConn) writeMessage(opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+func (c *wsConn) writeFrame(frame model.StreamFrame) error {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(wsText, b)
+}
+
+func (c *wsConn) close(code uint16, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123] // control frames carry at most 125 bytes
+	}
+	return c.writeMessage(wsClose, append(binary.BigEndian.AppendUint16(nil, code), reason...))
+}
+
+// fail closes the connection after a protocol violation.
+func (c *wsConn) fail(code uint16, reason string) error {
+	_ = c.close(code, reason)
+	return fmt.Errorf("websocket: %s", reason)
+}
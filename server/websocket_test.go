@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/constants"
+	"github.com/godispatcher/dispatcher/department"
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/pubsub"
+)
+
+// wsTestClient is a minimal browser-like WebSocket client.
+type wsTestClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, server *httptest.Server, path, origin string) (*wsTestClient, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	key := make([]byte, 16)
+	_, _ = rand.Read(key)
+	request := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: %s\r\n", path, server.Listener.Addr(), base64.StdEncoding.EncodeToString(key))
+	if origin != "" {
+		request += "Origin: " + origin + "\r\n"
+	}
+	if _, err := io.WriteString(conn, request+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, response.StatusCode
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &wsTestClient{conn: conn, reader: reader}, response.StatusCode
+}
+
+// send writes v as a masked text message, split into two fragments.
+func (c *wsTestClient) send(t *testing.T, v interface{}) {
+	t.Helper()
+	b, _ := json.Marshal(v)
+	half := len(b) / 2
+	c.writeFrame(t, wsText, false, b[:half])
+	c.writeFrame(t, wsContinuation, true, b[half:])
+}
+
+func (c *wsTestClient) writeFrame(t *testing.T, opcode byte, fin bool, payload []byte) {
+	t.Helper()
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	header := []byte{first}
+	switch n := len(payload); {
+	case n <= 125:
+		header = append(header, 0x80|byte(n))
+	default:
+		header = binary.BigEndian.AppendUint16(append(header, 0x80|126), uint16(n))
+	}
+	mask := []byte{1, 2, 3, 4}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	if _, err := c.conn.Write(append(append(header, mask...), masked...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receive returns the next message and its opcode.
+func (c *wsTestClient) receive(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var extended [2]byte
+		_, _ = io.ReadFull(c.reader, extended[:])
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func (c *wsTestClient) receiveFrame(t *testing.T) (model.StreamFrame, model.Document) {
+	t.Helper()
+	opcode, payload := c.receive(t)
+	if opcode != wsText {
+		t.Fatalf("want a text message, got opcode %d: %q", opcode, payload)
+	}
+	var frame model.StreamFrame
+	var document model.Document
+	if err := json.Unmarshal(payload, &frame); err != nil {
+		t.Fatal(err)
+	}
+	_ = json.Unmarshal(frame.Body, &document)
+	return frame, document
+}
+
+func websocketFrame(id uint64, document model.Document) model.StreamFrame {
+	body, _ := json.Marshal(document)
+	return model.StreamFrame{ID: id, Body: body}
+}
+
+func TestWebSocket(t *testing.T) {
+	registerRows()
+	defer func() { department.DispatcherHolder = nil }()
+	register := &department.RegisterDispatcher{
+		WebSocket: &model.WebSocketOptions{Enabled: true},
+		CORS:      &model.CORSOptions{AllowedOrigins: []string{"https://app.example.com"}},
+		PubSub:    &model.PubSubOptions{Enabled: true},
+	}
+	server := httptest.NewServer(WebSocketServer{Register: register})
+	defer server.Close()
+
+	if _, status := dialWebSocket(t, server, "/ws", "https://evil.example.com"); status != http.StatusForbidden {
+		t.Errorf("foreign origins must be rejected at upgrade, got %d", status)
+	}
+	rr := httptest.NewRecorder()
+	WebSocketServer{Register: register}.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if rr.Code != http.StatusUpgradeRequired {
+		t.Errorf("plain requests must be refused, got %d", rr.Code)
+	}
+
+	ws, status := dialWebSocket(t, server, "/ws", "https://app.example.com")
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade failed: %d", status)
+	}
+	ws.send(t, websocketFrame(1, model.Document{Department: "Test", Transaction: "echo", Form: model.DocumentForm{"n": 1}}))
+	if frame, document := ws.receiveFrame(t); frame.ID != 1 || document.Type != constants.DOC_TYPE_RESULT {
+		t.Errorf("unexpected response: %+v %+v", frame, document)
+	}
+
+	streaming := websocketFrame(2, rowsDocument(2, 0))
+	streaming.Stream = true
+	ws.send(t, streaming)
+	for i := 0; i < 3; i++ {
+		frame, document := ws.receiveFrame(t)
+		if frame.ID != 2 || frame.More != (i < 2) {
+			t.Errorf("unexpected streaming frame %d: %+v %+v", i, frame, document)
+		}
+	}
+
+	ws.send(t, websocketFrame(3, model.Document{Type: constants.DOC_TYPE_SUBSCRIBE, Procedure: "prices.>"}))
+	if frame, document := ws.receiveFrame(t); frame.ID != 3 || !frame.More || document.Type != constants.DOC_TYPE_SUBSCRIBE {
+		t.Fatalf("subscription was not acknowledged: %+v %+v", frame, document)
+	}
+	if n, _ := pubsub.Publish("prices.eur.usd", map[string]float64{"rate": 1.1}); n != 1 {
+		t.Fatalf("event was delivered to %d subscribers", n)
+	}
+	if frame, document := ws.receiveFrame(t); frame.ID != 3 || document.Type != constants.DOC_TYPE_EVENT || document.Procedure != "prices.eur.usd" {
+		t.Errorf("unexpected push: %+v %+v", frame, document)
+	}
+
+	ws.writeFrame(t, wsPing, true, []byte("hi"))
+	if opcode, payload := ws.receive(t); opcode != wsPong || string(payload) != "hi" {
+		t.Errorf("ping was not answered: %d %q", opcode, payload)
+	}
+	ws.writeFrame(t, wsClose, true, binary.BigEndian.AppendUint16(nil, 1000))
+	for {
+		// the subscription ends with the connection
+		opcode, _ := ws.receive(t)
+		if opcode == wsClose {
+			break
+		}
+	}
+}
+
+func TestWebSocketAuthentication(t *testing.T) {
+	registerEcho()
+	defer func() { department.DispatcherHolder = nil }()
+	register := &department.RegisterDispatcher{WebSocket: &model.WebSocketOptions{
+		Enabled: true,
+		Authenticate: func(security *model.Security) error {
+			if security == nil || security.VerifyCode != "secret" {
+				return errors.New("invalid token")
+			}
+			return nil
+		},
+	}}
+	server := httptest.NewServer(WebSocketServer{Register: register})
+	defer server.Close()
+	echo := model.Document{Department: "Test", Transaction: "echo"}
+
+	if _, status := dialWebSocket(t, server, "/ws?token=wrong", ""); status != http.StatusUnauthorized {
+		t.Errorf("a wrong query token must be rejected at upgrade, got %d", status)
+	}
+
+	ws, _ := dialWebSocket(t, server, "/ws?token=secret", "")
+	ws.send(t, websocketFrame(1, echo))
+	if _, document := ws.receiveFrame(t); document.Security == nil || document.Security.VerifyCode != "secret" {
+		t.Errorf("documents must inherit the security of the connection: %+v", document)
+	}
+
+	ws, _ = dialWebSocket(t, server, "/ws", "")
+	ws.send(t, websocketFrame(1, echo))
+	if _, document := ws.receiveFrame(t); !strings.Contains(fmt.Sprint(document.Error), "authentication required") {
+		t.Errorf("unauthenticated frames must be refused: %+v", document)
+	}
+	if opcode, _ := ws.receive(t); opcode != wsClose {
+		t.Errorf("the connection must be closed, got opcode %d", opcode)
+	}
+
+	ws, _ = dialWebSocket(t, server, "/ws", "")
+	ws.send(t, websocketFrame(1, model.Document{Type: constants.DOC_TYPE_AUTH, Security: &model.Security{VerifyCode: "secret"}}))
+	if frame, document := ws.receiveFrame(t); frame.ID != 1 || document.Type != constants.DOC_TYPE_AUTH {
+		t.Fatalf("authentication was not acknowledged: %+v", document)
+	}
+	ws.send(t, websocketFrame(2, echo))
+	if _, document := ws.receiveFrame(t); document.Type != constants.DOC_TYPE_RESULT || document.Security.VerifyCode != "secret" {
+		t.Errorf("unexpected response after authentication: %+v", document)
+	}
+}
@@ -18,6 +18,14 @@ type Transaction[T any] interface {
 	SetupTransaction() error
 }
 
+// StreamingTransaction is implemented by transactions that produce their output progressively,
+// e.g. search hits or report rows. TransactStream is called instead of Transact and passes every
+// partial output to emit; GetResponse becomes the output of the terminal document. A non-nil
+// error from emit means the caller is gone and the transaction should stop.
+type StreamingTransaction interface {
+	TransactStream(emit func(output any) error) error
+}
+
 type TransactionBucketItemInterface interface {
 	GetName() string
 	GetTransaction() model.ServerInterface
@@ -1,8 +1,8 @@
 package utilities
 
 import (
-	"encoding"
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"strings"
 )
@@ -10,112 +10,127 @@ import (
 type StructVariable map[string]interface{}
 type SliceVariable []interface{}
 
-// hasCustomJSONMarshaling reports whether the type or its pointer implements
-// json.Marshaler or encoding.TextMarshaler (or explicitly defines MarshalJSON).
-// This helps us classify types like UUID as primitive JSON types (e.g., String)
-// instead of falling back to their underlying Go kind (e.g., array/slice of bytes).
-func hasCustomJSONMarshaling(t reflect.Type) bool {
-	if t == nil {
-		return false
-	}
-	jsonMarshaler := reflect.TypeOf((*json.Marshaler)(nil)).Elem()
-	textMarshaler := reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
-
-	// Check the type itself and also its pointer form to catch pointer receiver methods.
-	if t.Implements(jsonMarshaler) || t.Implements(textMarshaler) {
-		return true
+// Analysis describes the JSON shape of variable for the help pages, following encoding/json:
+// structs become a StructVariable keyed by JSON names (embedded structs flattened, `json:"-"`
+// skipped), slices and arrays a SliceVariable with one element, maps {"string": [value]}, and
+// everything else one of "string", "integer", "number", "boolean", "string(date-time)" or "any".
+// Interfaces are described by the value they hold, if any.
+//
+// nestedTypes is the stack of structs being described, by fully qualified type name. A struct
+// that contains itself is written as "<- Parent", with one "<" per level up.
+func Analysis(variable interface{}, nestedTypes *[]string) interface{} {
+	if nestedTypes == nil {
+		nestedTypes = &[]string{}
 	}
-	if t.Kind() != reflect.Ptr {
-		pt := reflect.PointerTo(t)
-		if pt.Implements(jsonMarshaler) || pt.Implements(textMarshaler) {
-			return true
-		}
+	v := reflect.ValueOf(variable)
+	if !v.IsValid() {
+		return "any"
 	}
+	return analyse(v.Type(), v, nestedTypes)
+}
 
-	// Fallback explicit method presence check for MarshalJSON by name.
-	if _, ok := t.MethodByName("MarshalJSON"); ok {
-		return true
-	}
-	if t.Kind() != reflect.Ptr {
-		pt := reflect.PointerTo(t)
-		if _, ok := pt.MethodByName("MarshalJSON"); ok {
-			return true
+// analyse describes t; v is a value of t when known and invalid otherwise.
+func analyse(t reflect.Type, v reflect.Value, nestedTypes *[]string) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if v.IsValid() && !v.IsNil() {
+			v = v.Elem()
+		} else {
+			v = reflect.Value{}
 		}
 	}
-	return false
-}
-
-func Analysis(variable interface{}, nestedTypes *[]string) interface{} {
-
-	typeOf := reflect.TypeOf(variable)
-	valueOf := reflect.ValueOf(variable)
-	var output interface{}
-	if typeOf == nil {
-		return "any"
+	if t == timeType {
+		return "string(date-time)"
 	}
-
-	// If the type has custom (JSON/Text) marshaling, prefer analyzing its marshaled JSON shape.
-	if hasCustomJSONMarshaling(typeOf) {
-		byteData, _ := json.Marshal(variable)
-		output = strings.Trim(MarshalJSONAnalysis(byteData), "\"")
-		return output
+	if implementsAny(t, jsonMarshalerType) {
+		return marshaledAnalysis(t)
+	}
+	if implementsAny(t, textMarshalerType) {
+		return "string"
 	}
 
-	switch typeOf.Kind() {
+	switch t.Kind() {
+	case reflect.Interface:
+		if v.IsValid() && !v.IsNil() {
+			return analyse(v.Elem().Type(), v.Elem(), nestedTypes)
+		}
+		return "any"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 && !implementsAny(t.Elem(), jsonMarshalerType, textMarshalerType) {
+			// base64
+			return "string"
+		}
+		var elem reflect.Value
+		if v.IsValid() && v.Len() > 0 {
+			elem = v.Index(0)
+		}
+		return SliceVariable{analyse(t.Elem(), elem, nestedTypes)}
+	case reflect.Map:
+		// JSON object keys are always strings
+		return map[string][]interface{}{"string": {analyse(t.Elem(), reflect.Value{}, nestedTypes)}}
 	case reflect.Struct:
+		identity := typeIdentity(t)
+		for i := len(*nestedTypes) - 1; i >= 0; i-- {
+			if (*nestedTypes)[i] == identity {
+				return strings.Repeat("<", len(*nestedTypes)-i) + "- Parent"
+			}
+		}
+		*nestedTypes = append(*nestedTypes, identity)
+		defer func() { *nestedTypes = (*nestedTypes)[:len(*nestedTypes)-1] }()
+
 		structVar := StructVariable{}
-		(*nestedTypes) = append(*nestedTypes, typeOf.Name())
-	NEXTLOOP:
-		for i := 0; i < valueOf.NumField(); i++ {
-			f := valueOf.Field(i)
-			ft := typeOf.Field(i)
-			tagOption, _ := ParseTagToTransactionExchangeTag(string(ft.Tag))
-			switch f.Type().Kind() {
-			case reflect.Map, reflect.Slice, reflect.Ptr:
-				backIcon := strings.Builder{}
-				for i := len(*nestedTypes) - 1; i >= 0; i-- {
-					item := (*nestedTypes)[i]
-					backIcon.WriteString("<")
-					if strings.Compare(ft.Type.Elem().Name(), item) == 0 {
-						structVar[tagOption.FieldRawname] = backIcon.String() + "- Parent"
-						continue NEXTLOOP
-					}
-				}
+		for _, f := range JsonFields(t) {
+			if f.Quoted {
+				structVar[f.Name] = "string"
+				continue
 			}
-			if f.CanInterface() {
-				structVar[tagOption.FieldRawname] = Analysis(f.Interface(), nestedTypes)
-			} else if f.CanAddr() {
-				structVar[tagOption.FieldRawname] = Analysis(f.Addr().Interface(), nestedTypes)
-			} else {
-				structVar[tagOption.FieldRawname] = Analysis(reflect.New(f.Type()).Interface(), nestedTypes)
+			var fv reflect.Value
+			if v.IsValid() {
+				// fails on nil embedded pointers, leaving the value unknown
+				fv, _ = v.FieldByIndexErr(f.Index)
 			}
+			structVar[f.Name] = analyse(f.Field.Type, fv, nestedTypes)
 		}
-		output = structVar
-		if len(*nestedTypes) > 0 {
-			*nestedTypes = (*nestedTypes)[:len(*nestedTypes)-1]
-		}
-	case reflect.String:
-		output = valueOf.Type().Kind().String()
-	case reflect.Ptr:
-		lastElemType := typeOf.Elem()
-		for ok := lastElemType.Kind() == reflect.Ptr; ok; ok = lastElemType.Kind() == reflect.Ptr {
-			lastElemType = lastElemType.Elem()
-		}
-		output = Analysis(reflect.New(lastElemType).Elem().Interface(), nestedTypes)
-	case reflect.Slice:
-		var sliceMap []interface{}
-		sliceMap = append(sliceMap, Analysis(reflect.New(typeOf.Elem()).Interface(), nestedTypes))
-		output = sliceMap
-	case reflect.Map:
-		mapData := make(map[string][]interface{})
-		mapData[valueOf.Type().Key().String()] = append(mapData[valueOf.Type().Key().String()], Analysis(reflect.New(valueOf.Type().Elem()).Interface(), nestedTypes))
-		output = mapData
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
-		output = "Number"
+		return structVar
 	default:
-		output = typeOf.Kind().String()
+		// funcs, channels and complex numbers cannot be encoded
+		return "any"
+	}
+}
+
+// marshaledAnalysis describes a type with its own MarshalJSON by the zero value's encoding.
+func marshaledAnalysis(t reflect.Type) interface{} {
+	switch marshaledSchema(t)["type"] {
+	case "string":
+		return "string"
+	case "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		return SliceVariable{"any"}
+	case "object":
+		return StructVariable{}
+	default:
+		return "any"
 	}
-	return output
+}
+
+// typeIdentity is the fully qualified name of t, e.g. "github.com/godispatcher/dispatcher/model.Document".
+func typeIdentity(t reflect.Type) string {
+	if t.Name() != "" && t.PkgPath() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+	return t.String()
 }
 
 func MarshalJSONAnalysis(byteData []byte) string {
@@ -140,3 +155,172 @@ func MarshalJSONAnalysis(byteData []byte) string {
 		return "Unknown"
 	}
 }
+
+// FieldDoc documents one field of a request or response type.
+type FieldDoc struct {
+	Path        string   `json:"path" yaml:"path"`
+	Type        string   `json:"type" yaml:"type"`
+	Required    bool     `json:"required,omitempty" yaml:"required,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Example     string   `json:"example,omitempty" yaml:"example,omitempty"`
+	Enum        []string `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Deprecated  bool     `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	Sensitive   bool     `json:"sensitive,omitempty" yaml:"sensitive,omitempty"`
+}
+
+// DescribeFields lists the fields of v as encoding/json writes them, together with their
+// validation and documentation tags. Nested fields get dotted paths, "[]" marks array
+// elements and "{}" map values; a type that contains itself is described once.
+func DescribeFields(v interface{}) []FieldDoc {
+	var docs []FieldDoc
+	describeFields(reflect.TypeOf(v), "", map[reflect.Type]bool{}, &docs)
+	return docs
+}
+
+func describeFields(t reflect.Type, prefix string, visiting map[reflect.Type]bool, docs *[]FieldDoc) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || t == timeType || implementsAny(t, jsonMarshalerType, textMarshalerType) || visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for _, f := range JsonFields(t) {
+		tagOption, _ := ParseTagToTransactionExchangeTag(string(f.Field.Tag))
+		path := prefix + f.Name
+		*docs = append(*docs, FieldDoc{
+			Path:        path,
+			Type:        fieldTypeName(f, tagOption),
+			Required:    tagOption.Require != nil && *tagOption.Require,
+			Description: tagOption.Description,
+			Example:     tagOption.Example,
+			Enum:        tagOption.Enum,
+			Deprecated:  tagOption.Deprecated,
+			Sensitive:   tagOption.Sensitive,
+		})
+
+		ft := f.Field.Type
+		for {
+			switch {
+			case ft.Kind() == reflect.Ptr:
+				ft = ft.Elem()
+				continue
+			case (ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array) && ft.Elem().Kind() != reflect.Uint8:
+				path += "[]"
+				ft = ft.Elem()
+				continue
+			case ft.Kind() == reflect.Map:
+				path += "{}"
+				ft = ft.Elem()
+				continue
+			}
+			break
+		}
+		describeFields(ft, path+".", visiting, docs)
+	}
+}
+
+// fieldTypeName returns the JSON type of a field, e.g. "integer", "string(date-time)" or "object[]".
+func fieldTypeName(f JsonField, tagOption TransactionExchangeTag) string {
+	if f.Quoted {
+		return "string"
+	}
+	schema := NewSchemaGenerator("").TypeSchema(f.Field.Type)
+	suffix := ""
+	for schema["type"] == "array" {
+		items, _ := schema["items"].(Schema)
+		schema = items
+		suffix += "[]"
+	}
+	name, ok := schema["type"].(string)
+	switch {
+	case schema["$ref"] != nil:
+		name = "object"
+	case !ok:
+		name = "any"
+	}
+	format, _ := schema["format"].(string)
+	if tagOption.Format != "" && suffix == "" {
+		format = tagOption.Format
+	}
+	if format != "" {
+		name += "(" + format + ")"
+	}
+	return name + suffix
+}
+
+// SampleValue builds a value shaped like v's JSON encoding, to pre-fill request editors.
+// Fields take their example tag when present and zero values otherwise; arrays hold one
+// element and a type that contains itself is cut off with null.
+func SampleValue(v interface{}) interface{} {
+	return sampleValue(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func sampleValue(t reflect.Type, visiting map[reflect.Type]bool) interface{} {
+	if t == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return "2006-01-02T15:04:05Z"
+	}
+	if implementsAny(t, jsonMarshalerType, textMarshalerType) {
+		b, err := json.Marshal(reflect.New(t).Interface())
+		if err != nil {
+			return nil
+		}
+		var out interface{}
+		_ = json.Unmarshal(b, &out)
+		return out
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return false
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return 0
+	case reflect.String:
+		return ""
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return ""
+		}
+		return []interface{}{sampleValue(t.Elem(), visiting)}
+	case reflect.Map:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if visiting[t] {
+			return nil
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		out := map[string]interface{}{}
+		for _, f := range JsonFields(t) {
+			var value interface{}
+			tagOption, _ := ParseTagToTransactionExchangeTag(string(f.Field.Tag))
+			switch {
+			case tagOption.Example != "":
+				value = tagOption.Example
+				// examples of non-string fields are JSON, e.g. example:"30" or example:"[1,2]"
+				var decoded interface{}
+				if schema := NewSchemaGenerator("").TypeSchema(f.Field.Type); schema["type"] != "string" && !f.Quoted &&
+					json.Unmarshal([]byte(tagOption.Example), &decoded) == nil {
+					value = decoded
+				}
+			case f.Quoted:
+				value = fmt.Sprint(sampleValue(f.Field.Type, visiting))
+			default:
+				value = sampleValue(f.Field.Type, visiting)
+			}
+			out[f.Name] = value
+		}
+		return out
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,107 @@
+package utilities_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/model"
+	"github.com/godispatcher/dispatcher/utilities"
+)
+
+type analysisBase struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type analysisLabels map[string]float32
+
+// Document shares its name with model.Document, which must not be taken for recursion.
+type Document struct {
+	analysisBase
+	Inner    model.Document `json:"inner"`
+	Children []*Document    `json:"children"`
+	Ratio    float64        `json:"ratio"`
+	Count    uint8          `json:"count,string"`
+	Active   bool
+	Skipped  string         `json:"-"`
+	Payload  interface{}    `json:"payload"`
+	Filled   interface{}    `json:"filled"`
+	Point    [2]int         `json:"point"`
+	Labels   analysisLabels `json:"labels"`
+	Raw      []byte         `json:"raw"`
+	Level    schemaLevel    `json:"level"`
+	secret   string
+}
+
+func TestAnalysis(t *testing.T) {
+	got := utilities.Analysis(Document{Filled: []string{"a"}}, &[]string{})
+	b, _ := json.Marshal(got)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"id":         "integer",
+		"created_at": "string(date-time)",
+		"children":   []interface{}{"<- Parent"},
+		"ratio":      "number",
+		"count":      "string",
+		"Active":     "boolean",
+		"payload":    "any",
+		"filled":     []interface{}{"string"},
+		"point":      []interface{}{"integer"},
+		"labels":     map[string]interface{}{"string": []interface{}{"number"}},
+		"raw":        "string",
+		"level":      "string",
+	}
+	for key, value := range want {
+		if !reflect.DeepEqual(decoded[key], value) {
+			t.Errorf("%s: got %#v want %#v", key, decoded[key], value)
+		}
+	}
+	for _, key := range []string{"Skipped", "secret", "analysisBase", ""} {
+		if _, ok := decoded[key]; ok {
+			t.Errorf("%q must not be listed", key)
+		}
+	}
+
+	inner := decoded["inner"].(map[string]interface{})
+	if !reflect.DeepEqual(inner["dispatchings"], []interface{}{"<- Parent"}) {
+		t.Errorf("model.Document recursion: got %#v", inner["dispatchings"])
+	}
+	if _, ok := inner["department"]; !ok {
+		t.Errorf("model.Document must be described, not taken for its namesake: %#v", inner)
+	}
+	if got := utilities.Analysis(nil, nil); got != "any" {
+		t.Errorf("nil: got %#v", got)
+	}
+}
+
+type sampleLogin struct {
+	Email    string `json:"email" require:"true" example:"jane@example.com"`
+	Password string `json:"password" require:"true" sensitive:"true"`
+	Remember int    `json:"remember,omitempty" example:"30"`
+	Legacy   string `json:"legacy,omitempty" deprecated:"true"`
+}
+
+type sampleNode struct {
+	Name     string        `json:"name"`
+	Children []*sampleNode `json:"children,omitempty"`
+}
+
+func TestSampleValue(t *testing.T) {
+	sample := utilities.SampleValue(sampleLogin{}).(map[string]interface{})
+	want := map[string]interface{}{"email": "jane@example.com", "password": "", "remember": float64(30), "legacy": ""}
+	for key, value := range want {
+		if sample[key] != value {
+			t.Errorf("%s: got %#v want %#v", key, sample[key], value)
+		}
+	}
+	node := utilities.SampleValue(sampleNode{}).(map[string]interface{})
+	if children := node["children"].([]interface{}); children[0] != nil {
+		t.Errorf("recursive sample must stop at the repeated type: %v", node)
+	}
+}
@@ -0,0 +1,205 @@
+package utilities
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// JsonField describes a struct field as encoding/json sees it.
+type JsonField struct {
+	Name      string // JSON key
+	Field     reflect.StructField
+	Index     []int // index sequence for reflect.Value.FieldByIndex, through embedded structs
+	OmitEmpty bool
+	Quoted    bool // `json:",string"` on a scalar or string field
+	tagged    bool
+}
+
+// JsonFields returns the fields encoding/json would marshal for the struct type t, in the
+// same order and with the same rules: unexported and `json:"-"` fields are skipped,
+// untagged embedded structs are flattened and name conflicts are resolved by depth and tags.
+func JsonFields(t reflect.Type) []JsonField {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	type queued struct {
+		typ   reflect.Type
+		index []int
+	}
+	var fields []JsonField
+	current := []queued{}
+	next := []queued{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	count := map[reflect.Type]int{}
+	nextCount := map[reflect.Type]int{}
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, q := range current {
+			if visited[q.typ] {
+				continue
+			}
+			visited[q.typ] = true
+
+			for i := 0; i < q.typ.NumField(); i++ {
+				sf := q.typ.Field(i)
+				if sf.Anonymous {
+					ft := sf.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				if !isValidJsonTag(name) {
+					name = ""
+				}
+				index := make([]int, len(q.index)+1)
+				copy(index, q.index)
+				index[len(q.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					tagged := name != ""
+					if name == "" {
+						name = sf.Name
+					}
+					// like encoding/json, ",string" only applies to scalar and string fields
+					quoted := false
+					if hasTagOption(opts, "string") {
+						switch ft.Kind() {
+						case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+							reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+							reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+							quoted = true
+						}
+					}
+					field := JsonField{
+						Name:      name,
+						Field:     sf,
+						Index:     index,
+						OmitEmpty: hasTagOption(opts, "omitempty"),
+						Quoted:    quoted,
+						tagged:    tagged,
+					}
+					fields = append(fields, field)
+					if count[q.typ] > 1 {
+						// The type was embedded more than once at this depth; add a duplicate
+						// so the dominance check below drops the ambiguous name.
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, queued{typ: ft, index: index})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		x := fields
+		if x[i].Name != x[j].Name {
+			return x[i].Name < x[j].Name
+		}
+		if len(x[i].Index) != len(x[j].Index) {
+			return len(x[i].Index) < len(x[j].Index)
+		}
+		if x[i].tagged != x[j].tagged {
+			return x[i].tagged
+		}
+		return indexLess(x[i].Index, x[j].Index)
+	})
+
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		fi := fields[i]
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].Name != fi.Name {
+				break
+			}
+		}
+		if advance == 1 {
+			out = append(out, fi)
+			continue
+		}
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+	fields = out
+
+	sort.Slice(fields, func(i, j int) bool {
+		return indexLess(fields[i].Index, fields[j].Index)
+	})
+	return fields
+}
+
+// dominantField picks the field that wins among fields sharing a JSON name:
+// the shallowest one, preferring tagged fields; a tie means the name is dropped.
+func dominantField(fields []JsonField) (JsonField, bool) {
+	if len(fields) > 1 && len(fields[0].Index) == len(fields[1].Index) && fields[0].tagged == fields[1].tagged {
+		return JsonField{}, false
+	}
+	return fields[0], true
+}
+
+func indexLess(a, b []int) bool {
+	for k, xik := range a {
+		if k >= len(b) {
+			return false
+		}
+		if xik != b[k] {
+			return xik < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func hasTagOption(opts, option string) bool {
+	for opts != "" {
+		var name string
+		name, opts, _ = strings.Cut(opts, ",")
+		if name == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidJsonTag mirrors encoding/json's check for usable tag names.
+func isValidJsonTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
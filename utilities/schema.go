@@ -0,0 +1,257 @@
+package utilities
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JsonSchemaDialect is the $schema of the documents produced by JsonSchema.
+const JsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema object.
+type Schema map[string]interface{}
+
+// SchemaGenerator builds JSON Schemas from Go types following encoding/json rules.
+// Named struct types are emitted once into Definitions and referenced with RefPrefix,
+// which keeps recursive types finite.
+type SchemaGenerator struct {
+	RefPrefix   string // "#/$defs/" by default, "#/components/schemas/" for OpenAPI
+	Definitions map[string]Schema
+	// Output describes marshaled values: fields without omitempty are always written,
+	// so they are listed as required as well.
+	Output bool
+	names  map[reflect.Type]string
+}
+
+func NewSchemaGenerator(refPrefix string) *SchemaGenerator {
+	if refPrefix == "" {
+		refPrefix = "#/$defs/"
+	}
+	return &SchemaGenerator{
+		RefPrefix:   refPrefix,
+		Definitions: map[string]Schema{},
+		names:       map[reflect.Type]string{},
+	}
+}
+
+// JsonSchema returns a standalone draft 2020-12 document for the dynamic type of v.
+// Set output when the schema describes a response rather than a request.
+func JsonSchema(v interface{}, output bool) Schema {
+	g := NewSchemaGenerator("")
+	g.Output = output
+	schema := copySchema(g.Generate(v))
+	schema["$schema"] = JsonSchemaDialect
+	if len(g.Definitions) > 0 {
+		schema["$defs"] = g.Definitions
+	}
+	return schema
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	definitionNameRe  = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+)
+
+// Generate returns the schema of the dynamic type of v; nil yields an empty (any) schema.
+func (g *SchemaGenerator) Generate(v interface{}) Schema {
+	return g.TypeSchema(reflect.TypeOf(v))
+}
+
+// TypeSchema returns the schema of t, registering named structs in Definitions.
+func (g *SchemaGenerator) TypeSchema(t reflect.Type) Schema {
+	if t == nil {
+		return Schema{}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return Schema{"type": "string", "format": "date-time"}
+	}
+	if implementsAny(t, jsonMarshalerType) {
+		return marshaledSchema(t)
+	}
+	if implementsAny(t, textMarshalerType) {
+		return Schema{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && !implementsAny(t.Elem(), jsonMarshalerType, textMarshalerType) {
+			return Schema{"type": "string", "contentEncoding": "base64"}
+		}
+		return Schema{"type": "array", "items": g.TypeSchema(t.Elem())}
+	case reflect.Array:
+		return Schema{"type": "array", "items": g.TypeSchema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": g.TypeSchema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.definitionName(t)
+			g.names[t] = name
+			// reserve the name before descending so recursive references resolve to it
+			g.Definitions[name] = Schema{}
+			g.Definitions[name] = g.structSchema(t)
+		}
+		return Schema{"$ref": g.RefPrefix + name}
+	default:
+		// interfaces, funcs, channels: anything goes
+		return Schema{}
+	}
+}
+
+func (g *SchemaGenerator) structSchema(t reflect.Type) Schema {
+	properties := Schema{}
+	var required []string
+	for _, f := range JsonFields(t) {
+		property := g.TypeSchema(f.Field.Type)
+		if f.Quoted {
+			property = Schema{"type": "string"}
+		}
+		tagOption, _ := ParseTagToTransactionExchangeTag(string(f.Field.Tag))
+		if (tagOption.Require != nil && *tagOption.Require) || (g.Output && !f.OmitEmpty) {
+			required = append(required, f.Name)
+		}
+		if tagOption.IsEmpty != nil && !*tagOption.IsEmpty && property["type"] == "string" {
+			property = copySchema(property)
+			property["minLength"] = 1
+		}
+		if tagOption.Format != "" {
+			property = copySchema(property)
+			property["format"] = tagOption.Format
+		}
+		if len(tagOption.Enum) > 0 {
+			property = copySchema(property)
+			property["enum"] = enumValues(tagOption.Enum, property["type"] != "string")
+		}
+		properties[f.Name] = withDocumentation(property, tagOption)
+	}
+	schema := Schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// withDocumentation adds the description, example, deprecated and sensitive tags to a property.
+// Examples of non-string properties are decoded as JSON when possible.
+func withDocumentation(property Schema, tagOption TransactionExchangeTag) Schema {
+	if tagOption.Description == "" && tagOption.Example == "" && !tagOption.Deprecated && !tagOption.Sensitive {
+		return property
+	}
+	property = copySchema(property)
+	if tagOption.Description != "" {
+		property["description"] = tagOption.Description
+	}
+	if tagOption.Example != "" {
+		var example interface{} = tagOption.Example
+		if property["type"] != "string" {
+			var decoded interface{}
+			if json.Unmarshal([]byte(tagOption.Example), &decoded) == nil {
+				example = decoded
+			}
+		}
+		property["examples"] = []interface{}{example}
+	}
+	if tagOption.Deprecated {
+		property["deprecated"] = true
+	}
+	if tagOption.Sensitive {
+		property["x-sensitive"] = true
+	}
+	return property
+}
+
+// enumValues returns the enum tag values; for non-string properties they are decoded as JSON.
+func enumValues(enum []string, decode bool) []interface{} {
+	values := make([]interface{}, len(enum))
+	for i, value := range enum {
+		values[i] = value
+		var decoded interface{}
+		if decode && json.Unmarshal([]byte(value), &decoded) == nil {
+			values[i] = decoded
+		}
+	}
+	return values
+}
+
+// marshaledSchema infers the JSON type written by a custom MarshalJSON from the zero value.
+func marshaledSchema(t reflect.Type) (schema Schema) {
+	defer func() {
+		if recover() != nil {
+			schema = Schema{}
+		}
+	}()
+	b, err := json.Marshal(reflect.New(t).Interface())
+	if err != nil {
+		return Schema{}
+	}
+	switch MarshalJSONAnalysis(b) {
+	case "String":
+		return Schema{"type": "string"}
+	case "Number":
+		return Schema{"type": "number"}
+	case "Boolean":
+		return Schema{"type": "boolean"}
+	case "Array":
+		return Schema{"type": "array"}
+	case "Object":
+		return Schema{"type": "object"}
+	default:
+		// null or unknown: the shape depends on the value
+		return Schema{}
+	}
+}
+
+// definitionName returns a unique, JSON-pointer safe name such as "model.Security".
+func (g *SchemaGenerator) definitionName(t reflect.Type) string {
+	base := t.Name()
+	if pkg := t.PkgPath(); pkg != "" {
+		base = pkg[strings.LastIndex(pkg, "/")+1:] + "." + base
+	}
+	base = strings.Trim(definitionNameRe.ReplaceAllString(base, "_"), "_")
+	name := base
+	for i := 2; ; i++ {
+		if _, taken := g.Definitions[name]; !taken {
+			return name
+		}
+		name = base + "_" + strconv.Itoa(i)
+	}
+}
+
+func implementsAny(t reflect.Type, ifaces ...reflect.Type) bool {
+	for _, iface := range ifaces {
+		if t.Implements(iface) || (t.Kind() != reflect.Ptr && reflect.PointerTo(t).Implements(iface)) {
+			return true
+		}
+	}
+	return false
+}
+
+func copySchema(s Schema) Schema {
+	out := make(Schema, len(s))
+	for k, v := range s {
+		out[k] = v
+	}
+	return out
+}
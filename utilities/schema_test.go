@@ -0,0 +1,94 @@
+package utilities_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/godispatcher/dispatcher/utilities"
+)
+
+type schemaLevel int
+
+func (schemaLevel) MarshalJSON() ([]byte, error) { return json.Marshal("debug") }
+
+type schemaAudit struct {
+	CreatedBy string `json:"created_by"`
+	Name      string
+}
+
+type schemaOwner struct {
+	Name string
+}
+
+type schemaItem struct {
+	schemaAudit
+	*schemaOwner
+	ID       int64              `json:"id,string" require:"true"`
+	Email    string             `json:"email" format:"email" isEmpty:"false"`
+	At       time.Time          `json:"at"`
+	Level    schemaLevel        `json:"level"`
+	Tags     map[string]float64 `json:"tags,omitempty"`
+	Raw      []byte             `json:"raw,omitempty"`
+	Parent   *schemaItem        `json:"parent,omitempty"`
+	Audit    schemaAudit        `json:"audit,string"`
+	Internal string             `json:"-"`
+	NoTag    bool
+}
+
+func TestJsonFields(t *testing.T) {
+	var names []string
+	for _, f := range utilities.JsonFields(reflect.TypeOf(schemaItem{})) {
+		names = append(names, f.Name)
+		if f.Name == "audit" && f.Quoted {
+			t.Errorf(`",string" must be ignored on struct fields`)
+		}
+		if f.Name == "id" && !f.Quoted {
+			t.Errorf(`",string" must apply to integer fields`)
+		}
+	}
+	// Name is embedded twice at the same depth, so encoding/json drops it
+	want := []string{"created_by", "id", "email", "at", "level", "tags", "raw", "parent", "audit", "NoTag"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got fields %v want %v", names, want)
+	}
+}
+
+func TestJsonSchema(t *testing.T) {
+	schema := utilities.JsonSchema(schemaItem{}, false)
+	if schema["$schema"] != utilities.JsonSchemaDialect || schema["$ref"] != "#/$defs/utilities_test.schemaItem" {
+		t.Fatalf("unexpected root: %v", schema)
+	}
+	item := schema["$defs"].(map[string]utilities.Schema)["utilities_test.schemaItem"]
+	properties := item["properties"].(utilities.Schema)
+
+	cases := map[string]utilities.Schema{
+		"id":     {"type": "string"},
+		"email":  {"type": "string", "format": "email", "minLength": 1},
+		"at":     {"type": "string", "format": "date-time"},
+		"level":  {"type": "string"},
+		"tags":   {"type": "object", "additionalProperties": utilities.Schema{"type": "number"}},
+		"raw":    {"type": "string", "contentEncoding": "base64"},
+		"parent": {"$ref": "#/$defs/utilities_test.schemaItem"},
+		"audit":  {"$ref": "#/$defs/utilities_test.schemaAudit"},
+		"NoTag":  {"type": "boolean"},
+	}
+	for name, want := range cases {
+		if got, _ := properties[name].(utilities.Schema); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v want %v", name, properties[name], want)
+		}
+	}
+	if len(properties) != 10 {
+		t.Errorf("unexpected properties: %v", properties)
+	}
+	if !reflect.DeepEqual(item["required"], []string{"id"}) {
+		t.Errorf("request schema required: got %v", item["required"])
+	}
+
+	output := utilities.JsonSchema(schemaItem{}, true)
+	item = output["$defs"].(map[string]utilities.Schema)["utilities_test.schemaItem"]
+	if required := item["required"].([]string); len(required) != 7 {
+		t.Errorf("fields without omitempty must be required in output schemas: %v", required)
+	}
+}
@@ -1,21 +1,32 @@
 package utilities
 
 import (
+	"reflect"
 	"strings"
 
 	"github.com/godispatcher/dispatcher/constants"
 )
 
 type TransactionExchangeTag struct {
-	Require      *bool  `json:"require"`
-	IsEmpty      *bool  `json:"is_empty"`
-	FieldRawname string `json:"field_raw_name"`
+	Require      *bool    `json:"require"`
+	IsEmpty      *bool    `json:"is_empty"`
+	FieldRawname string   `json:"field_raw_name"`
+	Format       string   `json:"format,omitempty"`
+	Enum         []string `json:"enum,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	Example      string   `json:"example,omitempty"`
+	Deprecated   bool     `json:"deprecated,omitempty"`
+	Sensitive    bool     `json:"sensitive,omitempty"`
 }
 
 func ParseTagToTransactionExchangeTag(tag string) (result TransactionExchangeTag, err error) {
 	options := strings.Split(tag, " ")
 	for _, val := range options {
 		optionDetail := strings.Split(val, ":")
+		if len(optionDetail) < 2 {
+			// a word of a quoted documentation tag
+			continue
+		}
 		switch optionDetail[0] {
 		case constants.OPTION_REQUIRE:
 			{
@@ -43,8 +54,30 @@ func ParseTagToTransactionExchangeTag(tag string) (result TransactionExchangeTag
 				optionDetailArgument := strings.Split(optionDetail[1], ",")
 				result.FieldRawname = optionDetailArgument[0]
 			}
+		case constants.OPTION_FORMAT:
+			{
+				result.Format = strings.Trim(optionDetail[1], "\"")
+			}
 		}
 	}
 
+	// documentation tags may contain spaces, so they are read with the standard tag syntax
+	structTag := reflect.StructTag(tag)
+	result.Description = structTag.Get(constants.OPTION_DESCRIPTION)
+	result.Example = structTag.Get(constants.OPTION_EXAMPLE)
+	result.Deprecated = isTrueTag(structTag.Get(constants.OPTION_DEPRECATED))
+	result.Sensitive = isTrueTag(structTag.Get(constants.OPTION_SENSITIVE))
+	if enum := structTag.Get(constants.OPTION_ENUM); enum != "" {
+		result.Enum = strings.Split(enum, ",")
+	}
+
 	return
 }
+
+func isTrueTag(value string) bool {
+	switch value {
+	case "true", "True", "TRUE":
+		return true
+	}
+	return false
+}